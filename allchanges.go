@@ -0,0 +1,484 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+const (
+	defaultMaxOpenFeeds   = 10
+	defaultChangesBatch   = 100
+	defaultDBUpdatesPoll  = time.Minute
+	dbUpdateTypeDeleted   = "deleted"
+	systemDBNamePrefix    = "_"
+	allChangesUpdatesName = "_db_updates"
+)
+
+// AllChangesWatcher is implemented by the client returned by this driver.
+type AllChangesWatcher interface {
+	// FollowAllChanges returns a follower of the changes to every database
+	// selected by opts. It does not connect until Run is called.
+	FollowAllChanges(opts *AllChangesOptions) *AllChangesFollower
+}
+
+var _ AllChangesWatcher = &client{}
+
+// AllChangesOptions configure FollowAllChanges.
+type AllChangesOptions struct {
+	// ConsumerID names the checkpoint kept in each database, as the
+	// _local/{ConsumerID} document. It is required.
+	ConsumerID string
+	// Pattern, if set, selects the databases whose names match it, as
+	// interpreted by path.Match.
+	Pattern string
+	// Regexp, if set, selects the databases whose names match it. If neither
+	// Pattern nor Regexp is set, all databases but the system databases,
+	// whose names start with an underscore, are selected.
+	Regexp *regexp.Regexp
+	// Options are passed to each _changes request, and may include
+	// include_docs, or a filter set with OptionChangesFilter. The feed, since
+	// and limit options are set by the follower.
+	Options map[string]interface{}
+	// Checkpoint configures how often each database's checkpoint is saved,
+	// in addition to whenever the follower has caught up with a database.
+	Checkpoint *CheckpointOptions
+	// MaxOpenFeeds is the maximum number of databases whose changes are read
+	// at once. Defaults to 10.
+	MaxOpenFeeds int
+	// BatchSize is the number of changes requested at once from a database.
+	// Defaults to 100.
+	BatchSize int
+	// PollTimeout is how long each request to _db_updates waits for an
+	// event. Defaults to one minute.
+	PollTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before retrying after an
+	// error, as for ChangesFollowerOptions.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError, if set, is called with each error which is retried, and the
+	// name of the database concerned, or "_db_updates".
+	OnError func(dbName string, err error)
+}
+
+// DBChange is a change to one of the databases followed by an
+// AllChangesFollower.
+type DBChange struct {
+	DBName string
+	driver.Change
+}
+
+// AllChangesFollower follows the changes to a set of databases, which may be
+// large. It watches _db_updates for databases which are created, updated or
+// deleted, and reads the changes to each selected database which is updated
+// from a checkpoint stored in that database, with a normal _changes request,
+// until it has caught up. At most MaxOpenFeeds databases are read at once,
+// so no connection is held open to an idle database. When it starts, every
+// selected database is read from its checkpoint.
+//
+// As with Checkpoint, changes are delivered at least once.
+type AllChangesFollower struct {
+	client *client
+	opts   AllChangesOptions
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (c *client) FollowAllChanges(opts *AllChangesOptions) *AllChangesFollower {
+	f := &AllChangesFollower{
+		client: c,
+		stop:   make(chan struct{}),
+	}
+	if opts != nil {
+		f.opts = *opts
+	}
+	if f.opts.MaxOpenFeeds <= 0 {
+		f.opts.MaxOpenFeeds = defaultMaxOpenFeeds
+	}
+	if f.opts.BatchSize <= 0 {
+		f.opts.BatchSize = defaultChangesBatch
+	}
+	if f.opts.PollTimeout <= 0 {
+		f.opts.PollTimeout = defaultDBUpdatesPoll
+	}
+	if f.opts.MinBackoff <= 0 {
+		f.opts.MinBackoff = defaultMinBackoff
+	}
+	if f.opts.MaxBackoff < f.opts.MinBackoff {
+		f.opts.MaxBackoff = defaultMaxBackoff
+		if f.opts.MaxBackoff < f.opts.MinBackoff {
+			f.opts.MaxBackoff = f.opts.MinBackoff
+		}
+	}
+	return f
+}
+
+// Stop stops the follower gracefully: Run returns nil once the changes being
+// handled have been handled, and the checkpoints saved. It is safe to call
+// Stop more than once, and from within the handler.
+func (f *AllChangesFollower) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *AllChangesFollower) stopped() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *AllChangesFollower) match(dbName string) bool {
+	if f.opts.Pattern == "" && f.opts.Regexp == nil {
+		return !strings.HasPrefix(dbName, systemDBNamePrefix)
+	}
+	if f.opts.Pattern != "" {
+		if ok, _ := path.Match(f.opts.Pattern, dbName); ok {
+			return true
+		}
+	}
+	return f.opts.Regexp != nil && f.opts.Regexp.MatchString(dbName)
+}
+
+func (f *AllChangesFollower) onError(dbName string, err error) {
+	if f.opts.OnError != nil {
+		f.opts.OnError(dbName, err)
+	}
+}
+
+type dbEvent struct {
+	dbName  string
+	deleted bool
+}
+
+type catchUpResult struct {
+	dbName string
+	err    error
+}
+
+// Run follows the changes until Stop is called, ctx is cancelled, fn returns
+// an error, or the server returns an error from _db_updates which is not
+// retried, such as when the credentials are not those of an admin. fn is
+// called concurrently for changes to different databases, and in order for
+// the changes to each database. A change is recorded in its database's
+// checkpoint once fn returns nil. Run returns once the checkpoints of all the
+// databases being read have been saved.
+func (f *AllChangesFollower) Run(ctx context.Context, fn func(*DBChange) error) error {
+	if f.opts.ConsumerID == "" {
+		return missingArg("ConsumerID")
+	}
+	if _, err := path.Match(f.opts.Pattern, ""); err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	workCtx, cancelWork := context.WithCancel(ctx)
+	defer cancelWork()
+	watchCtx, cancelWatch := context.WithCancel(workCtx)
+	defer cancelWatch()
+
+	events := make(chan dbEvent)
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- f.watchUpdates(watchCtx, events) }()
+	retries := make(chan string)
+	done := make(chan catchUpResult)
+
+	var queue []string
+	queued := map[string]bool{}
+	active := map[string]bool{}
+	dirty := map[string]bool{}
+	enqueue := func(dbName string) {
+		switch {
+		case active[dbName]:
+			dirty[dbName] = true
+		case !queued[dbName]:
+			queued[dbName] = true
+			queue = append(queue, dbName)
+		}
+	}
+
+	var stopping bool
+	var result error
+	stopWith := func(err error) {
+		if !stopping {
+			stopping, result = true, err
+			cancelWatch()
+		}
+	}
+	stop, ctxDone := f.stop, ctx.Done()
+	for {
+		for !stopping && len(active) < f.opts.MaxOpenFeeds && len(queue) > 0 {
+			dbName := queue[0]
+			queue = queue[1:]
+			if !queued[dbName] {
+				// Removed from the queue when the database was deleted.
+				continue
+			}
+			delete(queued, dbName)
+			active[dbName] = true
+			go func() {
+				done <- catchUpResult{dbName: dbName, err: f.catchUp(workCtx, dbName, fn)}
+			}()
+		}
+		if stopping && len(active) == 0 {
+			return result
+		}
+		select {
+		case ev := <-events:
+			if stopping || ev.dbName == "" || !f.match(ev.dbName) {
+				continue
+			}
+			if ev.deleted {
+				delete(queued, ev.dbName)
+				delete(dirty, ev.dbName)
+				continue
+			}
+			enqueue(ev.dbName)
+		case dbName := <-retries:
+			if !stopping {
+				enqueue(dbName)
+			}
+		case r := <-done:
+			delete(active, r.dbName)
+			if herr, ok := r.err.(*handlerError); ok {
+				cancelWork()
+				stopWith(herr.err)
+				continue
+			}
+			if r.err != nil && !stopping && kivik.StatusCode(r.err) != http.StatusNotFound {
+				f.onError(r.dbName, r.err)
+				go f.retryLater(watchCtx, retries, r.dbName)
+			}
+			if dirty[r.dbName] {
+				delete(dirty, r.dbName)
+				enqueue(r.dbName)
+			}
+		case err := <-watchErr:
+			watchErr = nil
+			if err != nil {
+				stopWith(err)
+			}
+		case <-stop:
+			stop = nil
+			stopWith(nil)
+		case <-ctxDone:
+			ctxDone = nil
+			stopWith(ctx.Err())
+		}
+	}
+}
+
+func (f *AllChangesFollower) retryLater(ctx context.Context, retries chan<- string, dbName string) {
+	select {
+	case <-time.After(f.opts.MinBackoff):
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case retries <- dbName:
+	case <-ctx.Done():
+	}
+}
+
+// watchUpdates sends the selected databases which exist when it starts, and
+// then those in each _db_updates event, to events, until ctx is cancelled or
+// an error which is not retried occurs.
+func (f *AllChangesFollower) watchUpdates(ctx context.Context, events chan<- dbEvent) error {
+	send := func(ev dbEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	var since string
+	backoff := f.opts.MinBackoff
+	for ctx.Err() == nil {
+		var err error
+		if since == "" {
+			// Find the current sequence before listing the databases, so
+			// that none created in between is missed.
+			if since, err = f.updatesSince(ctx); err == nil {
+				var dbNames []string
+				if dbNames, err = f.client.AllDBs(ctx, nil); err == nil {
+					for _, dbName := range dbNames {
+						if !send(dbEvent{dbName: dbName}) {
+							return nil
+						}
+					}
+				} else {
+					since = ""
+				}
+			}
+		} else {
+			since, err = f.pollUpdates(ctx, since, send)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			backoff = f.opts.MinBackoff
+			continue
+		}
+		if !retryable(err) {
+			return err
+		}
+		f.onError(allChangesUpdatesName, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > f.opts.MaxBackoff {
+			backoff = f.opts.MaxBackoff
+		}
+	}
+	return nil
+}
+
+// updatesSince returns the current _db_updates sequence.
+func (f *AllChangesFollower) updatesSince(ctx context.Context) (string, error) {
+	updates, err := f.client.DBUpdatesWithOptions(ctx, map[string]interface{}{
+		"feed":  "normal",
+		"since": "now",
+	})
+	if err != nil {
+		return "", err
+	}
+	defer updates.Close() // nolint: errcheck
+	for {
+		if err := updates.Next(&driver.DBUpdate{}); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+	}
+	return updates.(DBUpdatesReporter).LastSeq(), nil
+}
+
+// pollUpdates waits for _db_updates events after since, sends them, and
+// returns the new sequence.
+func (f *AllChangesFollower) pollUpdates(ctx context.Context, since string, send func(dbEvent) bool) (string, error) {
+	updates, err := f.client.DBUpdatesWithOptions(ctx, map[string]interface{}{
+		"feed":    "longpoll",
+		"since":   since,
+		"timeout": f.opts.PollTimeout,
+	})
+	if err != nil {
+		return since, err
+	}
+	defer updates.Close() // nolint: errcheck
+	for {
+		update := &driver.DBUpdate{}
+		err := updates.Next(update)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return since, err
+		}
+		if !send(dbEvent{dbName: update.DBName, deleted: update.Type == dbUpdateTypeDeleted}) {
+			return since, nil
+		}
+	}
+	if seq := updates.(DBUpdatesReporter).LastSeq(); seq != "" {
+		since = seq
+	}
+	return since, nil
+}
+
+// catchUp reads the changes to dbName after its checkpoint, until there are
+// none pending, and saves the checkpoint.
+func (f *AllChangesFollower) catchUp(ctx context.Context, dbName string, fn func(*DBChange) error) error {
+	d := &db{client: f.client, dbName: dbName}
+	cp := d.Checkpoint(f.opts.ConsumerID, f.opts.Checkpoint)
+	since, err := cp.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for !f.stopped() {
+		var count int
+		since, count, err = f.readBatch(ctx, d, cp, since, fn)
+		if err != nil || count < f.opts.BatchSize {
+			break
+		}
+	}
+	// The checkpoint is saved even once Run is stopping, and ctx cancelled.
+	saveCtx, cancel := context.WithTimeout(detachedContext{ctx}, checkpointSaveTimeout)
+	defer cancel()
+	if saveErr := cp.Save(saveCtx); saveErr != nil {
+		switch err.(type) {
+		case nil:
+			return saveErr
+		case *handlerError:
+			f.onError(dbName, saveErr)
+		default:
+			return &checkpointSaveError{err: err, saveErr: saveErr}
+		}
+	}
+	return err
+}
+
+// readBatch reads up to BatchSize changes to d after since, and returns the
+// sequence to continue from and the number of changes read.
+func (f *AllChangesFollower) readBatch(ctx context.Context, d *db, cp *Checkpoint, since string, fn func(*DBChange) error) (string, int, error) {
+	opts := make(map[string]interface{}, len(f.opts.Options)+2)
+	for k, v := range f.opts.Options {
+		opts[k] = v
+	}
+	delete(opts, "feed")
+	opts["limit"] = f.opts.BatchSize
+	if since != "" {
+		opts["since"] = since
+	}
+	rows, err := d.changes(ctx, opts, nil)
+	if err != nil {
+		return since, 0, err
+	}
+	defer rows.Close() // nolint: errcheck
+	var count int
+	for !f.stopped() {
+		change := &DBChange{DBName: d.dbName}
+		err := rows.Next(&change.Change)
+		if err == io.EOF {
+			if seq := rows.LastSeq(); seq != "" {
+				since = seq
+			}
+			return since, count, nil
+		}
+		if err != nil {
+			return since, count, err
+		}
+		count++
+		if err := fn(change); err != nil {
+			return since, count, &handlerError{err: err}
+		}
+		if err := cp.Mark(ctx, change.Seq); err != nil {
+			return since, count, err
+		}
+		since = change.Seq
+	}
+	return since, count, nil
+}
@@ -0,0 +1,317 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+// allChangesServer serves _db_updates, _all_dbs, and the _changes feeds and
+// checkpoints of a set of databases.
+type allChangesServer struct {
+	t *testing.T
+	// changes are the seqs of the changes to each database.
+	changes map[string][]string
+	// created is sent as a _db_updates event.
+	created string
+
+	mu          sync.Mutex
+	polls       int
+	open        int
+	maxOpen     int
+	checkpoints map[string]string
+	requested   map[string]bool
+}
+
+func (s *allChangesServer) client() *client {
+	s.checkpoints = map[string]string{}
+	s.requested = map[string]bool{}
+	return newCustomClient(func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		resp := s.handle(req)
+		if resp != nil {
+			resp.Request = req
+		}
+		return resp, nil
+	})
+}
+
+func (s *allChangesServer) handle(req *http.Request) *http.Response {
+	query := req.URL.Query()
+	switch req.URL.Path {
+	case "/_db_updates":
+		if query.Get("feed") == "normal" {
+			return jsonResponse(http.StatusOK, `{"results":[],"last_seq":"10-x"}`)
+		}
+		s.mu.Lock()
+		s.polls++
+		first := s.polls == 1
+		s.mu.Unlock()
+		if first && s.created != "" {
+			if since := query.Get("since"); since != "10-x" {
+				s.t.Errorf("Unexpected since: %s", since)
+			}
+			return jsonResponse(http.StatusOK, fmt.Sprintf(`{"results":[{"db_name":%q,"type":"created","seq":"11-y"}],"last_seq":"11-y"}`, s.created))
+		}
+		if !first {
+			<-req.Context().Done()
+		}
+		return jsonResponse(http.StatusOK, `{"results":[],"last_seq":"11-y"}`)
+	case "/_all_dbs":
+		dbNames := []string{"_users", "other"}
+		for dbName := range s.changes {
+			if dbName != s.created {
+				dbNames = append(dbNames, dbName)
+			}
+		}
+		sort.Strings(dbNames)
+		body, _ := json.Marshal(dbNames)
+		return jsonResponse(http.StatusOK, string(body))
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	dbName := parts[0]
+	s.mu.Lock()
+	s.requested[dbName] = true
+	s.mu.Unlock()
+	switch {
+	case len(parts) == 2 && parts[1] == "_local/consumer" && req.Method == http.MethodGet:
+		s.mu.Lock()
+		seq, ok := s.checkpoints[dbName]
+		s.mu.Unlock()
+		if !ok {
+			return jsonResponse(http.StatusNotFound, `{"error":"not_found","reason":"missing"}`)
+		}
+		return jsonResponse(http.StatusOK, fmt.Sprintf(`{"_id":"_local/consumer","_rev":"0-1","seq":%q}`, seq))
+	case len(parts) == 2 && parts[1] == "_local/consumer" && req.Method == http.MethodPut:
+		var doc checkpointDoc
+		if err := json.NewDecoder(req.Body).Decode(&doc); err != nil {
+			s.t.Error(err)
+		}
+		s.mu.Lock()
+		s.checkpoints[dbName] = string(doc.Seq)
+		s.mu.Unlock()
+		return jsonResponse(http.StatusCreated, `{"ok":true,"id":"_local/consumer","rev":"0-1"}`)
+	case len(parts) == 2 && parts[1] == "_changes":
+		s.mu.Lock()
+		if s.open++; s.open > s.maxOpen {
+			s.maxOpen = s.open
+		}
+		s.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		s.mu.Lock()
+		s.open--
+		s.mu.Unlock()
+		var rows []string
+		start := 0
+		if since := query.Get("since"); since != "" {
+			for i, seq := range s.changes[dbName] {
+				if seq == since {
+					start = i + 1
+				}
+			}
+		}
+		var limit int
+		_, _ = fmt.Sscan(query.Get("limit"), &limit)
+		seqs := s.changes[dbName][start:]
+		if len(seqs) > limit {
+			seqs = seqs[:limit]
+		}
+		lastSeq := query.Get("since")
+		for _, seq := range seqs {
+			rows = append(rows, fmt.Sprintf(`{"seq":%q,"id":"doc-%s","changes":[{"rev":"1-x"}]}`, seq, seq))
+			lastSeq = seq
+		}
+		return jsonResponse(http.StatusOK, fmt.Sprintf(`{"results":[%s],"last_seq":%q,"pending":%d}`, strings.Join(rows, ","), lastSeq, len(s.changes[dbName])-start-len(seqs)))
+	}
+	s.t.Errorf("Unexpected request: %s %s", req.Method, req.URL)
+	return nil
+}
+
+func TestAllChangesFollower(t *testing.T) {
+	s := &allChangesServer{
+		t: t,
+		changes: map[string][]string{
+			"tenant-a": {"1-a", "2-a", "3-a"},
+			"tenant-b": {"1-b"},
+			"tenant-c": {"1-c", "2-c"},
+		},
+		created: "tenant-c",
+	}
+	c := s.client()
+	f := c.FollowAllChanges(&AllChangesOptions{
+		ConsumerID:   "consumer",
+		Pattern:      "tenant-*",
+		MaxOpenFeeds: 2,
+		BatchSize:    2,
+	})
+	var mu sync.Mutex
+	got := map[string][]string{}
+	var count int
+	err := f.Run(context.Background(), func(change *DBChange) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[change.DBName] = append(got[change.DBName], change.Seq)
+		if count++; count == 6 {
+			f.Stop()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(s.changes, got); d != nil {
+		t.Error(d)
+	}
+	expected := map[string]string{"tenant-a": "3-a", "tenant-b": "1-b", "tenant-c": "2-c"}
+	if d := testy.DiffInterface(expected, s.checkpoints); d != nil {
+		t.Error(d)
+	}
+	if s.requested["other"] || s.requested["_users"] {
+		t.Errorf("Unselected database read")
+	}
+	if s.maxOpen > 2 {
+		t.Errorf("%d feeds open at once", s.maxOpen)
+	}
+}
+
+func TestAllChangesFollowerResume(t *testing.T) {
+	s := &allChangesServer{
+		t: t,
+		changes: map[string][]string{
+			"tenant-a": {"1-a", "2-a", "3-a"},
+		},
+	}
+	c := s.client()
+	s.checkpoints["tenant-a"] = "2-a"
+	f := c.FollowAllChanges(&AllChangesOptions{ConsumerID: "consumer"})
+	var got []string
+	err := f.Run(context.Background(), func(change *DBChange) error {
+		got = append(got, change.DBName+"/"+change.Seq)
+		f.Stop()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"tenant-a/3-a"}, got); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestAllChangesFollowerErrors(t *testing.T) {
+	t.Run("missing consumer ID", func(t *testing.T) {
+		f := newTestClient(nil, nil).FollowAllChanges(nil)
+		err := f.Run(context.Background(), nil)
+		testy.StatusError(t, "kivik: ConsumerID required", http.StatusBadRequest, err)
+	})
+	t.Run("invalid pattern", func(t *testing.T) {
+		f := newTestClient(nil, nil).FollowAllChanges(&AllChangesOptions{ConsumerID: "consumer", Pattern: "["})
+		err := f.Run(context.Background(), nil)
+		testy.StatusError(t, "syntax error in pattern", http.StatusBadRequest, err)
+	})
+	t.Run("handler error", func(t *testing.T) {
+		s := &allChangesServer{
+			t:       t,
+			changes: map[string][]string{"tenant-a": {"1-a", "2-a"}},
+		}
+		err := s.client().FollowAllChanges(&AllChangesOptions{ConsumerID: "consumer"}).Run(context.Background(), func(*DBChange) error {
+			return errors.New("index failed")
+		})
+		testy.Error(t, "index failed", err)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		s := &allChangesServer{
+			t:       t,
+			changes: map[string][]string{"tenant-a": {"1-a"}},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err := s.client().FollowAllChanges(&AllChangesOptions{ConsumerID: "consumer"}).Run(ctx, func(*DBChange) error {
+			cancel()
+			return nil
+		})
+		if err != context.Canceled {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if d := testy.DiffInterface(map[string]string{"tenant-a": "1-a"}, s.checkpoints); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("unauthorized", func(t *testing.T) {
+		c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+			resp := jsonResponse(http.StatusUnauthorized, `{"error":"unauthorized","reason":"You are not a server admin."}`)
+			resp.Request = req
+			return resp, nil
+		})
+		err := c.FollowAllChanges(&AllChangesOptions{ConsumerID: "consumer"}).Run(context.Background(), nil)
+		testy.StatusError(t, "Unauthorized", http.StatusUnauthorized, err)
+	})
+	t.Run("retried", func(t *testing.T) {
+		var mu sync.Mutex
+		var failed bool
+		s := &allChangesServer{
+			t:       t,
+			changes: map[string][]string{"tenant-a": {"1-a"}},
+		}
+		c := s.client()
+		inner := c.Client.Client.Transport
+		c.Client.Client.Transport = customTransport(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			fail := !failed && req.URL.Path == "/tenant-a/_changes"
+			failed = failed || fail
+			mu.Unlock()
+			if fail {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       ioutil.NopCloser(io.MultiReader(strings.NewReader(`{"results":[`), errReader{err: errors.New("connection reset")})),
+					Request:    req,
+				}, nil
+			}
+			return inner.RoundTrip(req)
+		})
+		var errs []string
+		f := c.FollowAllChanges(&AllChangesOptions{
+			ConsumerID: "consumer",
+			MinBackoff: time.Millisecond,
+			OnError: func(dbName string, err error) {
+				errs = append(errs, dbName+": "+err.Error())
+			},
+		})
+		err := f.Run(context.Background(), func(*DBChange) error {
+			f.Stop()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]string{"tenant-a: connection reset"}, errs); d != nil {
+			t.Error(d)
+		}
+	})
+}
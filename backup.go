@@ -0,0 +1,584 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5" // nolint: gosec
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// backupFormatVersion is the version of the archive format written by Dump.
+const backupFormatVersion = 1
+
+// Record types found in a backup archive.
+const (
+	recordHeader   = "header"
+	recordSecurity = "security"
+	recordDoc      = "doc"
+	recordLocal    = "local"
+	recordFooter   = "footer"
+)
+
+const defaultBackupBatchSize = 500
+
+// DumpRestorer is implemented by the DB handles returned by this driver. It
+// provides a portable, server-independent backup format: a gzip-compressed
+// stream of newline-delimited JSON records.
+type DumpRestorer interface {
+	// Dump writes every document (with its revision history and inline
+	// attachments), every _local document, and the security object to w.
+	Dump(ctx context.Context, w io.Writer, opts *DumpOptions) error
+	// Restore reads an archive written by Dump, and writes its contents to
+	// the database, preserving revision IDs.
+	Restore(ctx context.Context, r io.Reader, opts *RestoreOptions) error
+}
+
+var _ DumpRestorer = &db{}
+
+// BackupCheckpoint records the progress of a Dump or Restore, so that an
+// interrupted operation can be resumed.
+type BackupCheckpoint struct {
+	// LastDocID is the ID of the last document fully processed.
+	LastDocID string `json:"last_doc_id"`
+	// Records is the number of document records processed so far.
+	Records int64 `json:"records"`
+	// Offset is the number of bytes of archive written by Dump so far. To
+	// resume an interrupted Dump, truncate the archive to Offset bytes before
+	// appending to it. Restore ignores this value.
+	Offset int64 `json:"offset"`
+}
+
+// DumpOptions are optional parameters to Dump.
+type DumpOptions struct {
+	// Conflicts, when true, includes all conflicting leaf revisions of each
+	// document, not just the winning revision.
+	Conflicts bool
+
+	// BatchSize is the number of documents fetched per request. Defaults to
+	// 500.
+	BatchSize int
+
+	// Resume, if set, continues a previous Dump from the provided checkpoint.
+	// The output should be appended to the original archive, after truncating
+	// it to the checkpoint's Offset.
+	Resume *BackupCheckpoint
+
+	// Checkpoint, if set, is called after each batch of documents has been
+	// written. Returning an error aborts the dump.
+	Checkpoint func(BackupCheckpoint) error
+}
+
+// RestoreOptions are optional parameters to Restore.
+type RestoreOptions struct {
+	// BatchSize is the number of documents written per _bulk_docs request.
+	// Defaults to 500.
+	BatchSize int
+
+	// Resume, if set, skips the records already restored according to the
+	// checkpoint.
+	Resume *BackupCheckpoint
+
+	// Checkpoint, if set, is called after each batch of documents has been
+	// written. Returning an error aborts the restore.
+	Checkpoint func(BackupCheckpoint) error
+}
+
+type backupRecord struct {
+	Type      string           `json:"type"`
+	Version   int              `json:"version,omitempty"`
+	DBName    string           `json:"db_name,omitempty"`
+	UpdateSeq string           `json:"update_seq,omitempty"`
+	Security  *driver.Security `json:"security,omitempty"`
+	Doc       json.RawMessage  `json:"doc,omitempty"`
+	Records   int64            `json:"records,omitempty"`
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// backupWriter writes archive records. Each batch is written as a complete
+// gzip member, so that an archive cut short after any checkpoint can be
+// truncated and appended to.
+type backupWriter struct {
+	w          *countingWriter
+	gz         *gzip.Writer
+	enc        *json.Encoder
+	checkpoint BackupCheckpoint
+}
+
+func newBackupWriter(w io.Writer, resume *BackupCheckpoint) *backupWriter {
+	cw := &countingWriter{w: w}
+	gz := gzip.NewWriter(cw)
+	bw := &backupWriter{
+		w:   cw,
+		gz:  gz,
+		enc: json.NewEncoder(gz),
+	}
+	if resume != nil {
+		bw.checkpoint = *resume
+		cw.n = resume.Offset
+	}
+	return bw
+}
+
+func (w *backupWriter) write(rec *backupRecord) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *backupWriter) writeDoc(recType, docID string, doc json.RawMessage) error {
+	if err := w.write(&backupRecord{Type: recType, Doc: doc}); err != nil {
+		return err
+	}
+	w.checkpoint.Records++
+	w.checkpoint.LastDocID = docID
+	return nil
+}
+
+// flush completes the current gzip member, then reports the checkpoint.
+func (w *backupWriter) flush(fn func(BackupCheckpoint) error) error {
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	w.gz.Reset(w.w)
+	w.checkpoint.Offset = w.w.n
+	if fn == nil {
+		return nil
+	}
+	return fn(w.checkpoint)
+}
+
+func backupBatchSize(size int) int {
+	if size <= 0 {
+		return defaultBackupBatchSize
+	}
+	return size
+}
+
+// Dump writes a backup of the database to w.
+func (d *db) Dump(ctx context.Context, w io.Writer, opts *DumpOptions) error {
+	if w == nil {
+		return missingArg("w")
+	}
+	if opts == nil {
+		opts = &DumpOptions{}
+	}
+	bw := newBackupWriter(w, opts.Resume)
+	if opts.Resume == nil {
+		stats, err := d.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		if err := bw.write(&backupRecord{
+			Type:      recordHeader,
+			Version:   backupFormatVersion,
+			DBName:    d.dbName,
+			UpdateSeq: stats.UpdateSeq,
+		}); err != nil {
+			return err
+		}
+		sec, err := d.Security(ctx)
+		if err != nil {
+			return err
+		}
+		if err := bw.write(&backupRecord{Type: recordSecurity, Security: sec}); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(bw.checkpoint.LastDocID, "_local/") {
+		if err := d.dumpDocs(ctx, bw, opts); err != nil {
+			return err
+		}
+	}
+	if err := d.dumpLocalDocs(ctx, bw, opts); err != nil {
+		return err
+	}
+	if err := bw.write(&backupRecord{Type: recordFooter, Records: bw.checkpoint.Records}); err != nil {
+		return err
+	}
+	return bw.gz.Close()
+}
+
+// nextBatch fetches up to batchSize rows following lastID from the rows
+// function, which is expected to query _all_docs or _local_docs.
+func nextBatch(ctx context.Context, query func(context.Context, map[string]interface{}) (driver.Rows, error), lastID string, batchSize int, opts map[string]interface{}) ([]driver.Row, error) {
+	options := map[string]interface{}{
+		"include_docs": true,
+		"limit":        batchSize + 1,
+	}
+	for k, v := range opts {
+		options[k] = v
+	}
+	if lastID != "" {
+		options["startkey"] = lastID
+	}
+	rows, err := query(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+	batch := make([]driver.Row, 0, batchSize)
+	for len(batch) < batchSize {
+		var row driver.Row
+		if err := rows.Next(&row); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if row.ID == lastID {
+			continue
+		}
+		batch = append(batch, row)
+	}
+	return batch, nil
+}
+
+func (d *db) dumpDocs(ctx context.Context, bw *backupWriter, opts *DumpOptions) error {
+	batchSize := backupBatchSize(opts.BatchSize)
+	var query map[string]interface{}
+	if opts.Conflicts {
+		query = map[string]interface{}{"conflicts": true}
+	}
+	for {
+		batch, err := nextBatch(ctx, d.AllDocs, bw.checkpoint.LastDocID, batchSize, query)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		refs := make([]driver.BulkGetReference, 0, len(batch))
+		for _, row := range batch {
+			var doc struct {
+				Rev       string   `json:"_rev"`
+				Conflicts []string `json:"_conflicts"`
+			}
+			if err := json.Unmarshal(row.Doc, &doc); err != nil {
+				return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+			}
+			refs = append(refs, driver.BulkGetReference{ID: row.ID, Rev: doc.Rev})
+			for _, rev := range doc.Conflicts {
+				refs = append(refs, driver.BulkGetReference{ID: row.ID, Rev: rev})
+			}
+		}
+		if err := d.dumpRevisions(ctx, bw, refs); err != nil {
+			return err
+		}
+		if err := bw.flush(opts.Checkpoint); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (d *db) dumpRevisions(ctx context.Context, bw *backupWriter, refs []driver.BulkGetReference) error {
+	rows, err := d.BulkGet(ctx, refs, map[string]interface{}{
+		"revs":        true,
+		"attachments": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+	for {
+		var row driver.Row
+		if err := rows.Next(&row); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if row.Error != nil {
+			return row.Error
+		}
+		if err := verifyAttachmentDigests(row.ID, row.Doc); err != nil {
+			return err
+		}
+		if err := bw.writeDoc(recordDoc, row.ID, row.Doc); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *db) dumpLocalDocs(ctx context.Context, bw *backupWriter, opts *DumpOptions) error {
+	batchSize := backupBatchSize(opts.BatchSize)
+	lastID := bw.checkpoint.LastDocID
+	if !strings.HasPrefix(lastID, "_local/") {
+		lastID = ""
+	}
+	for {
+		batch, err := nextBatch(ctx, d.LocalDocs, lastID, batchSize, nil)
+		if err != nil {
+			return err
+		}
+		for _, row := range batch {
+			if err := bw.writeDoc(recordLocal, row.ID, row.Doc); err != nil {
+				return err
+			}
+			lastID = row.ID
+		}
+		if len(batch) > 0 {
+			if err := bw.flush(opts.Checkpoint); err != nil {
+				return err
+			}
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+type backupAttachment struct {
+	ContentType string `json:"content_type"`
+	Digest      string `json:"digest"`
+	Data        []byte `json:"data"`
+	Stub        bool   `json:"stub"`
+}
+
+func decodeBackupAttachments(docID string, doc json.RawMessage) (map[string]backupAttachment, error) {
+	var atts struct {
+		Attachments map[string]backupAttachment `json:"_attachments"`
+	}
+	if err := json.Unmarshal(doc, &atts); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("kivik: invalid document '%s': %s", docID, err)}
+	}
+	return atts.Attachments, nil
+}
+
+// verifyAttachmentDigests checks the inline attachment content of doc against
+// the md5 digests reported by the server.
+func verifyAttachmentDigests(docID string, doc json.RawMessage) error {
+	atts, err := decodeBackupAttachments(docID, doc)
+	if err != nil {
+		return err
+	}
+	for filename, att := range atts {
+		if err := att.verify(docID, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *backupAttachment) verify(docID, filename string) error {
+	if a.Stub {
+		return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("kivik: attachment '%s/%s' has no content", docID, filename)}
+	}
+	if !strings.HasPrefix(a.Digest, "md5-") {
+		return nil
+	}
+	sum := md5.Sum(a.Data) // nolint: gosec
+	if base64.StdEncoding.EncodeToString(sum[:]) != strings.TrimPrefix(a.Digest, "md5-") {
+		return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("kivik: digest mismatch for attachment '%s/%s'", docID, filename)}
+	}
+	return nil
+}
+
+// Restore restores a backup written by Dump to the database.
+func (d *db) Restore(ctx context.Context, r io.Reader, opts *RestoreOptions) error {
+	if r == nil {
+		return missingArg("r")
+	}
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	defer gz.Close() // nolint: errcheck
+	rs := &restoreState{
+		db:        d,
+		batchSize: backupBatchSize(opts.BatchSize),
+		callback:  opts.Checkpoint,
+	}
+	var skip int64
+	if opts.Resume != nil {
+		skip = opts.Resume.Records
+		rs.checkpoint = *opts.Resume
+	}
+	var seen int64
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	for {
+		var rec backupRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: backup archive truncated")}
+			}
+			return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		switch rec.Type {
+		case recordHeader:
+			if rec.Version != backupFormatVersion {
+				return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: unsupported backup format version %d", rec.Version)}
+			}
+		case recordSecurity:
+			if opts.Resume != nil || rec.Security == nil {
+				continue
+			}
+			if err := d.SetSecurity(ctx, rec.Security); err != nil {
+				return err
+			}
+		case recordDoc, recordLocal:
+			seen++
+			if seen <= skip {
+				continue
+			}
+			if err := rs.add(ctx, &rec); err != nil {
+				return err
+			}
+		case recordFooter:
+			if rec.Records != seen {
+				return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: backup archive contains %d documents, footer expects %d", seen, rec.Records)}
+			}
+			return rs.flush(ctx)
+		default:
+			return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: unknown backup record type '%s'", rec.Type)}
+		}
+	}
+}
+
+type restoreState struct {
+	db         *db
+	batchSize  int
+	callback   func(BackupCheckpoint) error
+	checkpoint BackupCheckpoint
+
+	docs    []interface{}
+	pending int64
+	lastID  string
+}
+
+func (rs *restoreState) add(ctx context.Context, rec *backupRecord) error {
+	var meta struct {
+		ID string `json:"_id"`
+	}
+	if err := json.Unmarshal(rec.Doc, &meta); err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	if rec.Type == recordLocal {
+		if err := rs.db.putLocalDoc(ctx, meta.ID, rec.Doc); err != nil {
+			return err
+		}
+	} else {
+		atts, err := decodeBackupAttachments(meta.ID, rec.Doc)
+		if err != nil {
+			return err
+		}
+		if len(atts) > 0 {
+			if err := rs.db.restoreWithAttachments(ctx, meta.ID, rec.Doc, atts); err != nil {
+				return err
+			}
+		} else {
+			rs.docs = append(rs.docs, rec.Doc)
+		}
+	}
+	rs.pending++
+	rs.lastID = meta.ID
+	if len(rs.docs) >= rs.batchSize {
+		return rs.flush(ctx)
+	}
+	return nil
+}
+
+// flush writes all buffered documents, then reports the checkpoint.
+func (rs *restoreState) flush(ctx context.Context) error {
+	if len(rs.docs) > 0 {
+		results, err := rs.db.BulkDocs(ctx, rs.docs, map[string]interface{}{"new_edits": false})
+		if err != nil {
+			return err
+		}
+		if err := bulkResultsError(results); err != nil {
+			return err
+		}
+		rs.docs = rs.docs[:0]
+	}
+	if rs.pending == 0 {
+		return nil
+	}
+	rs.checkpoint.Records += rs.pending
+	rs.checkpoint.LastDocID = rs.lastID
+	rs.pending = 0
+	if rs.callback == nil {
+		return nil
+	}
+	return rs.callback(rs.checkpoint)
+}
+
+// bulkResultsError consumes results, returning the first error encountered,
+// with the status of the document's failure.
+func bulkResultsError(results driver.BulkResults) error {
+	defer results.Close() // nolint: errcheck
+	for {
+		var result driver.BulkResult
+		if err := results.Next(&result); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if result.Error != nil {
+			return &kivik.Error{HTTPStatus: kivik.StatusCode(result.Error), Err: fmt.Errorf("kivik: failed to restore '%s': %s", result.ID, result.Error)}
+		}
+	}
+}
+
+// restoreWithAttachments writes a single document revision, uploading its
+// attachments in binary form with a multipart/related request.
+func (d *db) restoreWithAttachments(ctx context.Context, docID string, raw json.RawMessage, atts map[string]backupAttachment) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	attachments := make(kivik.Attachments, len(atts))
+	for filename, att := range atts {
+		if err := att.verify(docID, filename); err != nil {
+			return err
+		}
+		attachments[filename] = &kivik.Attachment{
+			Filename:    filename,
+			ContentType: att.ContentType,
+			Content:     ioutil.NopCloser(bytes.NewReader(att.Data)),
+			Size:        int64(len(att.Data)),
+		}
+	}
+	doc[attachmentsKey] = attachments
+	_, err := d.Put(ctx, docID, doc, map[string]interface{}{"new_edits": false})
+	return err
+}
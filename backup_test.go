@@ -0,0 +1,230 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Body: Body(body),
+	}
+}
+
+func dumpServer(t *testing.T) *db {
+	t.Helper()
+	return newCustomDB(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/testdb":
+			return jsonResponse(http.StatusOK, `{"db_name":"testdb","update_seq":"3-xxx"}`), nil
+		case "/testdb/_security":
+			return jsonResponse(http.StatusOK, `{"admins":{"names":["bob"]}}`), nil
+		case "/testdb/_all_docs":
+			if req.URL.Query().Get("startkey") != "" {
+				return jsonResponse(http.StatusOK, `{"rows":[{"id":"foo","key":"foo","doc":{"_id":"foo","_rev":"2-b"}}]}`), nil
+			}
+			if req.URL.Query().Get("conflicts") != "true" {
+				return nil, fmt.Errorf("expected conflicts=true")
+			}
+			return jsonResponse(http.StatusOK, `{"rows":[
+				{"id":"bar","key":"bar","doc":{"_id":"bar","_rev":"1-a","_conflicts":["1-c"]}},
+				{"id":"foo","key":"foo","doc":{"_id":"foo","_rev":"2-b"}}
+			]}`), nil
+		case "/testdb/_bulk_get":
+			var body struct {
+				Docs []struct {
+					ID  string `json:"id"`
+					Rev string `json:"rev"`
+				} `json:"docs"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			results := make([]string, 0, len(body.Docs))
+			for _, ref := range body.Docs {
+				doc := fmt.Sprintf(`{"_id":%q,"_rev":%q,"_revisions":{"start":1,"ids":["x"]}}`, ref.ID, ref.Rev)
+				if ref.ID == "foo" {
+					// "hello" has the md5 digest XUFAKrxLKna5cZ2REBfFkg==
+					doc = `{"_id":"foo","_rev":"2-b","_revisions":{"start":2,"ids":["b","a"]},"_attachments":{"a.txt":{"content_type":"text/plain","digest":"md5-XUFAKrxLKna5cZ2REBfFkg==","data":"aGVsbG8="}}}`
+				}
+				results = append(results, fmt.Sprintf(`{"id":%q,"docs":[{"ok":%s}]}`, ref.ID, doc))
+			}
+			return jsonResponse(http.StatusOK, `{"results":[`+strings.Join(results, ",")+`]}`), nil
+		case "/testdb/_local_docs":
+			return jsonResponse(http.StatusOK, `{"rows":[{"id":"_local/cp","key":"_local/cp","doc":{"_id":"_local/cp","_rev":"0-1","seq":12345678901234567890}}]}`), nil
+		}
+		return nil, fmt.Errorf("unexpected request: %s %s", req.Method, req.URL)
+	})
+}
+
+func readArchive(t *testing.T, archive []byte) []string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestDump(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var buf bytes.Buffer
+		var checkpoints []BackupCheckpoint
+		err := dumpServer(t).Dump(context.Background(), &buf, &DumpOptions{
+			Conflicts: true,
+			Checkpoint: func(cp BackupCheckpoint) error {
+				checkpoints = append(checkpoints, cp)
+				return nil
+			},
+		})
+		testy.Error(t, "", err)
+		lines := readArchive(t, buf.Bytes())
+		if d := testy.DiffText(testy.Snapshot(t), strings.Join(lines, "\n")); d != nil {
+			t.Error(d)
+		}
+		if len(checkpoints) != 2 {
+			t.Fatalf("Expected 2 checkpoints, got %d", len(checkpoints))
+		}
+		last := checkpoints[1]
+		if last.LastDocID != "_local/cp" || last.Records != 4 {
+			t.Errorf("Unexpected final checkpoint: %+v", last)
+		}
+	})
+	t.Run("resume", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := dumpServer(t).Dump(context.Background(), &buf, &DumpOptions{
+			Resume: &BackupCheckpoint{LastDocID: "bar", Records: 2, Offset: 100},
+		})
+		testy.Error(t, "", err)
+		lines := readArchive(t, buf.Bytes())
+		if len(lines) != 3 || !strings.Contains(lines[2], `"records":4`) {
+			t.Errorf("Unexpected resumed archive:\n%s", strings.Join(lines, "\n"))
+		}
+	})
+	t.Run("digest mismatch", func(t *testing.T) {
+		d := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/testdb":
+				return jsonResponse(http.StatusOK, `{}`), nil
+			case "/testdb/_security":
+				return jsonResponse(http.StatusOK, `{}`), nil
+			case "/testdb/_all_docs":
+				return jsonResponse(http.StatusOK, `{"rows":[{"id":"foo","key":"foo","doc":{"_id":"foo","_rev":"1-a"}}]}`), nil
+			case "/testdb/_bulk_get":
+				return jsonResponse(http.StatusOK, `{"results":[{"id":"foo","docs":[{"ok":{"_id":"foo","_rev":"1-a","_attachments":{"a.txt":{"digest":"md5-XUFAKrxLKna5cZ2REBfFkg==","data":"Z29vZGJ5ZQ=="}}}}]}]}`), nil
+			}
+			return nil, fmt.Errorf("unexpected request: %s", req.URL)
+		})
+		err := d.Dump(context.Background(), &bytes.Buffer{}, nil)
+		testy.StatusError(t, "kivik: digest mismatch for attachment 'foo/a.txt'", http.StatusBadGateway, err)
+	})
+}
+
+type restoreRecorder struct {
+	requests []string
+	// bulkResults, if set, is the response to _bulk_docs.
+	bulkResults string
+}
+
+func (r *restoreRecorder) db() *db {
+	return newRecordingDB(&r.requests, func(req *http.Request) (*http.Response, error) {
+		switch req.Method {
+		case http.MethodPost:
+			if r.bulkResults != "" {
+				return jsonResponse(http.StatusCreated, r.bulkResults), nil
+			}
+			return jsonResponse(http.StatusCreated, `[]`), nil
+		case http.MethodPut:
+			return jsonResponse(http.StatusCreated, `{"ok":true,"rev":"1-x"}`), nil
+		}
+		return jsonResponse(http.StatusOK, `{}`), nil
+	})
+}
+
+func TestRestore(t *testing.T) {
+	var archive bytes.Buffer
+	if err := dumpServer(t).Dump(context.Background(), &archive, &DumpOptions{Conflicts: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rec := &restoreRecorder{}
+		err := rec.db().Restore(context.Background(), bytes.NewReader(archive.Bytes()), nil)
+		testy.Error(t, "", err)
+		if d := testy.DiffText(testy.Snapshot(t), strings.Join(rec.requests, "\n")); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("resume", func(t *testing.T) {
+		rec := &restoreRecorder{}
+		var checkpoints []BackupCheckpoint
+		err := rec.db().Restore(context.Background(), bytes.NewReader(archive.Bytes()), &RestoreOptions{
+			Resume: &BackupCheckpoint{LastDocID: "foo", Records: 3},
+			Checkpoint: func(cp BackupCheckpoint) error {
+				checkpoints = append(checkpoints, cp)
+				return nil
+			},
+		})
+		testy.Error(t, "", err)
+		if len(rec.requests) != 1 || !strings.HasPrefix(rec.requests[0], "PUT /testdb/_local/cp") {
+			t.Errorf("Unexpected requests: %v", rec.requests)
+		}
+		if len(checkpoints) != 1 || checkpoints[0].Records != 4 {
+			t.Errorf("Unexpected checkpoints: %+v", checkpoints)
+		}
+	})
+	t.Run("document rejected", func(t *testing.T) {
+		rec := &restoreRecorder{bulkResults: `[{"id":"foo","error":"conflict","reason":"Document update conflict."}]`}
+		err := rec.db().Restore(context.Background(), bytes.NewReader(archive.Bytes()), nil)
+		testy.StatusError(t, "kivik: failed to restore 'foo': Document update conflict.", http.StatusConflict, err)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(`{"type":"header","version":1}` + "\n"))
+		_ = gz.Close()
+		err := (&restoreRecorder{}).db().Restore(context.Background(), &buf, nil)
+		testy.StatusError(t, "kivik: backup archive truncated", http.StatusBadRequest, err)
+	})
+	t.Run("record count mismatch", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(`{"type":"header","version":1}` + "\n" + `{"type":"footer","records":3}` + "\n"))
+		_ = gz.Close()
+		err := (&restoreRecorder{}).db().Restore(context.Background(), &buf, nil)
+		testy.StatusError(t, "kivik: backup archive contains 0 documents, footer expects 3", http.StatusBadRequest, err)
+	})
+	t.Run("not gzip", func(t *testing.T) {
+		err := (&restoreRecorder{}).db().Restore(context.Background(), strings.NewReader("{}"), nil)
+		testy.StatusError(t, "unexpected EOF", http.StatusBadRequest, err)
+	})
+}
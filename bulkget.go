@@ -23,6 +23,12 @@ import (
 )
 
 func (d *db) BulkGet(ctx context.Context, docs []driver.BulkGetReference, opts map[string]interface{}) (driver.Rows, error) {
+	return d.bulkGet(ctx, docs, opts)
+}
+
+// bulkGet requests docs, which may be of any type marshaled as the docs of a
+// _bulk_get request body.
+func (d *db) bulkGet(ctx context.Context, docs interface{}, opts map[string]interface{}) (driver.Rows, error) {
 	query, err := optionsToParams(opts)
 	if err != nil {
 		return nil, err
@@ -15,7 +15,6 @@ package couchdb
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,23 +26,62 @@ import (
 
 // Changes returns the changes stream for the database.
 func (d *db) Changes(ctx context.Context, opts map[string]interface{}) (driver.Changes, error) {
+	rows, err := d.changes(ctx, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// changes requests the changes feed. If wrapBody is not nil, it wraps the
+// response body before it is parsed.
+func (d *db) changes(ctx context.Context, opts map[string]interface{}, wrapBody func(io.ReadCloser) io.ReadCloser) (*changesRows, error) {
 	key := "results"
-	if f, ok := opts["feed"]; ok {
-		if f == "eventsource" {
-			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: eventsource feed not supported, use 'continuous'")}
-		}
-		if f == "continuous" {
-			key = ""
+	var eventSource bool
+	switch opts["feed"] {
+	case "continuous":
+		key = ""
+	case "eventsource":
+		key = ""
+		eventSource = true
+	}
+	leid, err := lastEventID(opts)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := changesFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	var filterParams map[string]interface{}
+	if filter != nil {
+		filterParams = make(map[string]interface{}, len(filter.params)+1)
+		for k, v := range filter.params {
+			filterParams[k] = v
 		}
+		filterParams["filter"] = filter.name
 	}
-	query, err := optionsToParams(opts)
+	query, err := optionsToParams(opts, filterParams)
 	if err != nil {
 		return nil, err
 	}
 	options := &chttp.Options{
-		Query: query,
+		Query:  query,
+		Header: http.Header{},
+	}
+	method := http.MethodGet
+	if filter != nil && filter.body != nil {
+		method = http.MethodPost
+		options.GetBody = chttp.BodyEncoder(filter.body)
+		options.Header[chttp.HeaderIdempotencyKey] = []string{}
 	}
-	resp, err := d.Client.DoReq(ctx, http.MethodGet, d.path("_changes"), options)
+	if eventSource {
+		options.Accept = typeEventStream
+	}
+	if leid != "" {
+		options.Header.Set(OptionLastEventID, leid)
+	}
+	resp, err := d.Client.DoReq(ctx, method, d.path("_changes"), options)
 	if err != nil {
 		return nil, err
 	}
@@ -51,10 +89,25 @@ func (d *db) Changes(ctx context.Context, opts map[string]interface{}) (driver.C
 		return nil, err
 	}
 	etag, _ := chttp.ETag(resp)
-	return newChangesRows(ctx, key, resp.Body, etag), nil
+	body := resp.Body
+	if wrapBody != nil {
+		body = wrapBody(body)
+	}
+	var events *eventSourceReader
+	if eventSource {
+		events = newEventSourceReader(body)
+		body = events
+	}
+	rows := newChangesRows(ctx, key, body, etag)
+	rows.events = events
+	return rows, nil
 }
 
-type continuousChangesParser struct{}
+type continuousChangesParser struct {
+	// meta, if set, receives the last_seq and pending values of the final
+	// line of a continuous feed.
+	meta *changesMeta
+}
 
 func (p *continuousChangesParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
 	meta := i.(*changesMeta)
@@ -68,6 +121,12 @@ func (p *continuousChangesParser) decodeItem(i interface{}, dec *json.Decoder) e
 		return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
 	}
 	ch.Change.Seq = string(ch.Seq)
+	if p.meta != nil && ch.LastSeq != "" {
+		p.meta.lastSeq = ch.LastSeq
+		if ch.Pending != nil {
+			p.meta.pending = *ch.Pending
+		}
+	}
 	return nil
 }
 
@@ -90,17 +149,26 @@ func (m *changesMeta) parseMeta(key string, dec *json.Decoder) error {
 type changesRows struct {
 	*iter
 	*changesMeta
-	etag string
+	etag       string
+	continuous bool
+	// events is set for an eventsource feed.
+	events *eventSourceReader
 }
 
 func newChangesRows(ctx context.Context, key string, r io.ReadCloser, etag string) *changesRows {
-	var meta *changesMeta
-	if key != "" {
-		meta = &changesMeta{}
+	meta := &changesMeta{}
+	parser := &continuousChangesParser{}
+	var iterMeta interface{}
+	if key == "" {
+		parser.meta = meta
+	} else {
+		iterMeta = meta
 	}
 	return &changesRows{
-		iter: newIter(ctx, meta, key, r, &continuousChangesParser{}),
-		etag: etag,
+		iter:        newIter(ctx, iterMeta, key, r, parser),
+		changesMeta: meta,
+		etag:        etag,
+		continuous:  key == "",
 	}
 }
 
@@ -109,14 +177,43 @@ var _ driver.Changes = &changesRows{}
 type change struct {
 	*driver.Change
 	Seq sequenceID `json:"seq"`
+
+	// LastSeq and Pending are only set on the final line of a continuous
+	// feed.
+	LastSeq sequenceID `json:"last_seq"`
+	Pending *int64     `json:"pending"`
 }
 
 func (r *changesRows) Next(row *driver.Change) error {
-	row.Deleted = false
-	return r.iter.next(row)
+	for {
+		*row = driver.Change{}
+		if err := r.iter.next(row); err != nil {
+			return err
+		}
+		var eventID string
+		if r.events != nil {
+			eventID = r.events.nextEventID()
+		}
+		if !r.continuous {
+			return nil
+		}
+		if row.ID == "" && row.Seq == "" {
+			// The final line of a continuous feed, which ends with a timeout,
+			// has only last_seq and pending.
+			continue
+		}
+		r.lastSeq = sequenceID(row.Seq)
+		if eventID != "" {
+			r.lastSeq = sequenceID(eventID)
+		}
+		return nil
+	}
 }
 
-// LastSeq returns the last sequence ID.
+// LastSeq returns the last sequence ID. For a continuous feed, it is the seq
+// of the last change read, or the last_seq sent when the feed ended. For an
+// eventsource feed, it is the ID of the last event read, which may be passed
+// as OptionLastEventID to resume the feed.
 func (r *changesRows) LastSeq() string {
 	return string(r.lastSeq)
 }
@@ -15,6 +15,7 @@ package couchdb
 import (
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"testing"
@@ -41,10 +42,10 @@ func TestChanges(t *testing.T) {
 			err:     "kivik: invalid type chan int for options",
 		},
 		{
-			name:    "eventsource",
-			options: map[string]interface{}{"feed": "eventsource"},
+			name:    "invalid Last-Event-ID",
+			options: map[string]interface{}{"feed": "eventsource", OptionLastEventID: 3},
 			status:  http.StatusBadRequest,
-			err:     "kivik: eventsource feed not supported, use 'continuous'",
+			err:     "kivik: option 'Last-Event-ID' must be string, not int",
 		},
 		{
 			name:   "network error",
@@ -100,6 +101,70 @@ func TestChanges(t *testing.T) {
 	}
 }
 
+func TestChangesEventSource(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if feed := req.URL.Query().Get("feed"); feed != "eventsource" {
+			t.Errorf("Unexpected feed: %s", feed)
+		}
+		if accept := req.Header.Get("Accept"); accept != "text/event-stream" {
+			t.Errorf("Unexpected Accept: %s", accept)
+		}
+		if id := req.Header.Get("Last-Event-ID"); id != "1-a" {
+			t.Errorf("Unexpected Last-Event-ID: %s", id)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body: Body(": connected\r\n" +
+				"\r\n" +
+				"data: {\"seq\":\"2-b\",\"id\":\"b\",\r\n" +
+				"data: \"changes\":[{\"rev\":\"1-x\"}]}\r\n" +
+				"id: 2-b\r\n" +
+				"\r\n" +
+				"event: heartbeat\n" +
+				"data:\n" +
+				"\n" +
+				"data:{\"seq\":\"3-c\",\"id\":\"c\",\"changes\":[{\"rev\":\"2-y\"}],\"deleted\":true}\n" +
+				"id: 3-c\n" +
+				"\n" +
+				"data: {\"seq\":\"4-d\",\"id\":\"d\",\"changes\":[{\"rev\":\"1-z\"}]}\n" +
+				"id: 4-d\n"),
+		}, nil
+	})
+	changes, err := db.Changes(context.Background(), map[string]interface{}{
+		"feed":            "eventsource",
+		OptionLastEventID: "1-a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close() // nolint: errcheck
+	var got []*driver.Change
+	var lastSeqs []string
+	for {
+		change := &driver.Change{}
+		err := changes.Next(change)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, change)
+		lastSeqs = append(lastSeqs, changes.LastSeq())
+	}
+	expected := []*driver.Change{
+		{ID: "b", Seq: "2-b", Changes: []string{"1-x"}},
+		{ID: "c", Seq: "3-c", Changes: []string{"2-y"}, Deleted: true},
+	}
+	if d := testy.DiffInterface(expected, got); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface([]string{"2-b", "3-c"}, lastSeqs); d != nil {
+		t.Error(d)
+	}
+}
+
 func TestChangesNext(t *testing.T) {
 	tests := []struct {
 		name     string
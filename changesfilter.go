@@ -0,0 +1,116 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// ChangesFilter is a server-side filter for a changes feed, created with
+// FilterDocIDs, FilterSelector, FilterView or FilterDesign. Filters which
+// take a document ID list or selector send it in the body of a POST request,
+// so it is not limited by the maximum length of a URL.
+type ChangesFilter struct {
+	name   string
+	body   map[string]interface{}
+	params map[string]interface{}
+}
+
+// FilterDocIDs returns a filter which selects changes to the documents with
+// the given IDs.
+func FilterDocIDs(docIDs ...string) ChangesFilter {
+	if docIDs == nil {
+		docIDs = []string{}
+	}
+	return ChangesFilter{
+		name: "_doc_ids",
+		body: map[string]interface{}{"doc_ids": docIDs},
+	}
+}
+
+// FilterSelector returns a filter which selects changes to the documents
+// which match a Mango selector.
+func FilterSelector(selector interface{}) ChangesFilter {
+	return ChangesFilter{
+		name: "_selector",
+		body: map[string]interface{}{"selector": selector},
+	}
+}
+
+// FilterView returns a filter which selects changes to the documents for
+// which the map function of view, given as "ddoc/view", emits any row.
+func FilterView(view string) ChangesFilter {
+	return ChangesFilter{
+		name:   "_view",
+		params: map[string]interface{}{"view": strings.TrimPrefix(view, designPrefix)},
+	}
+}
+
+// FilterDesign returns a filter which selects changes with the filter
+// function filter, given as "ddoc/filter". params are added to the query
+// string, where the filter function can read them from req.query.
+func FilterDesign(filter string, params map[string]interface{}) ChangesFilter {
+	return ChangesFilter{
+		name:   strings.TrimPrefix(filter, designPrefix),
+		params: params,
+	}
+}
+
+// changesFilter removes the filter options from opts, and returns the filter
+// they describe, if any. The doc_ids option is treated as FilterDocIDs, so that
+// the IDs are sent in the request body.
+func changesFilter(opts map[string]interface{}) (*ChangesFilter, error) {
+	var filter *ChangesFilter
+	if f, ok := opts[OptionChangesFilter]; ok {
+		switch t := f.(type) {
+		case ChangesFilter:
+			filter = &t
+		case *ChangesFilter:
+			filter = t
+		default:
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: option '%s' must be ChangesFilter, not %T", OptionChangesFilter, f)}
+		}
+		delete(opts, OptionChangesFilter)
+	}
+	if docIDs, ok := opts["doc_ids"]; ok {
+		if filter != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: doc_ids and '%s' are mutually exclusive", OptionChangesFilter)}
+		}
+		if f, ok := opts["filter"]; ok && f != "_doc_ids" {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: doc_ids requires filter _doc_ids, not %v", f)}
+		}
+		delete(opts, "doc_ids")
+		delete(opts, "filter")
+		filter = &ChangesFilter{
+			name: "_doc_ids",
+			body: map[string]interface{}{"doc_ids": docIDs},
+		}
+	}
+	if filter == nil {
+		return nil, nil
+	}
+	if filter.name == "" {
+		return nil, missingArg("filter")
+	}
+	if _, ok := opts["filter"]; ok {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: filter and '%s' are mutually exclusive", OptionChangesFilter)}
+	}
+	if filter.name == "_view" && filter.params["view"] == "" {
+		return nil, missingArg("view")
+	}
+	return filter, nil
+}
@@ -0,0 +1,137 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestChangesFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		method  string
+		query   string
+		body    string
+		status  int
+		err     string
+	}{
+		{
+			name:    "doc IDs",
+			options: map[string]interface{}{OptionChangesFilter: FilterDocIDs("a", "b")},
+			method:  http.MethodPost,
+			query:   "filter=_doc_ids",
+			body:    `{"doc_ids":["a","b"]}`,
+		},
+		{
+			name:    "doc_ids option",
+			options: map[string]interface{}{"doc_ids": []string{"a"}, "since": "now"},
+			method:  http.MethodPost,
+			query:   "filter=_doc_ids&since=now",
+			body:    `{"doc_ids":["a"]}`,
+		},
+		{
+			name:    "doc_ids with filter _doc_ids",
+			options: map[string]interface{}{"doc_ids": []string{"a"}, "filter": "_doc_ids"},
+			method:  http.MethodPost,
+			query:   "filter=_doc_ids",
+			body:    `{"doc_ids":["a"]}`,
+		},
+		{
+			name:    "selector",
+			options: map[string]interface{}{OptionChangesFilter: FilterSelector(map[string]interface{}{"tenant": "x"}), "include_docs": true},
+			method:  http.MethodPost,
+			query:   "filter=_selector&include_docs=true",
+			body:    `{"selector":{"tenant":"x"}}`,
+		},
+		{
+			name:    "view",
+			options: map[string]interface{}{OptionChangesFilter: FilterView("_design/foo/bar")},
+			method:  http.MethodGet,
+			query:   "filter=_view&view=foo%2Fbar",
+		},
+		{
+			name:    "design filter",
+			options: map[string]interface{}{OptionChangesFilter: FilterDesign("foo/by_tenant", map[string]interface{}{"tenant": "x"})},
+			method:  http.MethodGet,
+			query:   "filter=foo%2Fby_tenant&tenant=x",
+		},
+		{
+			name:    "invalid filter type",
+			options: map[string]interface{}{OptionChangesFilter: "_doc_ids"},
+			status:  http.StatusBadRequest,
+			err:     "kivik: option 'kivik:changes-filter' must be ChangesFilter, not string",
+		},
+		{
+			name:    "filter and option",
+			options: map[string]interface{}{OptionChangesFilter: FilterView("foo/bar"), "filter": "foo/baz"},
+			status:  http.StatusBadRequest,
+			err:     "kivik: filter and 'kivik:changes-filter' are mutually exclusive",
+		},
+		{
+			name:    "doc_ids and option",
+			options: map[string]interface{}{OptionChangesFilter: FilterView("foo/bar"), "doc_ids": []string{"a"}},
+			status:  http.StatusBadRequest,
+			err:     "kivik: doc_ids and 'kivik:changes-filter' are mutually exclusive",
+		},
+		{
+			name:    "doc_ids and other filter",
+			options: map[string]interface{}{"doc_ids": []string{"a"}, "filter": "foo/bar"},
+			status:  http.StatusBadRequest,
+			err:     "kivik: doc_ids requires filter _doc_ids, not foo/bar",
+		},
+		{
+			name:    "missing view",
+			options: map[string]interface{}{OptionChangesFilter: FilterView("")},
+			status:  http.StatusBadRequest,
+			err:     "kivik: view required",
+		},
+		{
+			name:    "missing filter",
+			options: map[string]interface{}{OptionChangesFilter: ChangesFilter{}},
+			status:  http.StatusBadRequest,
+			err:     "kivik: filter required",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+				if req.Method != test.method {
+					t.Errorf("Unexpected method: %s", req.Method)
+				}
+				if req.URL.RawQuery != test.query {
+					t.Errorf("Unexpected query: %s", req.URL.RawQuery)
+				}
+				var body []byte
+				if req.Body != nil {
+					var err error
+					if body, err = ioutil.ReadAll(req.Body); err != nil {
+						return nil, err
+					}
+				}
+				if d := testy.DiffText(test.body, string(body)); d != nil {
+					t.Errorf("Unexpected body:\n%s", d)
+				}
+				return jsonResponse(http.StatusOK, `{"results":[],"last_seq":"1-a","pending":0}`), nil
+			})
+			changes, err := db.Changes(context.Background(), test.options)
+			testy.StatusError(t, test.err, test.status, err)
+			_ = changes.Close()
+		})
+	}
+}
@@ -0,0 +1,344 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+const (
+	defaultCheckpointEvery    = 100
+	defaultCheckpointInterval = 5 * time.Second
+	checkpointConflictRetries = 3
+	checkpointSaveTimeout     = 30 * time.Second
+)
+
+// CheckpointStore is implemented by the DB handles returned by this driver.
+type CheckpointStore interface {
+	// Checkpoint returns a checkpoint for the named consumer of a changes
+	// feed, stored in the _local/{consumerID} document of this database. The
+	// feed followed may be of this or another database.
+	Checkpoint(consumerID string, opts *CheckpointOptions) *Checkpoint
+}
+
+var _ CheckpointStore = &db{}
+
+// CheckpointOptions are optional parameters to Checkpoint.
+type CheckpointOptions struct {
+	// Every is the number of changes after which the checkpoint is saved.
+	// Defaults to 100.
+	Every int
+	// Interval is the time after which the checkpoint is saved, if any change
+	// has been marked since it was last saved. Defaults to 5 seconds.
+	Interval time.Duration
+}
+
+// Checkpoint records the position of a consumer of a changes feed in a _local
+// document, as the replicator does, so that it may resume where it left off
+// after a restart. As the checkpoint is saved periodically, changes processed
+// since the last save are delivered again after a restart, so consumers see
+// each change at least once.
+type Checkpoint struct {
+	db         *db
+	docID      string
+	consumerID string
+	opts       CheckpointOptions
+
+	mu       sync.Mutex
+	seq      string
+	rev      string
+	unsaved  int
+	lastSave time.Time
+}
+
+// CheckpointLag reports how far a checkpoint is behind the database it
+// follows.
+type CheckpointLag struct {
+	// Checkpoint is the stored sequence.
+	Checkpoint string
+	// UpdateSeq is the current update sequence of the database.
+	UpdateSeq string
+	// Pending is the number of changes after the checkpoint.
+	Pending int64
+	// Behind is the difference between the numeric prefixes of UpdateSeq and
+	// Checkpoint, which is -1 if either has no numeric prefix.
+	Behind int64
+}
+
+type checkpointDoc struct {
+	ID        string     `json:"_id"`
+	Rev       string     `json:"_rev,omitempty"`
+	Seq       sequenceID `json:"seq"`
+	Consumer  string     `json:"consumer"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (d *db) Checkpoint(consumerID string, opts *CheckpointOptions) *Checkpoint {
+	c := &Checkpoint{
+		db:         d,
+		docID:      "_local/" + consumerID,
+		consumerID: consumerID,
+		lastSave:   time.Now(),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Every <= 0 {
+		c.opts.Every = defaultCheckpointEvery
+	}
+	if c.opts.Interval <= 0 {
+		c.opts.Interval = defaultCheckpointInterval
+	}
+	return c
+}
+
+// Seq returns the sequence of the last change marked, or loaded.
+func (c *Checkpoint) Seq() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+func (c *Checkpoint) fetch(ctx context.Context) (*checkpointDoc, error) {
+	if c.consumerID == "" {
+		return nil, missingArg("consumerID")
+	}
+	doc := &checkpointDoc{}
+	_, err := c.db.Client.DoJSON(ctx, http.MethodGet, c.db.path(chttp.EncodeDocID(c.docID)), nil, doc)
+	if kivik.StatusCode(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Load reads the stored checkpoint, and returns its sequence, or "" if no
+// checkpoint has been saved.
+func (c *Checkpoint) Load(ctx context.Context) (string, error) {
+	doc, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq, c.rev, c.unsaved = "", "", 0
+	if doc != nil {
+		c.seq, c.rev = string(doc.Seq), doc.Rev
+	}
+	return c.seq, nil
+}
+
+// Mark records that the change with sequence seq has been processed, and
+// saves the checkpoint if Every changes have been marked, or Interval has
+// passed, since it was last saved.
+func (c *Checkpoint) Mark(ctx context.Context, seq string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq = seq
+	c.unsaved++
+	if c.unsaved < c.opts.Every && time.Since(c.lastSave) < c.opts.Interval {
+		return nil
+	}
+	return c.save(ctx)
+}
+
+// Save saves the checkpoint, if any change has been marked since it was last
+// saved.
+func (c *Checkpoint) Save(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unsaved == 0 {
+		return nil
+	}
+	return c.save(ctx)
+}
+
+// save writes the checkpoint document. If it has been updated elsewhere, such
+// as by a previous instance of the consumer, it is overwritten with the
+// current revision, unless the stored sequence is the later one, in which case
+// it is left alone.
+func (c *Checkpoint) save(ctx context.Context) error {
+	if c.consumerID == "" {
+		return missingArg("consumerID")
+	}
+	doc := &checkpointDoc{
+		ID:        c.docID,
+		Rev:       c.rev,
+		Seq:       sequenceID(c.seq),
+		Consumer:  c.consumerID,
+		UpdatedAt: time.Now().UTC(),
+	}
+	for i := 0; ; i++ {
+		rev, err := c.db.Put(ctx, c.docID, doc, nil)
+		if err == nil {
+			c.rev = rev
+			c.unsaved = 0
+			c.lastSave = time.Now()
+			return nil
+		}
+		if kivik.StatusCode(err) != http.StatusConflict || i >= checkpointConflictRetries {
+			return err
+		}
+		current, err := c.fetch(ctx)
+		if err != nil {
+			return err
+		}
+		doc.Rev = ""
+		if current != nil {
+			if stored, marked := current.Seq.number(), doc.Seq.number(); marked >= 0 && stored > marked {
+				// Keep c.rev, so that the next save is checked again.
+				c.unsaved = 0
+				c.lastSave = time.Now()
+				return nil
+			}
+			doc.Rev = current.Rev
+		}
+	}
+}
+
+// Reset deletes the stored checkpoint, so that the consumer starts again from
+// the beginning of the feed.
+func (c *Checkpoint) Reset(ctx context.Context) error {
+	doc, err := c.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if doc != nil {
+		if _, err := c.db.Delete(ctx, c.docID, doc.Rev, nil); err != nil && kivik.StatusCode(err) != http.StatusNotFound {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq, c.rev, c.unsaved = "", "", 0
+	return nil
+}
+
+// Lag compares the stored checkpoint with the current state of source, the
+// database whose changes are followed.
+func (c *Checkpoint) Lag(ctx context.Context, source driver.DB) (*CheckpointLag, error) {
+	doc, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lag := &CheckpointLag{}
+	if doc != nil {
+		lag.Checkpoint = string(doc.Seq)
+	}
+	stats, err := source.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lag.UpdateSeq = stats.UpdateSeq
+	since := lag.Checkpoint
+	if since == "" {
+		since = "0"
+	}
+	changes, err := source.Changes(ctx, map[string]interface{}{"since": since, "limit": 1})
+	if err != nil {
+		return nil, err
+	}
+	defer changes.Close() // nolint: errcheck
+	for {
+		err := changes.Next(&driver.Change{})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lag.Pending++
+	}
+	lag.Pending += changes.Pending()
+	var checkpoint int64
+	if lag.Checkpoint != "" {
+		checkpoint = sequenceID(lag.Checkpoint).number()
+	}
+	lag.Behind = -1
+	if updated := sequenceID(lag.UpdateSeq).number(); updated >= 0 && checkpoint >= 0 {
+		lag.Behind = updated - checkpoint
+	}
+	return lag, nil
+}
+
+// Follow loads the checkpoint and follows the changes feed of source from
+// it, calling fn for each change, and marking each change once fn returns
+// nil. The checkpoint is saved when the follower ends, including when ctx is
+// cancelled. opts.Since is used only if no checkpoint has been saved. Follow
+// returns as ChangesFollower.Run does, or with the error saving the
+// checkpoint.
+func (c *Checkpoint) Follow(ctx context.Context, source ChangesWatcher, opts *ChangesFollowerOptions, fn func(*driver.Change) error) error {
+	seq, err := c.Load(ctx)
+	if err != nil {
+		return err
+	}
+	var o ChangesFollowerOptions
+	if opts != nil {
+		o = *opts
+	}
+	if seq != "" {
+		o.Since = seq
+	}
+	err = source.FollowChanges(&o).Run(ctx, func(change *driver.Change) error {
+		if err := fn(change); err != nil {
+			return err
+		}
+		return c.Mark(ctx, change.Seq)
+	})
+	saveCtx, cancel := context.WithTimeout(detachedContext{ctx}, checkpointSaveTimeout)
+	defer cancel()
+	if saveErr := c.Save(saveCtx); saveErr != nil {
+		if err == nil {
+			return saveErr
+		}
+		return &checkpointSaveError{err: err, saveErr: saveErr}
+	}
+	return err
+}
+
+// checkpointSaveError is returned by Follow when the checkpoint could not be
+// saved after the follower failed. It unwraps to the follower's error.
+type checkpointSaveError struct {
+	err, saveErr error
+}
+
+func (e *checkpointSaveError) Error() string {
+	return e.err.Error() + "; saving checkpoint: " + e.saveErr.Error()
+}
+
+func (e *checkpointSaveError) Unwrap() error {
+	return e.err
+}
+
+// detachedContext carries the values of a context, but not its cancellation
+// or deadline, so that a checkpoint can still be saved once the context it
+// was followed with is done.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,267 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// checkpointServer stores a single _local document in memory, and counts the
+// revisions written.
+type checkpointServer struct {
+	mu        sync.Mutex
+	rev       int
+	seq       string
+	conflicts int
+	puts      []string
+}
+
+func (s *checkpointServer) db(t *testing.T) *db {
+	return newCustomDB(func(req *http.Request) (*http.Response, error) {
+		resp := s.handle(t, req)
+		if resp != nil {
+			resp.Request = req
+		}
+		return resp, nil
+	})
+}
+
+func (s *checkpointServer) handle(t *testing.T, req *http.Request) *http.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.URL.Path != "/testdb/_local/consumer" {
+		t.Errorf("Unexpected path: %s", req.URL.Path)
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if s.rev == 0 {
+			return jsonResponse(http.StatusNotFound, `{"error":"not_found","reason":"missing"}`)
+		}
+		return jsonResponse(http.StatusOK, fmt.Sprintf(`{"_id":"_local/consumer","_rev":"0-%d","seq":%q}`, s.rev, s.seq))
+	case http.MethodPut:
+		var doc checkpointDoc
+		if err := json.NewDecoder(req.Body).Decode(&doc); err != nil {
+			t.Error(err)
+			return nil
+		}
+		if s.conflicts > 0 {
+			s.conflicts--
+			s.rev++
+			return jsonResponse(http.StatusConflict, `{"error":"conflict","reason":"Document update conflict."}`)
+		}
+		if s.rev > 0 && doc.Rev != fmt.Sprintf("0-%d", s.rev) {
+			return jsonResponse(http.StatusConflict, `{"error":"conflict","reason":"Document update conflict."}`)
+		}
+		s.rev++
+		s.seq = string(doc.Seq)
+		s.puts = append(s.puts, s.seq)
+		return jsonResponse(http.StatusCreated, fmt.Sprintf(`{"ok":true,"id":"_local/consumer","rev":"0-%d"}`, s.rev))
+	case http.MethodDelete:
+		if got, want := req.URL.Query().Get("rev"), fmt.Sprintf("0-%d", s.rev); got != want {
+			t.Errorf("Unexpected rev: %s", got)
+		}
+		s.rev, s.seq = 0, ""
+		resp := jsonResponse(http.StatusOK, `{"ok":true,"id":"_local/consumer","rev":"0-0"}`)
+		resp.Header.Set("ETag", `"0-0"`)
+		return resp
+	}
+	t.Errorf("Unexpected method: %s", req.Method)
+	return nil
+}
+
+func TestCheckpointMark(t *testing.T) {
+	s := &checkpointServer{}
+	c := s.db(t).Checkpoint("consumer", &CheckpointOptions{Every: 2, Interval: time.Hour})
+	ctx := context.Background()
+	if seq, err := c.Load(ctx); err != nil || seq != "" {
+		t.Fatalf("Unexpected result: %q, %v", seq, err)
+	}
+	for _, seq := range []string{"1-a", "2-b", "3-c"} {
+		if err := c.Mark(ctx, seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := testy.DiffInterface([]string{"2-b"}, s.puts); d != nil {
+		t.Error(d)
+	}
+	if err := c.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"2-b", "3-c"}, s.puts); d != nil {
+		t.Error(d)
+	}
+
+	resumed := s.db(t).Checkpoint("consumer", nil)
+	if seq, err := resumed.Load(ctx); err != nil || seq != "3-c" {
+		t.Fatalf("Unexpected result: %q, %v", seq, err)
+	}
+	if err := resumed.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if seq := resumed.Seq(); seq != "" {
+		t.Errorf("Unexpected seq after reset: %s", seq)
+	}
+	if seq, err := resumed.Load(ctx); err != nil || seq != "" {
+		t.Fatalf("Unexpected result after reset: %q, %v", seq, err)
+	}
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	s := &checkpointServer{}
+	c := s.db(t).Checkpoint("consumer", &CheckpointOptions{Every: 1000, Interval: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if err := c.Mark(context.Background(), "1-a"); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"1-a"}, s.puts); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestCheckpointConflict(t *testing.T) {
+	t.Run("retried", func(t *testing.T) {
+		s := &checkpointServer{rev: 1, seq: "1-a", conflicts: 2}
+		c := s.db(t).Checkpoint("consumer", &CheckpointOptions{Every: 1})
+		if err := c.Mark(context.Background(), "5-e"); err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]string{"5-e"}, s.puts); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("stored seq newer", func(t *testing.T) {
+		s := &checkpointServer{rev: 1, seq: "9-z"}
+		c := s.db(t).Checkpoint("consumer", &CheckpointOptions{Every: 1})
+		if err := c.Mark(context.Background(), "5-e"); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.puts) != 0 {
+			t.Errorf("Unexpected puts: %v", s.puts)
+		}
+		if s.seq != "9-z" {
+			t.Errorf("Unexpected stored seq: %s", s.seq)
+		}
+	})
+	t.Run("too many", func(t *testing.T) {
+		s := &checkpointServer{rev: 1, seq: "1-a", conflicts: 10}
+		c := s.db(t).Checkpoint("consumer", &CheckpointOptions{Every: 1})
+		err := c.Mark(context.Background(), "5-e")
+		testy.StatusError(t, "Conflict", http.StatusConflict, err)
+	})
+}
+
+func TestCheckpointMissingConsumer(t *testing.T) {
+	c := newTestDB(nil, nil).Checkpoint("", nil)
+	_, err := c.Load(context.Background())
+	testy.StatusError(t, "kivik: consumerID required", http.StatusBadRequest, err)
+}
+
+func TestCheckpointLag(t *testing.T) {
+	s := &checkpointServer{rev: 1, seq: "10-a"}
+	checkpoints := s.db(t)
+	source := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/testdb":
+			return jsonResponse(http.StatusOK, `{"db_name":"testdb","update_seq":"25-z"}`), nil
+		case "/testdb/_changes":
+			if since := req.URL.Query().Get("since"); since != "10-a" {
+				t.Errorf("Unexpected since: %s", since)
+			}
+			return jsonResponse(http.StatusOK, `{"results":[{"seq":"11-b","id":"b","changes":[{"rev":"1-x"}]}],"last_seq":"11-b","pending":13}`), nil
+		}
+		t.Errorf("Unexpected path: %s", req.URL.Path)
+		return nil, nil
+	})
+	lag, err := checkpoints.Checkpoint("consumer", nil).Lag(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &CheckpointLag{
+		Checkpoint: "10-a",
+		UpdateSeq:  "25-z",
+		Pending:    14,
+		Behind:     15,
+	}
+	if d := testy.DiffInterface(expected, lag); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestCheckpointFollow(t *testing.T) {
+	s := &checkpointServer{rev: 1, seq: "1-a"}
+	checkpoints := s.db(t)
+	source := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if since := req.URL.Query().Get("since"); since != "1-a" {
+			t.Errorf("Unexpected since: %s", since)
+		}
+		return feedResponse(req, `{"seq":"2-b","id":"b","changes":[{"rev":"1-x"}]}
+{"seq":"3-c","id":"c","changes":[{"rev":"1-y"}]}
+`, nil), nil
+	})
+	c := checkpoints.Checkpoint("consumer", &CheckpointOptions{Every: 1000, Interval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ids []string
+	err := c.Follow(ctx, source, &ChangesFollowerOptions{Since: "now"}, func(change *driver.Change) error {
+		ids = append(ids, change.ID)
+		if len(ids) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d := testy.DiffInterface([]string{"b", "c"}, ids); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface([]string{"3-c"}, s.puts); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestCheckpointFollowSaveError(t *testing.T) {
+	s := &checkpointServer{rev: 1, seq: "1-a"}
+	checkpoints := s.db(t)
+	source := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		return feedResponse(req, `{"seq":"2-b","id":"b","changes":[{"rev":"1-x"}]}`+"\n", nil), nil
+	})
+	c := checkpoints.Checkpoint("consumer", &CheckpointOptions{Every: 1000, Interval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := c.Follow(ctx, source, nil, func(*driver.Change) error {
+		s.mu.Lock()
+		s.conflicts = 10
+		s.mu.Unlock()
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	testy.Error(t, "context canceled; saving checkpoint: Conflict", err)
+}
@@ -14,14 +14,11 @@ package couchdb
 
 import (
 	"context"
-	"encoding/json"
-	"io"
 	"net/http"
 	"strings"
 
 	"github.com/go-kivik/couchdb/v4/chttp"
 	kivik "github.com/go-kivik/kivik/v4"
-	"github.com/go-kivik/kivik/v4/driver"
 )
 
 func (c *client) AllDBs(ctx context.Context, opts map[string]interface{}) ([]string, error) {
@@ -65,41 +62,6 @@ func (c *client) DestroyDB(ctx context.Context, dbName string, _ map[string]inte
 	return err
 }
 
-func (c *client) DBUpdates(ctx context.Context) (updates driver.DBUpdates, err error) {
-	resp, err := c.DoReq(ctx, http.MethodGet, "/_db_updates?feed=continuous&since=now", nil)
-	if err != nil {
-		return nil, err
-	}
-	if err := chttp.ResponseError(resp); err != nil {
-		return nil, err
-	}
-	return newUpdates(ctx, resp.Body), nil
-}
-
-type couchUpdates struct {
-	*iter
-}
-
-var _ driver.DBUpdates = &couchUpdates{}
-
-type updatesParser struct{}
-
-var _ parser = &updatesParser{}
-
-func (p *updatesParser) decodeItem(i interface{}, dec *json.Decoder) error {
-	return dec.Decode(i)
-}
-
-func newUpdates(ctx context.Context, body io.ReadCloser) *couchUpdates {
-	return &couchUpdates{
-		iter: newIter(ctx, nil, "", body, &updatesParser{}),
-	}
-}
-
-func (u *couchUpdates) Next(update *driver.DBUpdate) error {
-	return u.iter.next(update)
-}
-
 // Ping queries the /_up endpoint, and returns true if there are no errors, or
 // if a 400 (Bad Request) is returned, and the Server: header indicates a server
 // version prior to 2.x.
@@ -0,0 +1,288 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// collation classes, in CouchDB's view collation order.
+const (
+	collateNull = iota
+	collateFalse
+	collateTrue
+	collateNumber
+	collateString
+	collateArray
+	collateObject
+)
+
+// asciiOrder lists the printable ASCII punctuation and symbols in the order of
+// the ICU root collation, which CouchDB uses for strings. Whitespace sorts
+// before all of them, and digits and letters after.
+const asciiOrder = "\t\n\v\f\r _-,;:!?.'\"()[]{}@*/\\&#%`^+<=>|~$"
+
+// stringHighKey is the string conventionally appended to a prefix to form an
+// endkey which sorts after every string starting with that prefix.
+const stringHighKey = "\ufff0"
+
+// Compare compares two view keys according to CouchDB's view collation, and
+// returns -1, 0 or +1. Keys are ordered by type: null, false, true, numbers,
+// strings, arrays, then objects. Numbers compare by value, arrays element by
+// element, and objects key by key, then value by value, in the order of
+// their fields, with a shorter array or object sorting first when it is a
+// prefix of the other.
+//
+// a and b may be any values which can be marshaled to JSON. A json.RawMessage
+// is used as-is, so the field order of objects is preserved. Maps are
+// marshaled with their keys sorted. Values which cannot be marshaled compare
+// as null.
+//
+// Strings follow the ICU root collation used by CouchDB for ASCII text:
+// whitespace, then punctuation and symbols, then digits, then letters, with
+// case ignored unless the strings are otherwise equal, in which case
+// lowercase sorts first. Other characters compare by code point, after all
+// ASCII characters, so accented and non-Latin text may collate differently
+// from CouchDB.
+func Compare(a, b interface{}) int {
+	return collate(collationValue(a), collationValue(b))
+}
+
+// PrefixRange returns startkey and endkey values to select all array keys
+// which begin with the elements of prefix. endkey is prefix followed by an
+// empty object, which sorts after any other value.
+func PrefixRange(prefix ...interface{}) (startKey, endKey []interface{}) {
+	startKey = append([]interface{}{}, prefix...)
+	endKey = append(append([]interface{}{}, prefix...), map[string]interface{}{})
+	return startKey, endKey
+}
+
+// StringPrefixRange returns startkey and endkey values to select all string
+// keys which begin with prefix. endkey is prefix followed by "\ufff0", which
+// sorts after the characters used in practice.
+func StringPrefixRange(prefix string) (startKey, endKey string) {
+	return prefix, prefix + stringHighKey
+}
+
+// collationValue converts a key to its generic JSON representation, with
+// objects as ordered []objectField slices.
+func collationValue(v interface{}) interface{} {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return nil
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+type objectField struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered decodes the next JSON value from dec, preserving the order of
+// object fields.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		switch t {
+		case '[':
+			array := []interface{}{}
+			for dec.More() {
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			}
+			_, err := dec.Token()
+			return array, err
+		case '{':
+			object := []objectField{}
+			for dec.More() {
+				key, err := nextKey(dec)
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				object = append(object, objectField{key: key, value: v})
+			}
+			_, err := dec.Token()
+			return object, err
+		}
+	case json.Number:
+		return strconv.ParseFloat(string(t), 64)
+	}
+	return t, nil
+}
+
+func collationClass(v interface{}) int {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return collateTrue
+		}
+		return collateFalse
+	case float64:
+		return collateNumber
+	case string:
+		return collateString
+	case []interface{}:
+		return collateArray
+	case []objectField:
+		return collateObject
+	}
+	return collateNull
+}
+
+func collate(a, b interface{}) int {
+	ca, cb := collationClass(a), collationClass(b)
+	if ca != cb {
+		return compareInts(ca, cb)
+	}
+	switch ca {
+	case collateNumber:
+		x, y := a.(float64), b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case collateString:
+		return compareStrings(a.(string), b.(string))
+	case collateArray:
+		x, y := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := collate(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(x), len(y))
+	case collateObject:
+		x, y := a.([]objectField), b.([]objectField)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareStrings(x[i].key, y[i].key); c != 0 {
+				return c
+			}
+			if c := collate(x[i].value, y[i].value); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(x), len(y))
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareStrings compares strings first ignoring case, then with lowercase
+// before uppercase, then by code point, so that only identical strings
+// compare equal.
+func compareStrings(a, b string) int {
+	if c := compareWeights(primaryWeights(a), primaryWeights(b)); c != 0 {
+		return c
+	}
+	if c := compareWeights(caseWeights(a), caseWeights(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func compareWeights(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareInts(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// primaryWeights returns the case-insensitive sort weight of each character of
+// s. Control characters are ignored, as they are by ICU.
+func primaryWeights(s string) []int {
+	weights := make([]int, 0, len(s))
+	for _, r := range s {
+		if w, ok := primaryWeight(r); ok {
+			weights = append(weights, w)
+		}
+	}
+	return weights
+}
+
+func primaryWeight(r rune) (int, bool) {
+	const (
+		digits  = len(asciiOrder)
+		letters = digits + 10
+		other   = letters + 26
+	)
+	if i := strings.IndexRune(asciiOrder, r); i >= 0 {
+		return i, true
+	}
+	switch {
+	case r >= '0' && r <= '9':
+		return digits + int(r-'0'), true
+	case r >= 'a' && r <= 'z':
+		return letters + int(r-'a'), true
+	case r >= 'A' && r <= 'Z':
+		return letters + int(r-'A'), true
+	case unicode.IsControl(r):
+		return 0, false
+	}
+	return other + int(unicode.ToLower(r)), true
+}
+
+// caseWeights returns 0 for each lowercase or uncased character of s, and 1
+// for each uppercase character.
+func caseWeights(s string) []int {
+	weights := make([]int, 0, len(s))
+	for _, r := range s {
+		if _, ok := primaryWeight(r); !ok {
+			continue
+		}
+		if unicode.IsUpper(r) {
+			weights = append(weights, 1)
+		} else {
+			weights = append(weights, 0)
+		}
+	}
+	return weights
+}
@@ -0,0 +1,94 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestCompare(t *testing.T) {
+	// The example sequence from the CouchDB view collation documentation.
+	ordered := []string{
+		`null`, `false`, `true`,
+		`1`, `2`, `3.0`, `4`,
+		`"a"`, `"A"`, `"aa"`, `"b"`, `"B"`, `"ba"`, `"bb"`,
+		`["a"]`, `["b"]`, `["b","c"]`, `["b","c","a"]`, `["b","d"]`, `["b","d","e"]`,
+		`{"a":1}`, `{"a":2}`, `{"b":1}`, `{"b":2}`, `{"b":2,"a":1}`, `{"b":2,"c":2}`,
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			expected := compareInts(i, j)
+			if got := Compare(json.RawMessage(a), json.RawMessage(b)); got != expected {
+				t.Errorf("Compare(%s, %s) = %d, expected %d", a, b, got, expected)
+			}
+		}
+	}
+}
+
+func TestCompareGoValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     interface{}
+		expected int
+	}{
+		{name: "int and float", a: 3, b: 3.0, expected: 0},
+		{name: "int and json", a: 10, b: json.RawMessage(`9.5`), expected: 1},
+		{name: "punctuation before digits", a: "~", b: "0", expected: -1},
+		{name: "digits before letters", a: "9", b: "a", expected: -1},
+		{name: "case ignored before length", a: "Ab", b: "abc", expected: -1},
+		{name: "whitespace first", a: " z", b: "_a", expected: -1},
+		{name: "high key after text", a: "abc\ufff0", b: "abczzz", expected: 1},
+		{name: "slices", a: []string{"a", "b"}, b: []interface{}{"a", 1}, expected: 1},
+		{name: "map keys sorted", a: map[string]int{"b": 1, "a": 2}, b: json.RawMessage(`{"a":2,"b":1}`), expected: 0},
+		{name: "unmarshalable is null", a: func() {}, b: nil, expected: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Compare(test.a, test.b); got != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	start, end := PrefixRange("user", 2020)
+	if d := testy.DiffAsJSON([]interface{}{"user", 2020}, start); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffAsJSON([]interface{}{"user", 2020, map[string]interface{}{}}, end); d != nil {
+		t.Error(d)
+	}
+	for _, key := range []interface{}{
+		[]interface{}{"user", 2020},
+		[]interface{}{"user", 2020, "zzz"},
+		[]interface{}{"user", 2020, []interface{}{1}, map[string]interface{}{"a": 1}},
+	} {
+		if Compare(start, key) > 0 || Compare(key, end) >= 0 {
+			t.Errorf("%v is not within range", key)
+		}
+	}
+	if Compare([]interface{}{"user", 2021}, end) <= 0 {
+		t.Error("Expected next prefix to sort after endkey")
+	}
+	sStart, sEnd := StringPrefixRange("abc")
+	if sStart != "abc" || sEnd != "abc\ufff0" {
+		t.Errorf("Unexpected range: %q - %q", sStart, sEnd)
+	}
+	if Compare("abcZ", sEnd) >= 0 || Compare("abd", sEnd) <= 0 {
+		t.Error("Unexpected string range ordering")
+	}
+}
@@ -32,6 +32,15 @@ const (
 	//    row, err := db.Get(ctx, "doc_id", kivik.Options{couchdb.OptionIfNoneMatch: "1-xxx"})
 	OptionIfNoneMatch = "If-None-Match"
 
+	// OptionLastEventID is an option key to set the Last-Event-ID header on
+	// a request for an eventsource changes feed, which resumes the feed after
+	// the change with that ID. It is an alternative to the since option.
+	//
+	// Example:
+	//
+	//    changes, err := db.Changes(ctx, kivik.Options{"feed": "eventsource", couchdb.OptionLastEventID: lastSeq})
+	OptionLastEventID = "Last-Event-ID"
+
 	// OptionPartition instructs supporting methods to limit the query to the
 	// specified partition. Supported methods are: Query, AllDocs, Find, and
 	// Explain. Only supported by CouchDB 3.0.0 and newer.
@@ -48,9 +57,30 @@ const (
 	// download attachments with the multipart/related media type. This only
 	// affects GET requests that request attachments.
 	NoMultipartGet = "kivik:no-multipart-get"
+
+	// OptionStrictMango enables, when true, or disables, when false, strict
+	// Mango mode for a call to Find, overriding the Couch.StrictMango default.
+	// In strict mode, the query is first passed to _explain, and if CouchDB
+	// would answer it by scanning the _all_docs index, rather than with a
+	// usable index, Find returns a *FullScanError without running the query.
+	//
+	// Example:
+	//
+	//    rows, err := db.Find(ctx, query, kivik.Options{couchdb.OptionStrictMango: true})
+	OptionStrictMango = "kivik:strict-mango"
+
+	// OptionChangesFilter is the option key used to pass a ChangesFilter to
+	// Changes, or in ChangesFollowerOptions.Options, to select the changes
+	// returned by the server.
+	//
+	// Example:
+	//
+	//    changes, err := db.Changes(ctx, kivik.Options{couchdb.OptionChangesFilter: couchdb.FilterDocIDs("a", "b")})
+	OptionChangesFilter = "kivik:changes-filter"
 )
 
 const (
-	typeJSON      = "application/json"
-	typeMPRelated = "multipart/related"
+	typeJSON        = "application/json"
+	typeMPRelated   = "multipart/related"
+	typeEventStream = "text/event-stream"
 )
@@ -30,6 +30,10 @@ type Couch struct {
 
 	// If provided, HTTPClient will be used for requests to the CouchDB server.
 	HTTPClient *http.Client
+
+	// If true, StrictMango enables strict Mango mode for all Find queries. See
+	// OptionStrictMango.
+	StrictMango bool
 }
 
 var _ driver.Driver = &Couch{}
@@ -51,6 +55,14 @@ type client struct {
 	// It should only be accessed through the schedulerSupported() method.
 	schedulerDetected *bool
 	sdMU              sync.Mutex
+
+	// strictMango is the default for OptionStrictMango.
+	strictMango bool
+
+	// features caches the server's features, once read. It should only be
+	// accessed through the hasFeature() method.
+	features   []string
+	featuresMU sync.Mutex
 }
 
 var (
@@ -80,7 +92,8 @@ func (d *Couch) NewClient(dsn string) (driver.Client, error) {
 		chttpClient.UserAgents = append(chttpClient.UserAgents, d.UserAgent)
 	}
 	return &client{
-		Client: chttpClient,
+		Client:      chttpClient,
+		strictMango: d.StrictMango,
 	}, nil
 }
 
@@ -409,6 +409,29 @@ func (d *db) Put(ctx context.Context, docID string, doc interface{}, options map
 	return result.Rev, nil
 }
 
+// putLocalDoc writes the _local document raw, overwriting any existing copy,
+// whatever its revision.
+func (d *db) putLocalDoc(ctx context.Context, docID string, raw json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	delete(doc, "_rev")
+	_, err := d.Put(ctx, docID, doc, nil)
+	if kivik.StatusCode(err) != http.StatusConflict {
+		return err
+	}
+	_, rev, err := d.GetMeta(ctx, docID, nil)
+	if err != nil {
+		return err
+	}
+	doc["_rev"] = rev
+	_, err = d.Put(ctx, docID, doc, nil)
+	return err
+}
+
 const attachmentsKey = "_attachments"
 
 func extractAttachments(doc interface{}) (*kivik.Attachments, bool) {
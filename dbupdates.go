@@ -0,0 +1,183 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// DBUpdatesReader is implemented by the client returned by this driver. It
+// extends driver.DBUpdater's DBUpdates, which always follows the continuous
+// feed from now.
+type DBUpdatesReader interface {
+	// DBUpdatesWithOptions returns the _db_updates feed. opts may include
+	// since, feed ("normal", "longpoll" or "continuous"), timeout, heartbeat
+	// and limit, and default to a continuous feed since now. timeout and
+	// heartbeat may be given as a time.Duration, or in milliseconds. The
+	// returned iterator implements DBUpdatesReporter.
+	DBUpdatesWithOptions(ctx context.Context, opts map[string]interface{}) (driver.DBUpdates, error)
+}
+
+var _ DBUpdatesReader = &client{}
+
+// DBUpdatesReporter is implemented by the iterators returned by DBUpdates and
+// DBUpdatesWithOptions.
+type DBUpdatesReporter interface {
+	// LastSeq returns the seq of the last event read or, once the feed has
+	// ended, the last_seq reported by the server. It may be passed as since
+	// to resume the feed.
+	LastSeq() string
+}
+
+func (c *client) DBUpdates(ctx context.Context) (updates driver.DBUpdates, err error) {
+	return c.DBUpdatesWithOptions(ctx, nil)
+}
+
+func (c *client) DBUpdatesWithOptions(ctx context.Context, opts map[string]interface{}) (driver.DBUpdates, error) {
+	params := map[string]interface{}{
+		"feed":  "continuous",
+		"since": "now",
+	}
+	for k, v := range opts {
+		switch k {
+		case "timeout", "heartbeat":
+			if d, ok := v.(time.Duration); ok {
+				v = int64(d / time.Millisecond)
+			}
+		}
+		params[k] = v
+	}
+	key := "results"
+	switch feed := params["feed"]; feed {
+	case "continuous":
+		key = ""
+	case "normal", "longpoll":
+	default:
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: unsupported feed %v for _db_updates", feed)}
+	}
+	query, err := optionsToParams(params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.DoReq(ctx, http.MethodGet, "/_db_updates", &chttp.Options{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	if err := chttp.ResponseError(resp); err != nil {
+		return nil, err
+	}
+	return newUpdatesFeed(ctx, key, resp.Body), nil
+}
+
+type updatesMeta struct {
+	lastSeq sequenceID
+}
+
+func (m *updatesMeta) parseMeta(key string, dec *json.Decoder) error {
+	if key == "last_seq" {
+		return dec.Decode(&m.lastSeq)
+	}
+	return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("Unexpected key: %s", key)}
+}
+
+type couchUpdates struct {
+	*iter
+	*updatesMeta
+	continuous bool
+}
+
+var (
+	_ driver.DBUpdates  = &couchUpdates{}
+	_ DBUpdatesReporter = &couchUpdates{}
+)
+
+type updatesParser struct {
+	// meta, if set, receives the last_seq of the final line of a continuous
+	// feed.
+	meta *updatesMeta
+}
+
+var _ parser = &updatesParser{}
+
+func (p *updatesParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
+	return i.(*updatesMeta).parseMeta(key, dec)
+}
+
+func (p *updatesParser) decodeItem(i interface{}, dec *json.Decoder) error {
+	update := &struct {
+		*driver.DBUpdate
+		Seq     sequenceID `json:"seq"`
+		LastSeq sequenceID `json:"last_seq"`
+	}{DBUpdate: i.(*driver.DBUpdate)}
+	if err := dec.Decode(update); err != nil {
+		return err
+	}
+	update.DBUpdate.Seq = string(update.Seq)
+	if p.meta != nil && update.LastSeq != "" {
+		p.meta.lastSeq = update.LastSeq
+	}
+	return nil
+}
+
+func newUpdates(ctx context.Context, body io.ReadCloser) *couchUpdates {
+	return newUpdatesFeed(ctx, "", body)
+}
+
+// newUpdatesFeed returns an iterator over a _db_updates response. key is
+// "results" for a normal or longpoll feed, and "" for a continuous feed.
+func newUpdatesFeed(ctx context.Context, key string, body io.ReadCloser) *couchUpdates {
+	meta := &updatesMeta{}
+	parser := &updatesParser{}
+	var iterMeta interface{}
+	if key == "" {
+		parser.meta = meta
+	} else {
+		iterMeta = meta
+	}
+	return &couchUpdates{
+		iter:        newIter(ctx, iterMeta, key, body, parser),
+		updatesMeta: meta,
+		continuous:  key == "",
+	}
+}
+
+func (u *couchUpdates) Next(update *driver.DBUpdate) error {
+	for {
+		*update = driver.DBUpdate{}
+		if err := u.iter.next(update); err != nil {
+			return err
+		}
+		if u.continuous && update.DBName == "" && update.Seq == "" {
+			// The final line of a continuous feed, which ends with a timeout,
+			// has only last_seq.
+			continue
+		}
+		u.lastSeq = sequenceID(update.Seq)
+		return nil
+	}
+}
+
+// LastSeq returns the seq of the last update read, or the last_seq sent when
+// the feed ended.
+func (u *couchUpdates) LastSeq() string {
+	return string(u.lastSeq)
+}
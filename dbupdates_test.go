@@ -0,0 +1,225 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+func TestDBUpdates(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *client
+		status int
+		err    string
+	}{
+		{
+			name:   "network error",
+			client: newTestClient(nil, errors.New("net error")),
+			status: http.StatusBadGateway,
+			err:    `Get "?http://example.com/_db_updates\?feed=continuous&since=now"?: net error`,
+		},
+		{
+			name: "error response",
+			client: newTestClient(&http.Response{
+				StatusCode: 400,
+				Body:       Body(""),
+			}, nil),
+			status: http.StatusBadRequest,
+			err:    "Bad Request",
+		},
+		{
+			name: "Success 1.6.1",
+			client: newTestClient(&http.Response{
+				StatusCode: 200,
+				Header: http.Header{
+					"Transfer-Encoding": {"chunked"},
+					"Server":            {"CouchDB/1.6.1 (Erlang OTP/17)"},
+					"Date":              {"Fri, 27 Oct 2017 19:55:43 GMT"},
+					"Content-Type":      {"application/json"},
+					"Cache-Control":     {"must-revalidate"},
+				},
+				Body: Body(""),
+			}, nil),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.client.DBUpdates(context.TODO())
+			testy.StatusErrorRE(t, test.err, test.status, err)
+			if _, ok := result.(*couchUpdates); !ok {
+				t.Errorf("Unexpected type returned: %t", result)
+			}
+		})
+	}
+}
+
+func TestDBUpdatesWithOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		query   string
+		body    string
+		status  int
+		err     string
+		updates []string
+		lastSeq string
+	}{
+		{
+			name:    "defaults",
+			query:   "feed=continuous&since=now",
+			body:    `{"db_name":"a","type":"created","seq":"1-a"}` + "\n",
+			updates: []string{"a"},
+			lastSeq: "1-a",
+		},
+		{
+			name: "continuous with timeout",
+			options: map[string]interface{}{
+				"since":     "1-a",
+				"timeout":   5 * time.Second,
+				"heartbeat": 1000,
+			},
+			query: "feed=continuous&heartbeat=1000&since=1-a&timeout=5000",
+			body: `{"db_name":"b","type":"created","seq":"2-b"}
+
+{"db_name":"c","type":"deleted","seq":"3-c"}
+{"last_seq":"3-c"}
+`,
+			updates: []string{"b", "c"},
+			lastSeq: "3-c",
+		},
+		{
+			name:    "normal",
+			options: map[string]interface{}{"feed": "normal", "since": "0", "limit": 2},
+			query:   "feed=normal&limit=2&since=0",
+			body:    `{"results":[{"db_name":"a","type":"created","seq":"1-a"},{"db_name":"b","type":"created","seq":"2-b"}],"last_seq":"2-b"}`,
+			updates: []string{"a", "b"},
+			lastSeq: "2-b",
+		},
+		{
+			name:    "longpoll",
+			options: map[string]interface{}{"feed": "longpoll"},
+			query:   "feed=longpoll&since=now",
+			body:    `{"results":[],"last_seq":"5-e"}`,
+			lastSeq: "5-e",
+		},
+		{
+			name:    "unsupported feed",
+			options: map[string]interface{}{"feed": "eventsource"},
+			status:  http.StatusBadRequest,
+			err:     "kivik: unsupported feed eventsource for _db_updates",
+		},
+		{
+			name:    "invalid option",
+			options: map[string]interface{}{"limit": 1.5},
+			status:  http.StatusBadRequest,
+			err:     "kivik: invalid type float64 for options",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path != "/_db_updates" {
+					t.Errorf("Unexpected path: %s", req.URL.Path)
+				}
+				if req.URL.RawQuery != test.query {
+					t.Errorf("Unexpected query: %s", req.URL.RawQuery)
+				}
+				return jsonResponse(http.StatusOK, test.body), nil
+			})
+			result, err := c.DBUpdatesWithOptions(context.Background(), test.options)
+			testy.StatusError(t, test.err, test.status, err)
+			defer result.Close() // nolint: errcheck
+			var names []string
+			for {
+				update := &driver.DBUpdate{}
+				err := result.Next(update)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, update.DBName)
+			}
+			if d := testy.DiffInterface(test.updates, names); d != nil {
+				t.Error(d)
+			}
+			if seq := result.(DBUpdatesReporter).LastSeq(); seq != test.lastSeq {
+				t.Errorf("Unexpected last seq: %s", seq)
+			}
+		})
+	}
+}
+
+func TestUpdatesNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		updates  *couchUpdates
+		status   int
+		err      string
+		expected *driver.DBUpdate
+	}{
+		{
+			name:     "consumed feed",
+			updates:  newUpdates(context.TODO(), Body("")),
+			expected: &driver.DBUpdate{},
+			status:   http.StatusInternalServerError,
+			err:      "EOF",
+		},
+		{
+			name:     "numeric seq",
+			updates:  newUpdates(context.TODO(), Body(`{"db_name":"mailbox","type":"created","seq":3}`)),
+			expected: &driver.DBUpdate{DBName: "mailbox", Type: "created", Seq: "3"},
+		},
+		{
+			name:    "read feed",
+			updates: newUpdates(context.TODO(), Body(`{"db_name":"mailbox","type":"created","seq":"1-g1AAAAFReJzLYWBg4MhgTmHgzcvPy09JdcjLz8gvLskBCjMlMiTJ____PyuDOZExFyjAnmJhkWaeaIquGIf2JAUgmWQPMiGRAZcaB5CaePxqEkBq6vGqyWMBkgwNQAqobD4h"},`)),
+			expected: &driver.DBUpdate{
+				DBName: "mailbox",
+				Type:   "created",
+				Seq:    "1-g1AAAAFReJzLYWBg4MhgTmHgzcvPy09JdcjLz8gvLskBCjMlMiTJ____PyuDOZExFyjAnmJhkWaeaIquGIf2JAUgmWQPMiGRAZcaB5CaePxqEkBq6vGqyWMBkgwNQAqobD4h",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := new(driver.DBUpdate)
+			err := test.updates.Next(result)
+			testy.StatusError(t, test.err, test.status, err)
+			if d := testy.DiffInterface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestUpdatesClose(t *testing.T) {
+	body := &closeTracker{ReadCloser: Body("")}
+	u := newUpdates(context.TODO(), body)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !body.closed {
+		t.Errorf("Failed to close")
+	}
+}
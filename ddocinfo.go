@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// DesignDocInspector is implemented by the DB handles returned by this driver.
+// It reports on the state of a design document's view index.
+type DesignDocInspector interface {
+	// DesignDocInfo returns information about the view index of the named
+	// design document. ddoc may include the _design/ prefix.
+	DesignDocInfo(ctx context.Context, ddoc string) (*DesignDocInfo, error)
+	// WaitForIndex polls the design document's _info, and the indexer
+	// entries of _active_tasks, until the view index has caught up with the
+	// database's update sequence at the time of the call. It does not start
+	// the index build; CouchDB builds an index when one of its views is
+	// queried, for example with update=lazy.
+	WaitForIndex(ctx context.Context, ddoc string, opts *WaitForIndexOptions) error
+}
+
+var _ DesignDocInspector = &db{}
+
+// DesignDocInfo is the response to GET /{db}/_design/{ddoc}/_info.
+type DesignDocInfo struct {
+	Name      string        `json:"name"`
+	ViewIndex ViewIndexInfo `json:"view_index"`
+}
+
+// ViewIndexSizes are the sizes, in bytes, of a view index.
+type ViewIndexSizes struct {
+	Active   int64 `json:"active"`
+	External int64 `json:"external"`
+	File     int64 `json:"file"`
+}
+
+// ViewIndexInfo describes the view index of a design document.
+type ViewIndexInfo struct {
+	Signature      string         `json:"signature"`
+	Language       string         `json:"language"`
+	Sizes          ViewIndexSizes `json:"sizes"`
+	UpdateSeq      string         `json:"update_seq"`
+	PurgeSeq       string         `json:"purge_seq"`
+	UpdaterRunning bool           `json:"updater_running"`
+	CompactRunning bool           `json:"compact_running"`
+	WaitingClients int64          `json:"waiting_clients"`
+	WaitingCommit  bool           `json:"waiting_commit"`
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface. The sequences are
+// integers in the responses of CouchDB 2.x and 3.x, but strings in others.
+func (i *ViewIndexInfo) UnmarshalJSON(data []byte) error {
+	type alias ViewIndexInfo
+	var info struct {
+		alias
+		UpdateSeq sequenceID `json:"update_seq"`
+		PurgeSeq  sequenceID `json:"purge_seq"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return err
+	}
+	*i = ViewIndexInfo(info.alias)
+	i.UpdateSeq = string(info.UpdateSeq)
+	i.PurgeSeq = string(info.PurgeSeq)
+	return nil
+}
+
+// IndexProgress reports the progress of a view index build.
+type IndexProgress struct {
+	// ChangesDone and TotalChanges are summed over the indexer tasks of all
+	// shards currently building the index. Both are zero when no indexer is
+	// running.
+	ChangesDone  int64
+	TotalChanges int64
+	// UpdateSeq is the update sequence the index has reached, and TargetSeq
+	// the database update sequence it is waiting for.
+	UpdateSeq string
+	TargetSeq string
+}
+
+// WaitForIndexOptions are optional parameters to WaitForIndex.
+type WaitForIndexOptions struct {
+	// PollInterval is how often the index build is checked. Defaults to one
+	// second.
+	PollInterval time.Duration
+	// Progress, if set, is called after each poll.
+	Progress func(IndexProgress)
+}
+
+func (d *db) DesignDocInfo(ctx context.Context, ddoc string) (*DesignDocInfo, error) {
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+	var info DesignDocInfo
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(designPrefix+chttp.EncodeDocID(ddoc)+"/_info"), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+type indexerTask struct {
+	Type           string `json:"type"`
+	Database       string `json:"database"`
+	DesignDocument string `json:"design_document"`
+	ChangesDone    int64  `json:"changes_done"`
+	TotalChanges   int64  `json:"total_changes"`
+}
+
+// indexerTasks returns the indexer entries of _active_tasks for the named
+// design document of this database.
+func (d *db) indexerTasks(ctx context.Context, ddoc string) ([]indexerTask, error) {
+	var tasks []indexerTask
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, "/_active_tasks", nil, &tasks); err != nil {
+		return nil, err
+	}
+	var result []indexerTask
+	for _, task := range tasks {
+		if task.Type == "indexer" && task.DesignDocument == designPrefix+ddoc && taskDBName(task.Database) == d.dbName {
+			result = append(result, task)
+		}
+	}
+	return result, nil
+}
+
+func (d *db) WaitForIndex(ctx context.Context, ddoc string, opts *WaitForIndexOptions) error {
+	if ddoc == "" {
+		return missingArg("ddoc")
+	}
+	if opts == nil {
+		opts = &WaitForIndexOptions{}
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+	stats, err := d.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	target := sequenceID(stats.UpdateSeq)
+	for {
+		info, err := d.DesignDocInfo(ctx, ddoc)
+		if err != nil {
+			return err
+		}
+		tasks, err := d.indexerTasks(ctx, ddoc)
+		if err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			progress := IndexProgress{
+				UpdateSeq: info.ViewIndex.UpdateSeq,
+				TargetSeq: string(target),
+			}
+			for _, task := range tasks {
+				progress.ChangesDone += task.ChangesDone
+				progress.TotalChanges += task.TotalChanges
+			}
+			opts.Progress(progress)
+		}
+		if len(tasks) == 0 && !info.ViewIndex.UpdaterRunning &&
+			sequenceID(info.ViewIndex.UpdateSeq).number() >= target.number() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// taskDBName returns the database name from the database field of an active
+// task, which in CouchDB 2.0 and later is a shard name, of the form
+// shards/00000000-1fffffff/dbname.1234567890.
+func taskDBName(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) != 3 || parts[0] != "shards" {
+		return name
+	}
+	name = parts[2]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,159 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestDesignDocInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       *db
+		ddoc     string
+		expected *DesignDocInfo
+		status   int
+		err      string
+	}{
+		{
+			name:   "missing ddoc",
+			db:     newTestDB(nil, nil),
+			status: http.StatusBadRequest,
+			err:    "kivik: ddoc required",
+		},
+		{
+			name:   "network error",
+			db:     newTestDB(nil, errors.New("net error")),
+			ddoc:   "foo",
+			status: http.StatusBadGateway,
+			err:    `Get "?http://example.com/testdb/_design/foo/_info"?: net error`,
+		},
+		{
+			name: "success",
+			db: newCustomDB(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path != "/testdb/_design/foo/_info" {
+					t.Errorf("Unexpected path: %s", req.URL.Path)
+				}
+				return jsonResponse(http.StatusOK, `{"name":"foo","view_index":{"compact_running":false,"language":"javascript","purge_seq":0,"signature":"a2b8f1c0","sizes":{"active":1024,"external":512,"file":4096},"update_seq":42,"updater_running":true,"waiting_clients":2,"waiting_commit":false}}`), nil
+			}),
+			ddoc: "_design/foo",
+			expected: &DesignDocInfo{
+				Name: "foo",
+				ViewIndex: ViewIndexInfo{
+					Signature:      "a2b8f1c0",
+					Language:       "javascript",
+					Sizes:          ViewIndexSizes{Active: 1024, External: 512, File: 4096},
+					UpdateSeq:      "42",
+					PurgeSeq:       "0",
+					UpdaterRunning: true,
+					WaitingClients: 2,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.db.DesignDocInfo(context.Background(), test.ddoc)
+			testy.StatusErrorRE(t, test.err, test.status, err)
+			if d := testy.DiffInterface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestWaitForIndex(t *testing.T) {
+	var polls int
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/testdb":
+			return jsonResponse(http.StatusOK, `{"db_name":"testdb","update_seq":"100-g1AAAA"}`), nil
+		case "/testdb/_design/foo/_info":
+			polls++
+			seq := map[int]string{1: "0", 2: "60", 3: "100"}[polls]
+			return jsonResponse(http.StatusOK, `{"name":"foo","view_index":{"updater_running":`+
+				map[bool]string{true: "true", false: "false"}[polls < 3]+`,"update_seq":`+seq+`}}`), nil
+		case "/_active_tasks":
+			switch polls {
+			case 1:
+				return jsonResponse(http.StatusOK, `[
+{"type":"indexer","database":"shards/00000000-7fffffff/testdb.1600000000","design_document":"_design/foo","changes_done":0,"total_changes":50},
+{"type":"indexer","database":"shards/80000000-ffffffff/testdb.1600000000","design_document":"_design/foo","changes_done":0,"total_changes":50},
+{"type":"indexer","database":"shards/00000000-7fffffff/other.1600000000","design_document":"_design/foo","changes_done":7,"total_changes":9}
+]`), nil
+			case 2:
+				return jsonResponse(http.StatusOK, `[
+{"type":"indexer","database":"shards/00000000-7fffffff/testdb.1600000000","design_document":"_design/foo","changes_done":40,"total_changes":50},
+{"type":"indexer","database":"shards/80000000-ffffffff/testdb.1600000000","design_document":"_design/foo","changes_done":20,"total_changes":50},
+{"type":"replication","database":"shards/00000000-7fffffff/testdb.1600000000"}
+]`), nil
+			}
+			return jsonResponse(http.StatusOK, `[]`), nil
+		}
+		t.Fatalf("Unexpected request: %s", req.URL.Path)
+		return nil, nil
+	})
+	var progress []IndexProgress
+	err := db.WaitForIndex(context.Background(), "foo", &WaitForIndexOptions{
+		PollInterval: time.Millisecond,
+		Progress:     func(p IndexProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []IndexProgress{
+		{ChangesDone: 0, TotalChanges: 100, UpdateSeq: "0", TargetSeq: "100-g1AAAA"},
+		{ChangesDone: 60, TotalChanges: 100, UpdateSeq: "60", TargetSeq: "100-g1AAAA"},
+		{UpdateSeq: "100", TargetSeq: "100-g1AAAA"},
+	}
+	if d := testy.DiffInterface(expected, progress); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestWaitForIndexCancel(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/testdb":
+			return jsonResponse(http.StatusOK, `{"db_name":"testdb","update_seq":"5-g1AAAA"}`), nil
+		case "/testdb/_design/foo/_info":
+			return jsonResponse(http.StatusOK, `{"name":"foo","view_index":{"updater_running":false,"update_seq":0}}`), nil
+		}
+		return jsonResponse(http.StatusOK, `[]`), nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := db.WaitForIndex(ctx, "foo", &WaitForIndexOptions{PollInterval: time.Millisecond})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTaskDBName(t *testing.T) {
+	tests := map[string]string{
+		"testdb":                                "testdb",
+		"shards/00000000-7fffffff/testdb.16000": "testdb",
+		"shards/00000000-7fffffff/a/b.16000":    "a/b",
+	}
+	for input, expected := range tests {
+		if got := taskDBName(input); got != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, got)
+		}
+	}
+}
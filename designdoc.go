@@ -0,0 +1,360 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const (
+	designPrefix         = "_design/"
+	defaultStagingSuffix = "-new"
+	defaultPollInterval  = time.Second
+)
+
+// DesignDocDeployer is implemented by the DB handles returned by this driver.
+// It deploys design documents, such as those read by LoadDesignDocs.
+type DesignDocDeployer interface {
+	// SyncDesignDocs compares each of ddocs with the deployed version, and
+	// uploads those which differ. It returns the IDs of the design documents
+	// which were changed, or would be changed if opts.DryRun is true.
+	SyncDesignDocs(ctx context.Context, ddocs []map[string]interface{}, opts *SyncDesignDocsOptions) ([]string, error)
+	// DeployDesignDoc performs a blue/green deployment of ddoc: it is
+	// uploaded under a staging ID, its view index is built, and it is then
+	// copied over the live design document, which therefore serves queries
+	// from the old index until the new one is ready. It returns false if the
+	// deployed design document was already up to date.
+	DeployDesignDoc(ctx context.Context, ddoc map[string]interface{}, opts *DeployDesignDocOptions) (bool, error)
+}
+
+var _ DesignDocDeployer = &db{}
+
+// SyncDesignDocsOptions are optional parameters to SyncDesignDocs.
+type SyncDesignDocsOptions struct {
+	// DryRun, when true, reports the changes without making them.
+	DryRun bool
+}
+
+// DeployDesignDocOptions are optional parameters to DeployDesignDoc.
+type DeployDesignDocOptions struct {
+	// StagingSuffix is appended to the design document ID, to form the ID
+	// under which the new version is built. Defaults to "-new".
+	StagingSuffix string
+	// PollInterval is how often the index build is checked. Defaults to one
+	// second.
+	PollInterval time.Duration
+}
+
+// LoadDesignDocs reads design documents from dir. Each subdirectory of dir is
+// read as one design document in the couchapp layout, and each .json file in
+// dir as one complete design document.
+//
+// In the couchapp layout, directories become objects, .json files are decoded
+// as JSON, and any other file becomes a string field, named after the file
+// without its extension, with trailing whitespace removed. For example:
+//
+//	myapp/views/by-name/map.js      -> views.by-name.map
+//	myapp/views/by-name/reduce.js   -> views.by-name.reduce
+//	myapp/validate_doc_update.js    -> validate_doc_update
+//	myapp/filters/active.js         -> filters.active
+//	myapp/options.json              -> options
+//
+// The design document ID is read from an _id file, if present, or else is
+// "_design/" followed by the directory name. Hidden files and the _attachments
+// directory are ignored.
+func LoadDesignDocs(dir string) ([]map[string]interface{}, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ddocs []map[string]interface{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		var ddoc map[string]interface{}
+		switch {
+		case entry.IsDir():
+			ddoc, err = loadCouchappDir(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := ddoc["_id"]; !ok {
+				ddoc["_id"] = designPrefix + name
+			}
+		case filepath.Ext(name) == ".json":
+			ddoc, err = loadJSONDesignDoc(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := ddoc["_id"]; !ok {
+				ddoc["_id"] = designPrefix + strings.TrimSuffix(name, ".json")
+			}
+		default:
+			continue
+		}
+		id, _ := ddoc["_id"].(string)
+		if !strings.HasPrefix(id, designPrefix) {
+			return nil, fmt.Errorf("%s: invalid design document ID %q", path, id)
+		}
+		delete(ddoc, "_rev")
+		ddocs = append(ddocs, ddoc)
+	}
+	return ddocs, nil
+}
+
+func loadJSONDesignDoc(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ddoc map[string]interface{}
+	if err := json.Unmarshal(data, &ddoc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return ddoc, nil
+}
+
+func loadCouchappDir(dir string) (map[string]interface{}, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || name == "_attachments" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		var value interface{}
+		if entry.IsDir() {
+			value, err = loadCouchappDir(path)
+		} else {
+			value, err = loadCouchappFile(path)
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := obj[name]; ok {
+			return nil, fmt.Errorf("%s: duplicate field %q", dir, name)
+		}
+		obj[name] = value
+	}
+	return obj, nil
+}
+
+func loadCouchappFile(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) == ".json" {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return value, nil
+	}
+	return strings.TrimRight(string(data), " \t\r\n"), nil
+}
+
+func designDocID(ddoc map[string]interface{}) (string, error) {
+	id, _ := ddoc["_id"].(string)
+	if !strings.HasPrefix(id, designPrefix) {
+		return "", &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: invalid design document ID %q", id)}
+	}
+	return id, nil
+}
+
+// getDesignDoc fetches the current version of a design document, returning a
+// nil map if it does not exist.
+func (d *db) getDesignDoc(ctx context.Context, id string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	_, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(chttp.EncodeDocID(id)), nil, &doc)
+	if kivik.StatusCode(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// designDocsEqual compares a design document with a deployed version,
+// ignoring the _id and _rev fields.
+func designDocsEqual(want, have map[string]interface{}) (bool, error) {
+	if have == nil {
+		return false, nil
+	}
+	normalize := func(doc map[string]interface{}) (interface{}, error) {
+		stripped := make(map[string]interface{}, len(doc))
+		for k, v := range doc {
+			if k != "_id" && k != "_rev" {
+				stripped[k] = v
+			}
+		}
+		data, err := json.Marshal(stripped)
+		if err != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		var result interface{}
+		err = json.Unmarshal(data, &result)
+		return result, err
+	}
+	w, err := normalize(want)
+	if err != nil {
+		return false, err
+	}
+	h, err := normalize(have)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(w, h), nil
+}
+
+// putDesignDoc stores ddoc under id, replacing the current revision, if any.
+func (d *db) putDesignDoc(ctx context.Context, id string, ddoc, current map[string]interface{}) error {
+	doc := make(map[string]interface{}, len(ddoc)+1)
+	for k, v := range ddoc {
+		doc[k] = v
+	}
+	doc["_id"] = id
+	delete(doc, "_rev")
+	if rev, ok := current["_rev"].(string); ok {
+		doc["_rev"] = rev
+	}
+	_, err := d.Put(ctx, id, doc, nil)
+	return err
+}
+
+func (d *db) SyncDesignDocs(ctx context.Context, ddocs []map[string]interface{}, opts *SyncDesignDocsOptions) ([]string, error) {
+	if opts == nil {
+		opts = &SyncDesignDocsOptions{}
+	}
+	var changed []string
+	for _, ddoc := range ddocs {
+		id, err := designDocID(ddoc)
+		if err != nil {
+			return changed, err
+		}
+		current, err := d.getDesignDoc(ctx, id)
+		if err != nil {
+			return changed, err
+		}
+		same, err := designDocsEqual(ddoc, current)
+		if err != nil {
+			return changed, err
+		}
+		if same {
+			continue
+		}
+		changed = append(changed, id)
+		if opts.DryRun {
+			continue
+		}
+		if err := d.putDesignDoc(ctx, id, ddoc, current); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+func (d *db) DeployDesignDoc(ctx context.Context, ddoc map[string]interface{}, opts *DeployDesignDocOptions) (bool, error) {
+	if opts == nil {
+		opts = &DeployDesignDocOptions{}
+	}
+	suffix := opts.StagingSuffix
+	if suffix == "" {
+		suffix = defaultStagingSuffix
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	id, err := designDocID(ddoc)
+	if err != nil {
+		return false, err
+	}
+	live, err := d.getDesignDoc(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if same, err := designDocsEqual(ddoc, live); err != nil || same {
+		return false, err
+	}
+	stagingID := id + suffix
+	staging, err := d.getDesignDoc(ctx, stagingID)
+	if err != nil {
+		return false, err
+	}
+	if err := d.putDesignDoc(ctx, stagingID, ddoc, staging); err != nil {
+		return false, err
+	}
+	if err := d.buildViews(ctx, stagingID, ddoc, interval); err != nil {
+		return false, err
+	}
+	target := id
+	if rev, ok := live["_rev"].(string); ok {
+		target += "?rev=" + rev
+	}
+	if _, err := d.Copy(ctx, target, stagingID, nil); err != nil {
+		return false, err
+	}
+	staging, err = d.getDesignDoc(ctx, stagingID)
+	if err != nil {
+		return true, err
+	}
+	if rev, ok := staging["_rev"].(string); ok {
+		_, err = d.Delete(ctx, stagingID, rev, nil)
+	}
+	return true, err
+}
+
+// buildViews triggers the build of the view index of the design document
+// stored as id, and waits for it to complete. Design documents without views
+// return immediately.
+func (d *db) buildViews(ctx context.Context, id string, ddoc map[string]interface{}, interval time.Duration) error {
+	views, _ := ddoc["views"].(map[string]interface{})
+	if len(views) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(views))
+	for name := range views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// All views of a design document share one index, so querying one with
+	// update=lazy starts the build of all of them, without waiting for it.
+	rows, err := d.Query(ctx, strings.TrimPrefix(id, designPrefix), names[0], map[string]interface{}{
+		"limit":  0,
+		"update": UpdateLazy,
+	})
+	if err != nil {
+		return err
+	}
+	_ = rows.Close()
+	return d.WaitForIndex(ctx, id, &WaitForIndexOptions{PollInterval: interval})
+}
@@ -0,0 +1,215 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func usersDesignDoc() map[string]interface{} {
+	return map[string]interface{}{
+		"_id": "_design/users",
+		"views": map[string]interface{}{
+			"by-name": map[string]interface{}{
+				"map":    "function(doc) {\n  emit(doc.name, 1);\n}",
+				"reduce": "_count",
+			},
+		},
+		"validate_doc_update": "function(newDoc, oldDoc, userCtx) {}",
+		"filters": map[string]interface{}{
+			"active": "function(doc, req) { return doc.active; }",
+		},
+		"options": map[string]interface{}{"partitioned": false},
+	}
+}
+
+func notFoundResponse(req *http.Request) *http.Response {
+	resp := jsonResponse(http.StatusNotFound, `{"error":"not_found","reason":"missing"}`)
+	resp.Request = req
+	return resp
+}
+
+func TestLoadDesignDocs(t *testing.T) {
+	ddocs, err := LoadDesignDocs("testdata/couchapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{
+			"_id":      "_design/legacy",
+			"language": "javascript",
+			"views": map[string]interface{}{
+				"all": map[string]interface{}{"map": "function(doc) { emit(doc._id); }"},
+			},
+		},
+		usersDesignDoc(),
+	}
+	if d := testy.DiffInterface(expected, ddocs); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSyncDesignDocs(t *testing.T) {
+	deployed := `{"_id":"_design/users","_rev":"2-xyz","views":{"by-name":{"map":"function(doc) {\n  emit(doc.name, 1);\n}","reduce":"_count"}},"validate_doc_update":"function(newDoc, oldDoc, userCtx) {}","filters":{"active":"function(doc, req) { return doc.active; }"},"options":{"partitioned":false}}`
+	newDB := func(requests *[]string) *db {
+		return newCustomDB(func(req *http.Request) (*http.Response, error) {
+			*requests = append(*requests, req.Method+" "+req.URL.Path)
+			switch req.Method + " " + req.URL.Path {
+			case "GET /testdb/_design/users":
+				return jsonResponse(http.StatusOK, deployed), nil
+			case "GET /testdb/_design/legacy":
+				return notFoundResponse(req), nil
+			case "PUT /testdb/_design/legacy":
+				return jsonResponse(http.StatusCreated, `{"ok":true,"id":"_design/legacy","rev":"1-abc"}`), nil
+			}
+			t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			return nil, nil
+		})
+	}
+	ddocs, err := LoadDesignDocs("testdata/couchapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("dry run", func(t *testing.T) {
+		var requests []string
+		changed, err := newDB(&requests).SyncDesignDocs(context.Background(), ddocs, &SyncDesignDocsOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]string{"_design/legacy"}, changed); d != nil {
+			t.Error(d)
+		}
+		if d := testy.DiffInterface([]string{"GET /testdb/_design/legacy", "GET /testdb/_design/users"}, requests); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("apply", func(t *testing.T) {
+		var requests []string
+		changed, err := newDB(&requests).SyncDesignDocs(context.Background(), ddocs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]string{"_design/legacy"}, changed); d != nil {
+			t.Error(d)
+		}
+		expected := []string{"GET /testdb/_design/legacy", "PUT /testdb/_design/legacy", "GET /testdb/_design/users"}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestDeployDesignDoc(t *testing.T) {
+	t.Run("up to date", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/testdb/_design/users" {
+				t.Fatalf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			}
+			return jsonResponse(http.StatusOK, `{"_id":"_design/users","_rev":"1-a","views":{"by-name":{"map":"function(doc) {\n  emit(doc.name, 1);\n}","reduce":"_count"}},"validate_doc_update":"function(newDoc, oldDoc, userCtx) {}","filters":{"active":"function(doc, req) { return doc.active; }"},"options":{"partitioned":false}}`), nil
+		})
+		deployed, err := db.DeployDesignDoc(context.Background(), usersDesignDoc(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deployed {
+			t.Error("Expected no deployment")
+		}
+	})
+	t.Run("blue/green", func(t *testing.T) {
+		var requests []string
+		var polls int
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			entry := req.Method + " " + req.URL.Path
+			if dest := req.Header.Get("Destination"); dest != "" {
+				entry += " -> " + dest
+			}
+			requests = append(requests, entry)
+			switch entry {
+			case "GET /testdb/_design/users":
+				return jsonResponse(http.StatusOK, `{"_id":"_design/users","_rev":"1-a","views":{"by-name":{"map":"function(doc) { emit(doc.name); }"}}}`), nil
+			case "GET /testdb/_design/users-new":
+				if polls == 0 {
+					return notFoundResponse(req), nil
+				}
+				return jsonResponse(http.StatusOK, `{"_id":"_design/users-new","_rev":"1-b"}`), nil
+			case "PUT /testdb/_design/users-new":
+				body, _ := ioutil.ReadAll(req.Body)
+				if testy.DiffAsJSON(testy.Snapshot(t), body) != nil {
+					t.Errorf("Unexpected staging document: %s", body)
+				}
+				return jsonResponse(http.StatusCreated, `{"ok":true,"id":"_design/users-new","rev":"1-b"}`), nil
+			case "GET /testdb/_design/users-new/_view/by-name":
+				if q := req.URL.RawQuery; q != "limit=0&update=lazy" {
+					t.Errorf("Unexpected view query: %s", q)
+				}
+				return jsonResponse(http.StatusOK, `{"total_rows":0,"offset":0,"rows":[]}`), nil
+			case "GET /testdb":
+				return jsonResponse(http.StatusOK, `{"db_name":"testdb","update_seq":"10-g1AAAA"}`), nil
+			case "GET /testdb/_design/users-new/_info":
+				polls++
+				if polls == 1 {
+					return jsonResponse(http.StatusOK, `{"name":"users-new","view_index":{"updater_running":true,"update_seq":4}}`), nil
+				}
+				return jsonResponse(http.StatusOK, `{"name":"users-new","view_index":{"updater_running":false,"update_seq":10}}`), nil
+			case "GET /_active_tasks":
+				if polls == 1 {
+					return jsonResponse(http.StatusOK, `[{"type":"indexer","database":"shards/00000000-7fffffff/testdb.1600000000","design_document":"_design/users-new","changes_done":4,"total_changes":10}]`), nil
+				}
+				return jsonResponse(http.StatusOK, `[]`), nil
+			case "COPY /testdb/_design/users-new -> _design/users?rev=1-a":
+				resp := jsonResponse(http.StatusCreated, `{"ok":true,"id":"_design/users","rev":"2-c"}`)
+				resp.Header.Set("ETag", `"2-c"`)
+				return resp, nil
+			case "DELETE /testdb/_design/users-new":
+				if rev := req.URL.Query().Get("rev"); rev != "1-b" {
+					t.Errorf("Unexpected rev: %s", rev)
+				}
+				resp := jsonResponse(http.StatusOK, `{"ok":true,"id":"_design/users-new","rev":"2-d"}`)
+				resp.Header.Set("ETag", `"2-d"`)
+				return resp, nil
+			}
+			t.Fatalf("Unexpected request: %s", entry)
+			return nil, nil
+		})
+		deployed, err := db.DeployDesignDoc(context.Background(), usersDesignDoc(), &DeployDesignDocOptions{PollInterval: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deployed {
+			t.Error("Expected deployment")
+		}
+		expected := []string{
+			"GET /testdb/_design/users",
+			"GET /testdb/_design/users-new",
+			"PUT /testdb/_design/users-new",
+			"GET /testdb/_design/users-new/_view/by-name",
+			"GET /testdb",
+			"GET /testdb/_design/users-new/_info",
+			"GET /_active_tasks",
+			"GET /testdb/_design/users-new/_info",
+			"GET /_active_tasks",
+			"COPY /testdb/_design/users-new -> _design/users?rev=1-a",
+			"GET /testdb/_design/users-new",
+			"DELETE /testdb/_design/users-new",
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -40,8 +40,8 @@ Passing any other type will return an error.
 
 The only exceptions to the above rule are:
 
- - the special option keys defined by the package constants `OptionFullCommit`
-   and `OptionIfNoneMatch`. These options set the appropriate HTTP request
+ - the special option keys defined by the package constants `OptionFullCommit`,
+   `OptionIfNoneMatch` and `OptionLastEventID`. These options set the appropriate HTTP request
    headers rather than setting a URL parameter.
  - the `keys` key, when passed to a view query, will result in a POST query
    being done, rather than a GET, to accommodate an arbitrary number of keys.
@@ -50,6 +50,13 @@ The only exceptions to the above rule are:
  - the 'NoMultipartGet' option is interpreted by the Kivik CouchDB driver to
    disable multipart/related GET downloads of attachments.
 
+For view queries, NewViewQuery provides a typed alternative to building the
+options map by hand, which validates option combinations before any request is
+made:
+
+    opts, err := couchdb.NewViewQuery().Key("foo").IncludeDocs(true).Options()
+    rows, err := db.Query(ctx, "ddoc", "view", opts)
+
 Authentication
 
 The CouchDB driver supports a number of authentication methods. For most uses,
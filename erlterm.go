@@ -0,0 +1,244 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Tags of the Erlang external term format, which CouchDB hashes to derive
+// replication IDs.
+const (
+	erlVersion      = 131
+	erlNewFloat     = 70
+	erlSmallInteger = 97
+	erlInteger      = 98
+	erlAtom         = 100
+	erlSmallTuple   = 104
+	erlNil          = 106
+	erlString       = 107
+	erlList         = 108
+	erlBinary       = 109
+	erlSmallBig     = 110
+)
+
+// erlAtomTerm is an Erlang atom, such as undefined.
+type erlAtomTerm string
+
+// erlCharList is an Erlang string, which is a list of characters, as opposed
+// to a binary.
+type erlCharList string
+
+// erlTuple is an Erlang tuple.
+type erlTuple []interface{}
+
+// termToBinary encodes v as Erlang's term_to_binary does, with atoms in the
+// Latin-1 form CouchDB pins for replication IDs. Go strings are encoded as
+// binaries, slices as lists, and the JSON values of json.Unmarshal, with
+// json.Number for numbers, as the EJSON terms CouchDB decodes them to.
+func termToBinary(v interface{}) ([]byte, error) {
+	return appendTerm([]byte{erlVersion}, v)
+}
+
+func appendTerm(buf []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return appendTerm(buf, erlAtomTerm("null"))
+	case bool:
+		if t {
+			return appendTerm(buf, erlAtomTerm("true"))
+		}
+		return appendTerm(buf, erlAtomTerm("false"))
+	case erlAtomTerm:
+		buf = append(buf, erlAtom)
+		buf = appendUint16(buf, len(t))
+		return append(buf, t...), nil
+	case erlCharList:
+		if t == "" {
+			return append(buf, erlNil), nil
+		}
+		list := make([]interface{}, len(t))
+		for i := 0; i < len(t); i++ {
+			list[i] = int64(t[i])
+		}
+		return appendTerm(buf, list)
+	case string:
+		buf = append(buf, erlBinary)
+		buf = appendUint32(buf, len(t))
+		return append(buf, t...), nil
+	case int:
+		return appendInteger(buf, big.NewInt(int64(t))), nil
+	case int64:
+		return appendInteger(buf, big.NewInt(t)), nil
+	case float64:
+		buf = append(buf, erlNewFloat)
+		return appendUint64(buf, math.Float64bits(t)), nil
+	case json.Number:
+		if !strings.ContainsAny(string(t), ".eE") {
+			if i, ok := new(big.Int).SetString(string(t), 10); ok {
+				return appendInteger(buf, i), nil
+			}
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendTerm(buf, f)
+	case erlTuple:
+		if len(t) > math.MaxUint8 {
+			return nil, fmt.Errorf("tuple of %d elements is too large", len(t))
+		}
+		buf = append(buf, erlSmallTuple, byte(len(t)))
+		return appendTerms(buf, t)
+	case []interface{}:
+		return appendList(buf, t)
+	case map[string]interface{}:
+		// JSON objects are {Props} tuples, with their keys sorted as
+		// CouchDB's ejsort sorts them.
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		props := make([]interface{}, len(keys))
+		for i, key := range keys {
+			props[i] = erlTuple{key, t[key]}
+		}
+		return appendTerm(buf, erlTuple{props})
+	}
+	return nil, fmt.Errorf("cannot encode %T as an Erlang term", v)
+}
+
+func appendTerms(buf []byte, terms []interface{}) ([]byte, error) {
+	var err error
+	for _, term := range terms {
+		if buf, err = appendTerm(buf, term); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendList encodes a list, which, like term_to_binary, it encodes as a
+// string if it holds only integers from 0 to 255.
+func appendList(buf []byte, list []interface{}) ([]byte, error) {
+	if len(list) == 0 {
+		return append(buf, erlNil), nil
+	}
+	if chars, ok := listBytes(list); ok {
+		buf = append(buf, erlString)
+		buf = appendUint16(buf, len(chars))
+		return append(buf, chars...), nil
+	}
+	buf = append(buf, erlList)
+	buf = appendUint32(buf, len(list))
+	buf, err := appendTerms(buf, list)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, erlNil), nil
+}
+
+func listBytes(list []interface{}) ([]byte, bool) {
+	if len(list) > math.MaxUint16 {
+		return nil, false
+	}
+	chars := make([]byte, len(list))
+	for i, v := range list {
+		var n *big.Int
+		switch t := v.(type) {
+		case int:
+			n = big.NewInt(int64(t))
+		case int64:
+			n = big.NewInt(t)
+		case json.Number:
+			if strings.ContainsAny(string(t), ".eE") {
+				return nil, false
+			}
+			var ok bool
+			if n, ok = new(big.Int).SetString(string(t), 10); !ok {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		if n.Sign() < 0 || n.Cmp(big.NewInt(math.MaxUint8)) > 0 {
+			return nil, false
+		}
+		chars[i] = byte(n.Int64())
+	}
+	return chars, true
+}
+
+func appendInteger(buf []byte, n *big.Int) []byte {
+	switch {
+	case n.Sign() >= 0 && n.Cmp(big.NewInt(math.MaxUint8)) <= 0:
+		return append(buf, erlSmallInteger, byte(n.Int64()))
+	case n.IsInt64() && n.Int64() >= math.MinInt32 && n.Int64() <= math.MaxInt32:
+		buf = append(buf, erlInteger)
+		return appendUint32(buf, int(uint32(int32(n.Int64()))))
+	}
+	// Larger integers are little-endian bignums.
+	digits := new(big.Int).Abs(n).Bytes()
+	buf = append(buf, erlSmallBig, byte(len(digits)))
+	if n.Sign() < 0 {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		buf = append(buf, digits[i])
+	}
+	return buf
+}
+
+func appendUint16(buf []byte, n int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(n))
+	return append(buf, b...)
+}
+
+func appendUint32(buf []byte, n int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n))
+	return append(buf, b...)
+}
+
+func appendUint64(buf []byte, n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return append(buf, b...)
+}
+
+// toEJSON converts v to the JSON values of its JSON encoding, so that
+// termToBinary encodes it as the EJSON term CouchDB decodes it to.
+func toEJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var ejson interface{}
+	if err := dec.Decode(&ejson); err != nil {
+		return nil, err
+	}
+	return ejson, nil
+}
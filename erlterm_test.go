@@ -0,0 +1,112 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestTermToBinary(t *testing.T) {
+	tests := []struct {
+		name     string
+		term     interface{}
+		expected []byte
+		err      string
+	}{
+		{
+			name:     "atom",
+			term:     erlAtomTerm("foo"),
+			expected: []byte{131, 100, 0, 3, 'f', 'o', 'o'},
+		},
+		{
+			name:     "binary",
+			term:     "a",
+			expected: []byte{131, 109, 0, 0, 0, 1, 'a'},
+		},
+		{
+			name:     "string",
+			term:     erlCharList("ab"),
+			expected: []byte{131, 107, 0, 2, 'a', 'b'},
+		},
+		{
+			name:     "empty string",
+			term:     erlCharList(""),
+			expected: []byte{131, 106},
+		},
+		{
+			name:     "small integer",
+			term:     1,
+			expected: []byte{131, 97, 1},
+		},
+		{
+			name:     "integer",
+			term:     256,
+			expected: []byte{131, 98, 0, 0, 1, 0},
+		},
+		{
+			name:     "negative integer",
+			term:     -1,
+			expected: []byte{131, 98, 255, 255, 255, 255},
+		},
+		{
+			name:     "big integer",
+			term:     json.Number("1099511627776"),
+			expected: []byte{131, 110, 6, 0, 0, 0, 0, 0, 0, 1},
+		},
+		{
+			name:     "float",
+			term:     json.Number("1.5"),
+			expected: []byte{131, 70, 63, 248, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "tuple",
+			term:     erlTuple{1, 2},
+			expected: []byte{131, 104, 2, 97, 1, 97, 2},
+		},
+		{
+			name:     "list",
+			term:     []interface{}{"a", 300},
+			expected: []byte{131, 108, 0, 0, 0, 2, 109, 0, 0, 0, 1, 'a', 98, 0, 0, 1, 44, 106},
+		},
+		{
+			name: "JSON object",
+			term: map[string]interface{}{"b": nil, "a": []interface{}{json.Number("1"), json.Number("2")}},
+			expected: []byte{131, 104, 1, 108, 0, 0, 0, 2,
+				104, 2, 109, 0, 0, 0, 1, 'a', 107, 0, 2, 1, 2,
+				104, 2, 109, 0, 0, 0, 1, 'b', 100, 0, 4, 'n', 'u', 'l', 'l',
+				106},
+		},
+		{
+			name:     "empty JSON object",
+			term:     map[string]interface{}{},
+			expected: []byte{131, 104, 1, 106},
+		},
+		{
+			name: "unsupported",
+			term: struct{}{},
+			err:  "cannot encode struct {} as an Erlang term",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := termToBinary(test.term)
+			testy.Error(t, test.err, err)
+			if d := testy.DiffInterface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
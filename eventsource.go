@@ -0,0 +1,134 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// eventSourceReader converts a Server-Sent Events stream, as sent for an
+// eventsource changes feed, to the newline-delimited JSON of a continuous
+// feed. The data of each message event becomes one line. Comments, and
+// events of other types, such as CouchDB's heartbeat events, are dropped.
+type eventSourceReader struct {
+	io.Closer
+	r *bufio.Reader
+
+	// out is data ready to be read.
+	out []byte
+	// data and event belong to the event being read.
+	data  []byte
+	event string
+	// hasData is true once a data field has been read for the current event,
+	// even if it was empty.
+	hasData bool
+
+	mu          sync.Mutex
+	lastEventID string
+	// ids holds the last event ID in effect when each line of out was
+	// written, until the line has been decoded.
+	ids []string
+}
+
+var _ io.ReadCloser = &eventSourceReader{}
+
+func newEventSourceReader(rc io.ReadCloser) *eventSourceReader {
+	return &eventSourceReader{
+		Closer: rc,
+		r:      bufio.NewReader(rc),
+	}
+}
+
+func (r *eventSourceReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		line, err := r.r.ReadString('\n')
+		if line != "" {
+			r.parseLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			if len(r.out) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// parseLine parses one line of the stream, as described by
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+func (r *eventSourceReader) parseLine(line string) {
+	if line == "" {
+		r.dispatch()
+		return
+	}
+	if line[0] == ':' {
+		// A comment, such as a heartbeat.
+		return
+	}
+	field, value := line, ""
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	}
+	switch field {
+	case "data":
+		if r.hasData {
+			r.data = append(r.data, '\n')
+		}
+		r.data = append(r.data, value...)
+		r.hasData = true
+	case "event":
+		r.event = value
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			r.mu.Lock()
+			r.lastEventID = value
+			r.mu.Unlock()
+		}
+	}
+}
+
+// dispatch ends the current event, and if it is a message with data, writes
+// its data as a line of output.
+func (r *eventSourceReader) dispatch() {
+	data, event := r.data, r.event
+	r.data, r.event, r.hasData = nil, "", false
+	if event != "" && event != "message" {
+		return
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return
+	}
+	r.out = append(append(r.out, data...), '\n')
+	r.mu.Lock()
+	r.ids = append(r.ids, r.lastEventID)
+	r.mu.Unlock()
+}
+
+// nextEventID returns the last event ID in effect when the next unread line
+// was written.
+func (r *eventSourceReader) nextEventID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ids) == 0 {
+		return ""
+	}
+	id := r.ids[0]
+	r.ids = r.ids[1:]
+	return id
+}
@@ -84,6 +84,19 @@ func (d *db) DeleteIndex(ctx context.Context, ddoc, name string, opts map[string
 }
 
 func (d *db) Find(ctx context.Context, query interface{}, opts map[string]interface{}) (driver.Rows, error) {
+	query, err := resolveMangoQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	strict, err := d.strictMango(opts)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := d.checkIndex(ctx, query, opts); err != nil {
+			return nil, err
+		}
+	}
 	reqPath := "_find"
 	if part, ok := opts[OptionPartition].(string); ok {
 		delete(opts, OptionPartition)
@@ -105,6 +118,35 @@ func (d *db) Find(ctx context.Context, query interface{}, opts map[string]interf
 	return newFindRows(ctx, resp.Body), nil
 }
 
+// ExecutionStats are the query execution statistics returned by Find, when
+// the query includes `"execution_stats": true`.
+type ExecutionStats struct {
+	TotalKeysExamined       int64   `json:"total_keys_examined"`
+	TotalDocsExamined       int64   `json:"total_docs_examined"`
+	TotalQuorumDocsExamined int64   `json:"total_quorum_docs_examined"`
+	ResultsReturned         int64   `json:"results_returned"`
+	ExecutionTimeMs         float64 `json:"execution_time_ms"`
+}
+
+// ExecutionStatsReporter is implemented by the rows returned by Find. As the
+// statistics follow the documents in the response, they are only available
+// once the rows have been read to the end. ExecutionStats returns nil if the
+// query did not request statistics.
+//
+// Example:
+//
+//	rows, _ := db.Find(ctx, query, nil) // db is a driver.OptsFinder
+//	var row driver.Row
+//	for rows.Next(&row) == nil {
+//	    // ...
+//	}
+//	stats := rows.(couchdb.ExecutionStatsReporter).ExecutionStats()
+type ExecutionStatsReporter interface {
+	ExecutionStats() *ExecutionStats
+}
+
+var _ ExecutionStatsReporter = &rows{}
+
 type queryPlan struct {
 	DBName   string                 `json:"dbname"`
 	Index    map[string]interface{} `json:"index"`
@@ -133,6 +175,10 @@ func (f *fields) UnmarshalJSON(data []byte) error {
 }
 
 func (d *db) Explain(ctx context.Context, query interface{}, opts map[string]interface{}) (*driver.QueryPlan, error) {
+	query, err := resolveMangoQuery(query)
+	if err != nil {
+		return nil, err
+	}
 	reqPath := "_explain"
 	if part, ok := opts[OptionPartition].(string); ok {
 		delete(opts, OptionPartition)
@@ -0,0 +1,320 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// defaultFindLimit is the page size CouchDB uses when a query has no limit.
+const defaultFindLimit = 25
+
+// FindPager pages through the complete results of a Mango query, by
+// re-issuing the query with the bookmark returned by each page. The page size
+// is the query's limit, or CouchDB's default of 25.
+type FindPager struct {
+	bookmarkPaging
+	db    driver.OptsFinder
+	query map[string]interface{}
+	opts  map[string]interface{}
+}
+
+// NewFindPager returns a pager over the results of query, which may be any
+// value accepted by Find, including a *MangoQuery. opts are passed to each
+// call to Find, so may include OptionPartition. If the query includes a
+// bookmark, paging starts from that bookmark.
+func NewFindPager(db driver.OptsFinder, query interface{}, opts map[string]interface{}) (*FindPager, error) {
+	q, err := findQueryMap(query)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := pageLimit(q["limit"])
+	if err != nil {
+		return nil, err
+	}
+	q["limit"] = limit
+	bookmark, _ := q["bookmark"].(string)
+	delete(q, "bookmark")
+	return &FindPager{
+		bookmarkPaging: bookmarkPaging{limit: limit, bookmark: bookmark},
+		db:             db,
+		query:          q,
+		opts:           opts,
+	}, nil
+}
+
+// pageLimit validates the limit of a bookmark-paged query, which defaults to
+// 25 for both Mango and search queries.
+func pageLimit(l interface{}) (int, error) {
+	limit := defaultFindLimit
+	switch l := l.(type) {
+	case nil:
+	case int:
+		limit = l
+	case float64:
+		limit = int(l)
+	default:
+		return 0, mangoError("invalid `limit`: %v", l)
+	}
+	if limit <= 0 {
+		return 0, mangoError("`limit` must be positive")
+	}
+	return limit, nil
+}
+
+// findQueryMap converts any query accepted by Find to a map, so that the
+// limit and bookmark may be set.
+func findQueryMap(query interface{}) (map[string]interface{}, error) {
+	query, err := resolveMangoQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	query, err = deJSONify(query)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := query.(map[string]interface{}); ok {
+		q := make(map[string]interface{}, len(m)+2)
+		for k, v := range m {
+			q[k] = v
+		}
+		return q, nil
+	}
+	data, err := json.Marshal(query)
+	if err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	var q map[string]interface{}
+	if err := json.Unmarshal(data, &q); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	return q, nil
+}
+
+// Next returns a rows iterator over the next page of results. It returns
+// io.EOF when there are no more results, so an empty page is never returned,
+// even when the previous page was exactly limit rows. Any previous page is
+// closed.
+func (p *FindPager) Next(ctx context.Context) (driver.Rows, error) {
+	return p.nextPage(func(bookmark string) (driver.Rows, error) {
+		query := make(map[string]interface{}, len(p.query)+1)
+		for k, v := range p.query {
+			query[k] = v
+		}
+		if bookmark != "" {
+			query["bookmark"] = bookmark
+		}
+		// Find consumes OptionPartition, so each request needs its own copy.
+		opts := make(map[string]interface{}, len(p.opts))
+		for k, v := range p.opts {
+			opts[k] = v
+		}
+		return p.db.Find(ctx, query, opts)
+	})
+}
+
+// Rows returns a rows iterator over all remaining results, fetching each page
+// as the previous one is exhausted.
+func (p *FindPager) Rows(ctx context.Context) driver.Rows {
+	return &bookmarkPagerRows{ctx: ctx, next: p.Next, paging: &p.bookmarkPaging}
+}
+
+// bookmarkPaging holds the paging state shared by FindPager and SearchPager,
+// both of which page by re-issuing a query with the returned bookmark.
+type bookmarkPaging struct {
+	limit    int
+	bookmark string
+	done     bool
+	page     *bookmarkPage
+}
+
+// Bookmark returns the bookmark for the next page, which may be stored to
+// resume paging later with SetBookmark. It is empty before the first page is
+// requested, unless the query included a bookmark. The bookmark is only known
+// once the current page has been read to the end, or closed.
+func (p *bookmarkPaging) Bookmark() string {
+	return p.bookmark
+}
+
+// SetBookmark sets the bookmark from which the next page is read.
+func (p *bookmarkPaging) SetBookmark(bookmark string) {
+	p.bookmark = bookmark
+	p.done = false
+}
+
+// Done returns true once the last page has been read.
+func (p *bookmarkPaging) Done() bool {
+	return p.done
+}
+
+// nextPage closes any previous page, and calls fetch with the current
+// bookmark to read the next one. It returns io.EOF rather than an empty page.
+func (p *bookmarkPaging) nextPage(fetch func(bookmark string) (driver.Rows, error)) (driver.Rows, error) {
+	if p.page != nil {
+		if err := p.page.Close(); err != nil {
+			return nil, err
+		}
+		p.page = nil
+	}
+	if p.done {
+		return nil, io.EOF
+	}
+	rows, err := fetch(p.bookmark)
+	if err != nil {
+		return nil, err
+	}
+	page := &bookmarkPage{Rows: rows, paging: p, prev: p.bookmark}
+	var first driver.Row
+	switch err := page.read(&first); err {
+	case nil:
+		page.first = &first
+	case io.EOF:
+		_ = page.Close()
+		return nil, io.EOF
+	default:
+		_ = rows.Close()
+		return nil, err
+	}
+	p.page = page
+	return page, nil
+}
+
+// bookmarkPage wraps the rows of a single request, recording the bookmark
+// once the rows are exhausted.
+type bookmarkPage struct {
+	driver.Rows
+	paging *bookmarkPaging
+	prev   string
+	first  *driver.Row
+	count  int
+	eof    bool
+	closed bool
+}
+
+var _ driver.Rows = &bookmarkPage{}
+
+func (r *bookmarkPage) read(row *driver.Row) error {
+	if r.eof {
+		return io.EOF
+	}
+	err := r.Rows.Next(row)
+	if err == io.EOF {
+		r.eof = true
+		r.finish()
+	}
+	if err == nil {
+		r.count++
+	}
+	return err
+}
+
+// finish records the page's bookmark, and marks the pager done if the page was
+// short, or the bookmark did not advance.
+func (r *bookmarkPage) finish() {
+	var bookmark string
+	if b, ok := r.Rows.(driver.Bookmarker); ok {
+		bookmark = b.Bookmark()
+	}
+	if r.count < r.paging.limit || bookmark == "" || bookmark == r.prev {
+		r.paging.done = true
+	}
+	if bookmark != "" {
+		r.paging.bookmark = bookmark
+	}
+}
+
+func (r *bookmarkPage) Next(row *driver.Row) error {
+	if r.first != nil {
+		*row = *r.first
+		r.first = nil
+		return nil
+	}
+	return r.read(row)
+}
+
+func (r *bookmarkPage) Bookmark() string {
+	return r.paging.bookmark
+}
+
+func (r *bookmarkPage) ExecutionStats() *ExecutionStats {
+	if s, ok := r.Rows.(ExecutionStatsReporter); ok {
+		return s.ExecutionStats()
+	}
+	return nil
+}
+
+// Close reads any remaining rows, so that the bookmark is known, then closes
+// the underlying iterator.
+func (r *bookmarkPage) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	var err error
+	for err == nil {
+		var row driver.Row
+		err = r.read(&row)
+	}
+	if e := r.Rows.Close(); e != nil {
+		return e
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// bookmarkPagerRows iterates over all pages of a FindPager or SearchPager.
+type bookmarkPagerRows struct {
+	ctx    context.Context
+	next   func(context.Context) (driver.Rows, error)
+	paging *bookmarkPaging
+	page   driver.Rows
+}
+
+var _ driver.Rows = &bookmarkPagerRows{}
+
+func (r *bookmarkPagerRows) Next(row *driver.Row) error {
+	for {
+		if r.page == nil {
+			page, err := r.next(r.ctx)
+			if err != nil {
+				return err
+			}
+			r.page = page
+		}
+		err := r.page.Next(row)
+		if err != io.EOF {
+			return err
+		}
+		r.page = nil
+	}
+}
+
+func (r *bookmarkPagerRows) Close() error {
+	if r.page == nil {
+		return nil
+	}
+	return r.page.Close()
+}
+
+func (r *bookmarkPagerRows) Bookmark() string  { return r.paging.bookmark }
+func (r *bookmarkPagerRows) Offset() int64     { return 0 }
+func (r *bookmarkPagerRows) TotalRows() int64  { return 0 }
+func (r *bookmarkPagerRows) UpdateSeq() string { return "" }
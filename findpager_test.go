@@ -0,0 +1,212 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// bookmarkHandler serves docCount results from an endpoint paged by
+// bookmarks, such as _find or _search, using the offset of the next result as
+// the bookmark. page renders the response with results start to end-1.
+func bookmarkHandler(docCount int, page func(start, end int, bookmark string) string) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		var query struct {
+			Limit    int    `json:"limit"`
+			Bookmark string `json:"bookmark"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			return nil, err
+		}
+		start := 0
+		if query.Bookmark != "" {
+			start, _ = strconv.Atoi(strings.TrimPrefix(query.Bookmark, "b"))
+		}
+		end := start + query.Limit
+		if end > docCount {
+			end = docCount
+		}
+		if end < start {
+			end = start
+		}
+		return jsonResponse(http.StatusOK, page(start, end, fmt.Sprintf("b%d", end))), nil
+	}
+}
+
+// findHandler serves docCount documents from _find.
+func findHandler(docCount int) func(*http.Request) (*http.Response, error) {
+	return bookmarkHandler(docCount, func(start, end int, bookmark string) string {
+		docs := []string{}
+		for i := start; i < end; i++ {
+			docs = append(docs, fmt.Sprintf(`{"_id":"doc%d"}`, i))
+		}
+		return `{"docs":[` + strings.Join(docs, ",") + `],"bookmark":"` + bookmark + `"}`
+	})
+}
+
+// readPageSizes reads every page from pager, and returns the number of rows
+// in each.
+func readPageSizes(t *testing.T, pager interface {
+	Next(context.Context) (driver.Rows, error)
+}) []int {
+	t.Helper()
+	var pages []int
+	for {
+		rows, err := pager.Next(context.Background())
+		if err == io.EOF {
+			return pages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			count++
+		}
+		pages = append(pages, count)
+	}
+}
+
+func TestFindPager(t *testing.T) {
+	t.Run("short final page", func(t *testing.T) {
+		var requests []string
+		pager, err := NewFindPager(newRecordingDB(&requests, findHandler(7)), NewMangoQuery(Eq("a", 1)).Limit(3), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]int{3, 3, 1}, readPageSizes(t, pager)); d != nil {
+			t.Error(d)
+		}
+		expected := []string{
+			`POST /testdb/_find {"limit":3,"selector":{"a":{"$eq":1}}}`,
+			`POST /testdb/_find {"bookmark":"b3","limit":3,"selector":{"a":{"$eq":1}}}`,
+			`POST /testdb/_find {"bookmark":"b6","limit":3,"selector":{"a":{"$eq":1}}}`,
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("exact final page", func(t *testing.T) {
+		var requests []string
+		pager, err := NewFindPager(newRecordingDB(&requests, findHandler(6)), map[string]interface{}{
+			"selector": map[string]interface{}{},
+			"limit":    3,
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]int{3, 3}, readPageSizes(t, pager)); d != nil {
+			t.Error(d)
+		}
+		if len(requests) != 3 {
+			t.Errorf("Expected 3 requests, got %d", len(requests))
+		}
+		if !pager.Done() {
+			t.Error("Expected pager to be done")
+		}
+	})
+	t.Run("partition and default limit", func(t *testing.T) {
+		var requests []string
+		opts := map[string]interface{}{OptionPartition: "p1"}
+		pager, err := NewFindPager(newRecordingDB(&requests, findHandler(30)), `{"selector":{}}`, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]int{25, 5}, readPageSizes(t, pager)); d != nil {
+			t.Error(d)
+		}
+		expected := []string{
+			`POST /testdb/_partition/p1/_find {"limit":25,"selector":{}}`,
+			`POST /testdb/_partition/p1/_find {"bookmark":"b25","limit":25,"selector":{}}`,
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("resume from bookmark", func(t *testing.T) {
+		d := newCustomDB(findHandler(5))
+		pager, err := NewFindPager(d, NewMangoQuery(Eq("a", 1)).Limit(2), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := pager.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		bookmark := pager.Bookmark()
+
+		resumed, err := NewFindPager(d, NewMangoQuery(Eq("a", 1)).Limit(2).Bookmark(bookmark), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]int{2, 1}, readPageSizes(t, resumed)); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("all rows", func(t *testing.T) {
+		pager, err := NewFindPager(newCustomDB(findHandler(4)), NewMangoQuery(Eq("a", 1)).Limit(2), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := pager.Rows(context.Background())
+		var ids []string
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			var doc struct {
+				ID string `json:"_id"`
+			}
+			if err := json.Unmarshal(row.Doc, &doc); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, doc.ID)
+		}
+		expected := []string{"doc0", "doc1", "doc2", "doc3"}
+		if d := testy.DiffInterface(expected, ids); d != nil {
+			t.Error(d)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("invalid limit", func(t *testing.T) {
+		_, err := NewFindPager(newTestDB(nil, nil), NewMangoQuery(Eq("a", 1)).Limit(0), nil)
+		testy.StatusError(t, "kivik: `limit` must be positive", http.StatusBadRequest, err)
+	})
+}
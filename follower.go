@@ -0,0 +1,373 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+const (
+	defaultHeartbeat  = 10 * time.Second
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// ChangesWatcher is implemented by the DB handles returned by this driver.
+type ChangesWatcher interface {
+	// FollowChanges returns a follower of the database's continuous changes
+	// feed. It does not connect until Run or Changes is called.
+	FollowChanges(opts *ChangesFollowerOptions) *ChangesFollower
+}
+
+var _ ChangesWatcher = &db{}
+
+// ChangesFollowerOptions are optional parameters to FollowChanges.
+type ChangesFollowerOptions struct {
+	// Since is the sequence after which to start following. Defaults to
+	// "now".
+	Since string
+	// Options are passed to the _changes endpoint, and may include filter,
+	// include_docs and similar. The feed, since and heartbeat options are
+	// set by the follower.
+	Options map[string]interface{}
+	// Heartbeat is the interval at which the server is asked to send a
+	// heartbeat when there are no changes. Defaults to 10 seconds.
+	Heartbeat time.Duration
+	// StallTimeout is how long the follower waits without receiving a change
+	// or a heartbeat before it treats the connection as stalled, and
+	// reconnects. Defaults to three times Heartbeat.
+	StallTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before reconnecting after an
+	// error, which doubles with each consecutive failure. They default to one
+	// second and one minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError, if set, is called with each error which causes the follower to
+	// reconnect.
+	OnError func(error)
+	// EventSource, if true, follows the eventsource feed rather than the
+	// continuous feed. The changes are the same, but are sent as Server-Sent
+	// Events, which some proxies pass through unbuffered.
+	EventSource bool
+}
+
+// ErrChangesStalled is passed to OnError when a changes feed connection
+// stops sending heartbeats.
+var ErrChangesStalled = errors.New("kivik: changes feed stalled")
+
+// ChangesFollower follows a database's continuous changes feed, reconnecting
+// from the last processed sequence after network errors, server restarts, and
+// stalled connections. Errors with a status of 400, 401, 403 or 404, such as
+// a missing database or filter, are not retried.
+type ChangesFollower struct {
+	db   *db
+	opts ChangesFollowerOptions
+
+	mu      sync.Mutex
+	lastSeq string
+	err     error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (d *db) FollowChanges(opts *ChangesFollowerOptions) *ChangesFollower {
+	f := &ChangesFollower{
+		db:   d,
+		stop: make(chan struct{}),
+	}
+	if opts != nil {
+		f.opts = *opts
+	}
+	if f.opts.Heartbeat <= 0 {
+		f.opts.Heartbeat = defaultHeartbeat
+	}
+	if f.opts.StallTimeout <= 0 {
+		f.opts.StallTimeout = 3 * f.opts.Heartbeat
+	}
+	if f.opts.MinBackoff <= 0 {
+		f.opts.MinBackoff = defaultMinBackoff
+	}
+	if f.opts.MaxBackoff < f.opts.MinBackoff {
+		f.opts.MaxBackoff = defaultMaxBackoff
+		if f.opts.MaxBackoff < f.opts.MinBackoff {
+			f.opts.MaxBackoff = f.opts.MinBackoff
+		}
+	}
+	f.lastSeq = f.opts.Since
+	return f
+}
+
+// LastSeq returns the sequence of the last change processed, from which the
+// follower resumes after reconnecting. It may be stored, and passed as Since
+// to a new follower, to resume following later.
+func (f *ChangesFollower) LastSeq() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSeq
+}
+
+func (f *ChangesFollower) setLastSeq(seq string) {
+	f.mu.Lock()
+	f.lastSeq = seq
+	f.mu.Unlock()
+}
+
+// Stop stops the follower gracefully: Run returns nil once the change being
+// handled, if any, has been handled, and the channel returned by Changes is
+// closed. It is safe to call Stop more than once, and from within the
+// handler.
+func (f *ChangesFollower) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *ChangesFollower) stopped() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run follows the changes feed, calling fn for each change, until Stop is
+// called, ctx is cancelled, fn returns an error, or the server returns an
+// error which is not retried. A change is recorded as processed once fn
+// returns nil. Run returns nil after Stop, and otherwise the error which ended
+// it.
+func (f *ChangesFollower) Run(ctx context.Context, fn func(*driver.Change) error) error {
+	backoff := f.opts.MinBackoff
+	for {
+		received, err := f.follow(ctx, fn)
+		if f.stopped() {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if herr, ok := err.(*handlerError); ok {
+			return herr.err
+		}
+		if err != nil && !retryable(err) {
+			return err
+		}
+		if received {
+			backoff = f.opts.MinBackoff
+		}
+		if err != nil && f.opts.OnError != nil {
+			f.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > f.opts.MaxBackoff {
+			backoff = f.opts.MaxBackoff
+		}
+	}
+}
+
+// Changes runs the follower in the background, and returns a channel which
+// receives each change. A change is recorded as processed once it has been
+// received from the channel. The channel is closed when the follower ends,
+// after which Err returns the reason.
+func (f *ChangesFollower) Changes(ctx context.Context) <-chan *driver.Change {
+	ch := make(chan *driver.Change)
+	go func() {
+		defer close(ch)
+		err := f.Run(ctx, func(change *driver.Change) error {
+			select {
+			case ch <- change:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-f.stop:
+				return errFollowerStopped
+			}
+		})
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+	}()
+	return ch
+}
+
+// Err returns the error which ended a follower started with Changes, once its
+// channel has been closed. It returns nil if the follower was stopped with
+// Stop.
+func (f *ChangesFollower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+var errFollowerStopped = errors.New("follower stopped")
+
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// retryable returns true if err may be resolved by reconnecting.
+func retryable(err error) bool {
+	switch kivik.StatusCode(err) {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return false
+	}
+	return true
+}
+
+// follow reads the changes feed over a single connection, until it ends or
+// fails. It reports whether any data was received.
+func (f *ChangesFollower) follow(ctx context.Context, fn func(*driver.Change) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-f.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	opts := make(map[string]interface{}, len(f.opts.Options)+3)
+	for k, v := range f.opts.Options {
+		opts[k] = v
+	}
+	opts["feed"] = "continuous"
+	if f.opts.EventSource {
+		opts["feed"] = "eventsource"
+	}
+	opts["heartbeat"] = int64(f.opts.Heartbeat / time.Millisecond)
+	opts["since"] = "now"
+	if since := f.LastSeq(); since != "" {
+		opts["since"] = since
+	}
+	body := &activityReader{}
+	body.touch()
+	var stalled int32
+	interval := f.opts.StallTimeout / 4
+	if interval <= 0 {
+		interval = f.opts.StallTimeout
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if body.idle() > f.opts.StallTimeout {
+					atomic.StoreInt32(&stalled, 1)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	rows, err := f.db.changes(ctx, opts, func(rc io.ReadCloser) io.ReadCloser {
+		body.ReadCloser = rc
+		return body
+	})
+	if err != nil {
+		if atomic.LoadInt32(&stalled) == 1 {
+			return false, ErrChangesStalled
+		}
+		return false, err
+	}
+	defer rows.Close() // nolint: errcheck
+	for {
+		change := &driver.Change{}
+		err := rows.Next(change)
+		if atomic.LoadInt32(&stalled) == 1 {
+			return body.received(), ErrChangesStalled
+		}
+		if err == io.EOF {
+			if seq := rows.LastSeq(); seq != "" {
+				f.setLastSeq(seq)
+			}
+			return body.received(), nil
+		}
+		if err != nil {
+			return body.received(), err
+		}
+		// The time spent in fn is not the server's silence.
+		body.pause()
+		err = fn(change)
+		body.resume()
+		if err != nil {
+			if err == errFollowerStopped {
+				return true, nil
+			}
+			return true, &handlerError{err: err}
+		}
+		f.setLastSeq(change.Seq)
+	}
+}
+
+// activityReader records when data, including heartbeats, was last read.
+// While paused, it is never idle.
+type activityReader struct {
+	io.ReadCloser
+	last   int64
+	bytes  int64
+	paused int32
+}
+
+func (r *activityReader) touch() {
+	atomic.StoreInt64(&r.last, time.Now().UnixNano())
+}
+
+func (r *activityReader) pause() {
+	atomic.StoreInt32(&r.paused, 1)
+}
+
+func (r *activityReader) resume() {
+	r.touch()
+	atomic.StoreInt32(&r.paused, 0)
+}
+
+func (r *activityReader) idle() time.Duration {
+	if atomic.LoadInt32(&r.paused) == 1 {
+		return 0
+	}
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&r.last))
+}
+
+func (r *activityReader) received() bool {
+	return atomic.LoadInt64(&r.bytes) > 0
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.bytes, int64(n))
+		r.touch()
+	}
+	return n, err
+}
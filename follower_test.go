@@ -0,0 +1,270 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// feedResponse returns a continuous feed response, which sends body, and then
+// fails with err or, if err is nil, blocks until the request is cancelled.
+func feedResponse(req *http.Request, body string, err error) *http.Response {
+	var tail io.Reader = errReader{err: err}
+	if err == nil {
+		pr, pw := io.Pipe()
+		go func() {
+			<-req.Context().Done()
+			_ = pw.CloseWithError(req.Context().Err())
+		}()
+		tail = pr
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(io.MultiReader(strings.NewReader(body), tail)),
+		Request:    req,
+	}
+}
+
+func TestChangesFollowerResume(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requests = append(requests, req.URL.RawQuery)
+		n := len(requests)
+		mu.Unlock()
+		switch n {
+		case 1:
+			return feedResponse(req, `{"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}
+{"seq":"2-b","id":"b","changes":[{"rev":"1-y"}]}
+`, errors.New("connection reset")), nil
+		case 2:
+			return feedResponse(req, `{"seq":"3-c","id":"c","changes":[{"rev":"1-z"}],"deleted":true}
+
+{"last_seq":"4-d","pending":0}
+`, io.EOF), nil
+		}
+		return feedResponse(req, "\n\n"+`{"seq":"5-e","id":"e","changes":[{"rev":"2-w"}]}`+"\n", nil), nil
+	})
+	var errs []string
+	f := db.FollowChanges(&ChangesFollowerOptions{
+		Heartbeat:  time.Second,
+		MinBackoff: time.Millisecond,
+		Options:    map[string]interface{}{"include_docs": true},
+		OnError:    func(err error) { errs = append(errs, err.Error()) },
+	})
+	var ids []string
+	err := f.Run(context.Background(), func(ch *driver.Change) error {
+		ids = append(ids, ch.ID+" "+ch.Seq)
+		if ch.ID == "e" {
+			f.Stop()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a 1-a", "b 2-b", "c 3-c", "e 5-e"}, ids); d != nil {
+		t.Error(d)
+	}
+	expected := []string{
+		"feed=continuous&heartbeat=1000&include_docs=true&since=now",
+		"feed=continuous&heartbeat=1000&include_docs=true&since=2-b",
+		"feed=continuous&heartbeat=1000&include_docs=true&since=4-d",
+	}
+	if d := testy.DiffInterface(expected, requests); d != nil {
+		t.Error(d)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "connection reset") {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if seq := f.LastSeq(); seq != "5-e" {
+		t.Errorf("Unexpected last seq: %s", seq)
+	}
+}
+
+func TestChangesFollowerStall(t *testing.T) {
+	var requests int
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if requests == 1 {
+			return feedResponse(req, "", nil), nil
+		}
+		return feedResponse(req, `{"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}`+"\n", nil), nil
+	})
+	var errs []error
+	f := db.FollowChanges(&ChangesFollowerOptions{
+		Since:        "0",
+		StallTimeout: 20 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+	err := f.Run(context.Background(), func(ch *driver.Change) error {
+		f.Stop()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0] != ErrChangesStalled {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+}
+
+func TestChangesFollowerSlowHandler(t *testing.T) {
+	var requests int
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return feedResponse(req, `{"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}`+"\n"+
+			`{"seq":"2-b","id":"b","changes":[{"rev":"1-y"}]}`+"\n", nil), nil
+	})
+	var errs []error
+	f := db.FollowChanges(&ChangesFollowerOptions{
+		Since:        "0",
+		StallTimeout: 20 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+	var seqs []string
+	err := f.Run(context.Background(), func(ch *driver.Change) error {
+		seqs = append(seqs, ch.Seq)
+		if len(seqs) == 2 {
+			f.Stop()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if d := testy.DiffInterface([]string{"1-a", "2-b"}, seqs); d != nil {
+		t.Error(d)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
+
+func TestChangesFollowerErrors(t *testing.T) {
+	t.Run("not retried", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			return notFoundResponse(req), nil
+		})
+		err := db.FollowChanges(nil).Run(context.Background(), func(*driver.Change) error { return nil })
+		testy.StatusError(t, "Not Found", http.StatusNotFound, err)
+	})
+	t.Run("handler error", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			return feedResponse(req, `{"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}`+"\n", nil), nil
+		})
+		f := db.FollowChanges(&ChangesFollowerOptions{Since: "0"})
+		err := f.Run(context.Background(), func(*driver.Change) error { return errors.New("handler failed") })
+		testy.Error(t, "handler failed", err)
+		if seq := f.LastSeq(); seq != "0" {
+			t.Errorf("Unexpected last seq: %s", seq)
+		}
+	})
+	t.Run("context cancelled", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			return feedResponse(req, "", nil), nil
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := db.FollowChanges(nil).Run(ctx, func(*driver.Change) error { return nil })
+		if err != context.DeadlineExceeded {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestChangesFollowerChannel(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		return feedResponse(req, `{"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}
+{"seq":"2-b","id":"b","changes":[{"rev":"1-y"}]}
+`, nil), nil
+	})
+	f := db.FollowChanges(nil)
+	var ids []string
+	for ch := range f.Changes(context.Background()) {
+		ids = append(ids, ch.ID)
+		if len(ids) == 2 {
+			f.Stop()
+		}
+	}
+	if err := f.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+	if seq := f.LastSeq(); seq != "2-b" {
+		t.Errorf("Unexpected last seq: %s", seq)
+	}
+}
+
+func TestChangesFollowerEventSource(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if feed := req.URL.Query().Get("feed"); feed != "eventsource" {
+			t.Errorf("Unexpected feed: %s", feed)
+		}
+		return feedResponse(req, `data: {"seq":"1-a","id":"a","changes":[{"rev":"1-x"}]}
+id: 1-a
+
+event: heartbeat
+data:
+
+data: {"seq":"2-b","id":"b","changes":[{"rev":"1-y"}]}
+id: 2-b
+
+`, nil), nil
+	})
+	f := db.FollowChanges(&ChangesFollowerOptions{EventSource: true})
+	var ids []string
+	err := f.Run(context.Background(), func(change *driver.Change) error {
+		ids = append(ids, change.ID)
+		if len(ids) == 2 {
+			f.Stop()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"a", "b"}, ids); d != nil {
+		t.Error(d)
+	}
+	if seq := f.LastSeq(); seq != "2-b" {
+		t.Errorf("Unexpected last seq: %s", seq)
+	}
+}
@@ -128,6 +128,12 @@ type iter struct {
 	// docid. This was added for the _revs_diff endpoint.
 	objMode bool
 
+	// optional allows the expected key to be absent, as it is from the
+	// result of a failed query in a multi-query request. missing is set when
+	// it was.
+	optional bool
+	missing  bool
+
 	dec    *json.Decoder
 	closed int32
 }
@@ -178,6 +184,10 @@ func (i *iter) begin() error {
 		return nil
 	}
 	for {
+		if i.optional && !i.dec.More() {
+			i.missing = true
+			return consumeDelim(i.dec, json.Delim('}'))
+		}
 		key, err := nextKey(i.dec)
 		if err != nil {
 			return err
@@ -230,6 +240,9 @@ func (i *iter) finish() (err error) {
 		}
 		return nil
 	}
+	if i.missing {
+		return nil
+	}
 	if i.objMode {
 		err := consumeDelim(i.dec, json.Delim('}'))
 		if err != nil && err != io.EOF {
@@ -266,7 +279,7 @@ func (i *iter) finish() (err error) {
 }
 
 func (i *iter) nextRow(row interface{}) error {
-	if !i.dec.More() {
+	if i.missing || !i.dec.More() {
 		return io.EOF
 	}
 	return i.parser.decodeItem(row, i.dec)
@@ -0,0 +1,464 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Sort directions accepted by MangoQuery.Sort.
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// Selector is a Mango selector expression, created with one of the condition
+// functions (Eq, Gt, In, ElemMatch, etc), and combined with And, Or, Nor and
+// Not. Arguments are checked as the selector is built; any error is reported
+// when the selector is rendered, or by MangoQuery.Query.
+//
+// Example:
+//
+//	sel := couchdb.And(
+//	    couchdb.Eq("type", "user"),
+//	    couchdb.Gte("age", 18),
+//	    couchdb.In("role", "admin", "editor"),
+//	)
+//
+// Field names are passed to CouchDB unchanged, so a period denotes a nested
+// field, and must be escaped with a backslash to match a literal period.
+type Selector struct {
+	expr map[string]interface{}
+	err  error
+}
+
+func mangoError(format string, args ...interface{}) error {
+	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: "+format, args...)}
+}
+
+func invalidSelector(err error) *Selector {
+	return &Selector{err: err}
+}
+
+func condition(field, op string, value interface{}) *Selector {
+	if field == "" {
+		return invalidSelector(mangoError("%s: field name required", op))
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return invalidSelector(mangoError("%s: invalid argument for `%s`: %s", op, field, err))
+	}
+	return &Selector{expr: map[string]interface{}{
+		field: map[string]interface{}{op: value},
+	}}
+}
+
+func subSelector(field, op string, sel *Selector) *Selector {
+	if sel == nil {
+		return invalidSelector(mangoError("%s: selector required", op))
+	}
+	if sel.err != nil {
+		return sel
+	}
+	return condition(field, op, sel.expr)
+}
+
+func combination(op string, sels []*Selector) *Selector {
+	if len(sels) == 0 {
+		return invalidSelector(mangoError("%s requires at least one selector", op))
+	}
+	exprs := make([]interface{}, len(sels))
+	for i, sel := range sels {
+		if sel == nil {
+			return invalidSelector(mangoError("%s: selector %d is nil", op, i))
+		}
+		if sel.err != nil {
+			return sel
+		}
+		exprs[i] = sel.expr
+	}
+	return &Selector{expr: map[string]interface{}{op: exprs}}
+}
+
+// And matches documents matching all of sels.
+func And(sels ...*Selector) *Selector { return combination("$and", sels) }
+
+// Or matches documents matching any of sels.
+func Or(sels ...*Selector) *Selector { return combination("$or", sels) }
+
+// Nor matches documents matching none of sels.
+func Nor(sels ...*Selector) *Selector { return combination("$nor", sels) }
+
+// Not matches documents not matching sel.
+func Not(sel *Selector) *Selector {
+	if sel == nil {
+		return invalidSelector(mangoError("$not: selector required"))
+	}
+	if sel.err != nil {
+		return sel
+	}
+	return &Selector{expr: map[string]interface{}{"$not": sel.expr}}
+}
+
+// Eq matches documents where field equals value.
+func Eq(field string, value interface{}) *Selector { return condition(field, "$eq", value) }
+
+// Ne matches documents where field does not equal value.
+func Ne(field string, value interface{}) *Selector { return condition(field, "$ne", value) }
+
+// Gt matches documents where field is greater than value.
+func Gt(field string, value interface{}) *Selector { return condition(field, "$gt", value) }
+
+// Gte matches documents where field is greater than or equal to value.
+func Gte(field string, value interface{}) *Selector { return condition(field, "$gte", value) }
+
+// Lt matches documents where field is less than value.
+func Lt(field string, value interface{}) *Selector { return condition(field, "$lt", value) }
+
+// Lte matches documents where field is less than or equal to value.
+func Lte(field string, value interface{}) *Selector { return condition(field, "$lte", value) }
+
+// In matches documents where field equals any of values.
+func In(field string, values ...interface{}) *Selector {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return condition(field, "$in", values)
+}
+
+// Nin matches documents where field equals none of values.
+func Nin(field string, values ...interface{}) *Selector {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return condition(field, "$nin", values)
+}
+
+// Exists matches documents where field exists, or does not exist if exists is
+// false.
+func Exists(field string, exists bool) *Selector { return condition(field, "$exists", exists) }
+
+var mangoTypes = map[string]bool{
+	"null": true, "boolean": true, "number": true,
+	"string": true, "array": true, "object": true,
+}
+
+// Type matches documents where field is of the named JSON type, which must be
+// one of null, boolean, number, string, array or object.
+func Type(field, typ string) *Selector {
+	if !mangoTypes[typ] {
+		return invalidSelector(mangoError("$type: invalid type `%s`", typ))
+	}
+	return condition(field, "$type", typ)
+}
+
+// Size matches documents where field is an array of the given length.
+func Size(field string, size int) *Selector {
+	if size < 0 {
+		return invalidSelector(mangoError("$size: size must not be negative"))
+	}
+	return condition(field, "$size", size)
+}
+
+// Mod matches documents where field is an integer, which divided by divisor
+// leaves remainder.
+func Mod(field string, divisor, remainder int) *Selector {
+	if divisor == 0 {
+		return invalidSelector(mangoError("$mod: divisor must not be zero"))
+	}
+	return condition(field, "$mod", []int{divisor, remainder})
+}
+
+// Regex matches documents where field is a string matching the PCRE regular
+// expression pattern. The pattern is evaluated by CouchDB, so is not checked
+// by the client.
+func Regex(field, pattern string) *Selector {
+	if pattern == "" {
+		return invalidSelector(mangoError("$regex: pattern required"))
+	}
+	return condition(field, "$regex", pattern)
+}
+
+func valueCondition(op string, value interface{}) *Selector {
+	if _, err := json.Marshal(value); err != nil {
+		return invalidSelector(mangoError("%s: invalid argument: %s", op, err))
+	}
+	return &Selector{expr: map[string]interface{}{op: value}}
+}
+
+// ValueEq matches a value equal to value. Like the other Value conditions, it
+// names no field, so tests the value itself, for use as the sub-selector of
+// ElemMatch or AllMatch over an array of scalars, or of KeyMapMatch.
+func ValueEq(value interface{}) *Selector { return valueCondition("$eq", value) }
+
+// ValueNe matches a value not equal to value.
+func ValueNe(value interface{}) *Selector { return valueCondition("$ne", value) }
+
+// ValueGt matches a value greater than value.
+func ValueGt(value interface{}) *Selector { return valueCondition("$gt", value) }
+
+// ValueGte matches a value greater than or equal to value.
+func ValueGte(value interface{}) *Selector { return valueCondition("$gte", value) }
+
+// ValueLt matches a value less than value.
+func ValueLt(value interface{}) *Selector { return valueCondition("$lt", value) }
+
+// ValueLte matches a value less than or equal to value.
+func ValueLte(value interface{}) *Selector { return valueCondition("$lte", value) }
+
+// ValueIn matches a value equal to any of values.
+func ValueIn(values ...interface{}) *Selector {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return valueCondition("$in", values)
+}
+
+// ValueNin matches a value equal to none of values.
+func ValueNin(values ...interface{}) *Selector {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return valueCondition("$nin", values)
+}
+
+// ValueRegex matches a string value matching the PCRE regular expression
+// pattern.
+func ValueRegex(pattern string) *Selector {
+	if pattern == "" {
+		return invalidSelector(mangoError("$regex: pattern required"))
+	}
+	return valueCondition("$regex", pattern)
+}
+
+// ElemMatch matches documents where field is an array with at least one
+// element matching sel. For an array of scalars, sel is built from the Value
+// conditions, such as ValueEq.
+func ElemMatch(field string, sel *Selector) *Selector {
+	return subSelector(field, "$elemMatch", sel)
+}
+
+// AllMatch matches documents where field is an array, all elements of which
+// match sel.
+func AllMatch(field string, sel *Selector) *Selector {
+	return subSelector(field, "$allMatch", sel)
+}
+
+// KeyMapMatch matches documents where field is an object with at least one
+// key matching sel. As keys are strings, sel is built from the Value
+// conditions, such as ValueEq.
+func KeyMapMatch(field string, sel *Selector) *Selector {
+	return subSelector(field, "$keyMapMatch", sel)
+}
+
+// Err returns the first error encountered while building the selector.
+func (s *Selector) Err() error {
+	return s.err
+}
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (s *Selector) MarshalJSON() ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return json.Marshal(s.expr)
+}
+
+// String renders the selector as JSON, for logging.
+func (s *Selector) String() string {
+	if s.err != nil {
+		return fmt.Sprintf("<invalid selector: %s>", s.err)
+	}
+	data, _ := json.Marshal(s.expr)
+	return string(data)
+}
+
+// MangoQuery is a typed builder for queries passed to Find and Explain. Create
+// a new query with NewMangoQuery.
+//
+// Example:
+//
+//	q := couchdb.NewMangoQuery(couchdb.Eq("type", "user")).
+//	    Fields("_id", "name").
+//	    Sort("name", couchdb.SortAsc).
+//	    Limit(25)
+//	rows, err := db.Find(ctx, q, nil)
+//
+// A *MangoQuery may be passed directly to Find and Explain, in which case it
+// is validated before any request is made.
+type MangoQuery struct {
+	selector *Selector
+	opts     map[string]interface{}
+	sort     []interface{}
+	sortDir  string
+	err      error
+}
+
+// NewMangoQuery returns a new query, matching documents which match sel.
+func NewMangoQuery(sel *Selector) *MangoQuery {
+	q := &MangoQuery{
+		selector: sel,
+		opts:     map[string]interface{}{},
+	}
+	if sel == nil {
+		q.err = mangoError("selector required")
+	}
+	return q
+}
+
+func (q *MangoQuery) set(key string, value interface{}) *MangoQuery {
+	q.opts[key] = value
+	return q
+}
+
+func (q *MangoQuery) fail(err error) *MangoQuery {
+	if q.err == nil {
+		q.err = err
+	}
+	return q
+}
+
+// Fields limits the fields returned for each document.
+func (q *MangoQuery) Fields(fields ...string) *MangoQuery {
+	for _, field := range fields {
+		if field == "" {
+			return q.fail(mangoError("empty field name"))
+		}
+	}
+	return q.set("fields", fields)
+}
+
+// Sort appends field to the sort order, in direction SortAsc or SortDesc.
+// CouchDB requires all fields to be sorted in the same direction.
+func (q *MangoQuery) Sort(field, direction string) *MangoQuery {
+	if field == "" {
+		return q.fail(mangoError("empty sort field name"))
+	}
+	if direction != SortAsc && direction != SortDesc {
+		return q.fail(mangoError("invalid sort direction `%s`", direction))
+	}
+	if q.sortDir != "" && q.sortDir != direction {
+		return q.fail(mangoError("all sort fields must have the same direction"))
+	}
+	q.sortDir = direction
+	q.sort = append(q.sort, map[string]string{field: direction})
+	return q
+}
+
+// Limit limits the number of documents returned.
+func (q *MangoQuery) Limit(limit int) *MangoQuery {
+	if limit < 0 {
+		return q.fail(mangoError("`limit` must not be negative"))
+	}
+	return q.set("limit", limit)
+}
+
+// Skip skips the specified number of documents before returning results.
+func (q *MangoQuery) Skip(skip int) *MangoQuery {
+	if skip < 0 {
+		return q.fail(mangoError("`skip` must not be negative"))
+	}
+	return q.set("skip", skip)
+}
+
+// Bookmark continues a previous query from the bookmark it returned.
+func (q *MangoQuery) Bookmark(bookmark string) *MangoQuery {
+	if bookmark == "" {
+		delete(q.opts, "bookmark")
+		return q
+	}
+	return q.set("bookmark", bookmark)
+}
+
+// UseIndex instructs CouchDB to use the index in the named design document. If
+// name is empty, any index in the design document may be used.
+func (q *MangoQuery) UseIndex(ddoc, name string) *MangoQuery {
+	if ddoc == "" {
+		return q.fail(mangoError("`use_index` requires a design document"))
+	}
+	if name == "" {
+		return q.set("use_index", ddoc)
+	}
+	return q.set("use_index", []string{ddoc, name})
+}
+
+// R sets the read quorum.
+func (q *MangoQuery) R(r int) *MangoQuery {
+	if r < 1 {
+		return q.fail(mangoError("`r` must be positive"))
+	}
+	return q.set("r", r)
+}
+
+// Stable, when true, requests that the same shard replicas be used for every
+// request.
+func (q *MangoQuery) Stable(stable bool) *MangoQuery { return q.set("stable", stable) }
+
+// Update controls whether the index is updated before the results are
+// returned.
+func (q *MangoQuery) Update(update bool) *MangoQuery { return q.set("update", update) }
+
+// ExecutionStats includes execution statistics in the response.
+func (q *MangoQuery) ExecutionStats(stats bool) *MangoQuery {
+	return q.set("execution_stats", stats)
+}
+
+// Query validates the query, and returns it as a map, suitable for passing to
+// Find or Explain.
+func (q *MangoQuery) Query() (map[string]interface{}, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if err := q.selector.Err(); err != nil {
+		return nil, err
+	}
+	query := make(map[string]interface{}, len(q.opts)+2)
+	for k, v := range q.opts {
+		query[k] = v
+	}
+	query["selector"] = q.selector.expr
+	if len(q.sort) > 0 {
+		query["sort"] = q.sort
+	}
+	return query, nil
+}
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (q *MangoQuery) MarshalJSON() ([]byte, error) {
+	query, err := q.Query()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(query)
+}
+
+// String renders the query as JSON, for logging.
+func (q *MangoQuery) String() string {
+	data, err := q.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("<invalid query: %s>", err)
+	}
+	return string(data)
+}
+
+// resolveMangoQuery validates query, if it is a *MangoQuery, so that errors
+// are reported before a request is made.
+func resolveMangoQuery(query interface{}) (interface{}, error) {
+	if q, ok := query.(*MangoQuery); ok {
+		return q.Query()
+	}
+	return query, nil
+}
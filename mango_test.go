@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestSelector(t *testing.T) {
+	type tst struct {
+		sel      *Selector
+		expected string
+		status   int
+		err      string
+	}
+	tests := testy.NewTable()
+	tests.Add("eq", tst{
+		sel:      Eq("name", "bob"),
+		expected: `{"name":{"$eq":"bob"}}`,
+	})
+	tests.Add("combination", tst{
+		sel: And(
+			Gte("age", 18),
+			Or(In("role", "admin", "editor"), Not(Exists("banned", true))),
+			Nor(Type("email", "null"), Size("tags", 0)),
+		),
+		expected: `{"$and":[{"age":{"$gte":18}},{"$or":[{"role":{"$in":["admin","editor"]}},{"$not":{"banned":{"$exists":true}}}]},{"$nor":[{"email":{"$type":"null"}},{"tags":{"$size":0}}]}]}`,
+	})
+	tests.Add("sub-selectors", tst{
+		sel: And(
+			ElemMatch("scores", Gt("value", 5)),
+			AllMatch("tags", Regex("name", "^a")),
+			KeyMapMatch("cameras", ValueEq("dslr")),
+			Mod("count", 3, 1),
+		),
+		expected: `{"$and":[{"scores":{"$elemMatch":{"value":{"$gt":5}}}},{"tags":{"$allMatch":{"name":{"$regex":"^a"}}}},{"cameras":{"$keyMapMatch":{"$eq":"dslr"}}},{"count":{"$mod":[3,1]}}]}`,
+	})
+	tests.Add("value conditions", tst{
+		sel: And(
+			ElemMatch("tags", ValueEq("x")),
+			AllMatch("ratings", And(ValueGte(1), ValueLte(5))),
+			ElemMatch("codes", ValueIn("a", "b")),
+			KeyMapMatch("labels", ValueRegex("^env-")),
+		),
+		expected: `{"$and":[{"tags":{"$elemMatch":{"$eq":"x"}}},{"ratings":{"$allMatch":{"$and":[{"$gte":1},{"$lte":5}]}}},{"codes":{"$elemMatch":{"$in":["a","b"]}}},{"labels":{"$keyMapMatch":{"$regex":"^env-"}}}]}`,
+	})
+	tests.Add("unmarshalable value condition", tst{
+		sel:    ElemMatch("a", ValueNe(make(chan int))),
+		status: http.StatusBadRequest,
+		err:    "kivik: $ne: invalid argument: json: unsupported type: chan int",
+	})
+	tests.Add("empty in", tst{
+		sel:      Nin("x"),
+		expected: `{"x":{"$nin":[]}}`,
+	})
+	tests.Add("empty and", tst{
+		sel:    And(),
+		status: http.StatusBadRequest,
+		err:    "kivik: $and requires at least one selector",
+	})
+	tests.Add("nested error", tst{
+		sel:    Or(Eq("a", 1), Not(Type("b", "integer"))),
+		status: http.StatusBadRequest,
+		err:    "kivik: $type: invalid type `integer`",
+	})
+	tests.Add("nil selector", tst{
+		sel:    ElemMatch("a", nil),
+		status: http.StatusBadRequest,
+		err:    "kivik: $elemMatch: selector required",
+	})
+	tests.Add("no field", tst{
+		sel:    Lt("", 3),
+		status: http.StatusBadRequest,
+		err:    "kivik: $lt: field name required",
+	})
+	tests.Add("unmarshalable value", tst{
+		sel:    Eq("a", make(chan int)),
+		status: http.StatusBadRequest,
+		err:    "kivik: $eq: invalid argument for `a`: json: unsupported type: chan int",
+	})
+	tests.Add("zero divisor", tst{
+		sel:    Mod("a", 0, 1),
+		status: http.StatusBadRequest,
+		err:    "kivik: $mod: divisor must not be zero",
+	})
+	tests.Add("negative size", tst{
+		sel:    Size("a", -1),
+		status: http.StatusBadRequest,
+		err:    "kivik: $size: size must not be negative",
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		testy.StatusError(t, test.err, test.status, test.sel.Err())
+		if test.err != "" {
+			return
+		}
+		if d := testy.DiffText(test.expected, test.sel.String()); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestMangoQuery(t *testing.T) {
+	type tst struct {
+		query    *MangoQuery
+		expected string
+		status   int
+		err      string
+	}
+	tests := testy.NewTable()
+	tests.Add("selector only", tst{
+		query:    NewMangoQuery(Eq("a", 1)),
+		expected: `{"selector":{"a":{"$eq":1}}}`,
+	})
+	tests.Add("all options", tst{
+		query: NewMangoQuery(Eq("type", "user")).
+			Fields("_id", "name").
+			Sort("type", SortDesc).
+			Sort("name", SortDesc).
+			Limit(10).
+			Skip(5).
+			Bookmark("abc").
+			UseIndex("ddoc", "idx").
+			R(2).
+			Stable(true).
+			Update(false).
+			ExecutionStats(true),
+		expected: `{"bookmark":"abc","execution_stats":true,"fields":["_id","name"],"limit":10,"r":2,"selector":{"type":{"$eq":"user"}},"skip":5,"sort":[{"type":"desc"},{"name":"desc"}],"stable":true,"update":false,"use_index":["ddoc","idx"]}`,
+	})
+	tests.Add("design doc index", tst{
+		query:    NewMangoQuery(Eq("a", 1)).UseIndex("ddoc", ""),
+		expected: `{"selector":{"a":{"$eq":1}},"use_index":"ddoc"}`,
+	})
+	tests.Add("nil selector", tst{
+		query:  NewMangoQuery(nil),
+		status: http.StatusBadRequest,
+		err:    "kivik: selector required",
+	})
+	tests.Add("invalid selector", tst{
+		query:  NewMangoQuery(Or()),
+		status: http.StatusBadRequest,
+		err:    "kivik: $or requires at least one selector",
+	})
+	tests.Add("mixed sort directions", tst{
+		query:  NewMangoQuery(Eq("a", 1)).Sort("a", SortAsc).Sort("b", SortDesc),
+		status: http.StatusBadRequest,
+		err:    "kivik: all sort fields must have the same direction",
+	})
+	tests.Add("invalid sort direction", tst{
+		query:  NewMangoQuery(Eq("a", 1)).Sort("a", "up"),
+		status: http.StatusBadRequest,
+		err:    "kivik: invalid sort direction `up`",
+	})
+	tests.Add("negative limit", tst{
+		query:  NewMangoQuery(Eq("a", 1)).Limit(-1),
+		status: http.StatusBadRequest,
+		err:    "kivik: `limit` must not be negative",
+	})
+	tests.Add("invalid r", tst{
+		query:  NewMangoQuery(Eq("a", 1)).R(0),
+		status: http.StatusBadRequest,
+		err:    "kivik: `r` must be positive",
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		_, err := test.query.Query()
+		testy.StatusError(t, test.err, test.status, err)
+		if d := testy.DiffText(test.expected, test.query.String()); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestFindMangoQuery(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		db := newCustomDB(func(*http.Request) (*http.Response, error) {
+			t.Fatal("unexpected request")
+			return nil, nil
+		})
+		_, err := db.Find(context.Background(), NewMangoQuery(Eq("a", 1)).Limit(-1), nil)
+		testy.StatusError(t, "kivik: `limit` must not be negative", http.StatusBadRequest, err)
+	})
+	t.Run("valid", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			expected := `{"limit":5,"selector":{"a":{"$gt":1}}}` + "\n"
+			if d := testy.DiffText(expected, string(body)); d != nil {
+				t.Error(d)
+			}
+			return jsonResponse(http.StatusOK, `{"docs":[]}`), nil
+		})
+		rows, err := db.Find(context.Background(), NewMangoQuery(Gt("a", 1)).Limit(5), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+	})
+}
@@ -0,0 +1,463 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Mango index types.
+const (
+	IndexTypeJSON = "json"
+	IndexTypeText = "text"
+)
+
+// IndexManager is implemented by the DB handles returned by this driver. It
+// provides typed access to Mango index definitions, and declarative index
+// management.
+type IndexManager interface {
+	// MangoIndexes returns the typed definitions of the database's indexes,
+	// excluding the special _all_docs index.
+	MangoIndexes(ctx context.Context) ([]MangoIndex, error)
+	// CreateMangoIndex creates index. Unlike CreateIndex, it supports text
+	// indexes and the partitioned flag.
+	CreateMangoIndex(ctx context.Context, index MangoIndex) error
+	// DeleteMangoIndex deletes index, which must have DesignDoc, Name and
+	// Type set.
+	DeleteMangoIndex(ctx context.Context, index MangoIndex) error
+	// EnsureIndexes compares desired against the database's indexes, and
+	// deletes every index which is not in desired, or whose definition
+	// differs, and creates every index in desired which does not exist. It
+	// returns the changes made, or to be made if opts.DryRun is true.
+	EnsureIndexes(ctx context.Context, desired []MangoIndex, opts *EnsureIndexesOptions) (*IndexPlan, error)
+}
+
+var _ IndexManager = &db{}
+
+// IndexField is a field of a JSON index, with its sort direction.
+type IndexField struct {
+	Name string
+	// Direction is SortAsc or SortDesc. Empty is the same as SortAsc.
+	Direction string
+}
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (f IndexField) MarshalJSON() ([]byte, error) {
+	dir := f.Direction
+	if dir == "" {
+		dir = SortAsc
+	}
+	return json.Marshal(map[string]string{f.Name: dir})
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface. It accepts both a
+// bare field name, and an object mapping the name to its direction.
+func (f *IndexField) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*f = IndexField{Direction: SortAsc}
+		return json.Unmarshal(data, &f.Name)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if len(m) != 1 {
+		return fmt.Errorf("invalid index field: %s", string(data))
+	}
+	for name, dir := range m {
+		*f = IndexField{Name: name, Direction: dir}
+	}
+	return nil
+}
+
+// TextIndexField is a field of a text index.
+type TextIndexField struct {
+	Name string `json:"name"`
+	// Type is one of "string", "number" or "boolean".
+	Type string `json:"type"`
+}
+
+// MangoIndex is a typed Mango index definition.
+type MangoIndex struct {
+	// DesignDoc is the name of the design document holding the index,
+	// without the _design/ prefix.
+	DesignDoc string
+	// Name is the name of the index.
+	Name string
+	// Type is IndexTypeJSON or IndexTypeText. Empty is the same as
+	// IndexTypeJSON.
+	Type string
+	// Fields are the fields of a JSON index.
+	Fields []IndexField
+	// TextFields are the fields of a text index. If empty, a text index
+	// covers all fields.
+	TextFields []TextIndexField
+	// PartialFilterSelector, if set, limits the index to documents matching
+	// the selector. It may be a *Selector, a map, or raw JSON. CouchDB
+	// normalizes selectors, so to compare equal to the definition read back
+	// from the server, selectors should use explicit operators, as those
+	// created by Eq, Gt, etc do.
+	PartialFilterSelector interface{}
+	// Partitioned, if set, creates a partitioned or global index, in a
+	// partitioned database. If nil, it is not compared by EnsureIndexes.
+	Partitioned *bool
+}
+
+func (i *MangoIndex) indexType() string {
+	if i.Type == "" {
+		return IndexTypeJSON
+	}
+	return i.Type
+}
+
+func (i *MangoIndex) key() string {
+	return strings.TrimPrefix(i.DesignDoc, "_design/") + "/" + i.Name
+}
+
+func (i *MangoIndex) validate() error {
+	switch i.indexType() {
+	case IndexTypeJSON:
+		if len(i.Fields) == 0 {
+			return mangoError("index `%s`: fields required", i.Name)
+		}
+		if len(i.TextFields) > 0 {
+			return mangoError("index `%s`: text fields are invalid for a JSON index", i.Name)
+		}
+		for _, f := range i.Fields {
+			if f.Name == "" {
+				return mangoError("index `%s`: empty field name", i.Name)
+			}
+			switch f.Direction {
+			case "", SortAsc, SortDesc:
+			default:
+				return mangoError("index `%s`: invalid sort direction `%s`", i.Name, f.Direction)
+			}
+		}
+	case IndexTypeText:
+		if len(i.Fields) > 0 {
+			return mangoError("index `%s`: use TextFields for a text index", i.Name)
+		}
+		for _, f := range i.TextFields {
+			switch f.Type {
+			case "string", "number", "boolean":
+			default:
+				return mangoError("index `%s`: invalid text field type `%s`", i.Name, f.Type)
+			}
+		}
+	default:
+		return mangoError("index `%s`: invalid index type `%s`", i.Name, i.Type)
+	}
+	_, err := i.partialFilter()
+	return err
+}
+
+// partialFilter returns the partial filter selector as a map, or nil.
+func (i *MangoIndex) partialFilter() (map[string]interface{}, error) {
+	if i.PartialFilterSelector == nil {
+		return nil, nil
+	}
+	sel, err := deJSONify(i.PartialFilterSelector)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(sel)
+	if err != nil {
+		return nil, mangoError("index `%s`: invalid partial filter selector: %s", i.Name, err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, mangoError("index `%s`: invalid partial filter selector: %s", i.Name, err)
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// definition returns the `index` object used to create the index.
+func (i *MangoIndex) definition() (map[string]interface{}, error) {
+	def := map[string]interface{}{}
+	if i.indexType() == IndexTypeText {
+		if len(i.TextFields) > 0 {
+			def["fields"] = i.TextFields
+		}
+	} else {
+		def["fields"] = i.Fields
+	}
+	pfs, err := i.partialFilter()
+	if err != nil {
+		return nil, err
+	}
+	if pfs != nil {
+		def["partial_filter_selector"] = pfs
+	}
+	return def, nil
+}
+
+// canonical returns a representation of the index definition which compares
+// equal for equivalent definitions.
+func (i *MangoIndex) canonical() (string, error) {
+	var fields interface{}
+	if i.indexType() == IndexTypeText {
+		tf := make([]TextIndexField, len(i.TextFields))
+		copy(tf, i.TextFields)
+		sort.Slice(tf, func(a, b int) bool { return tf[a].Name < tf[b].Name })
+		fields = tf
+	} else {
+		f := make([]IndexField, len(i.Fields))
+		for n, field := range i.Fields {
+			f[n] = IndexField{Name: field.Name, Direction: field.Direction}
+			if f[n].Direction == "" {
+				f[n].Direction = SortAsc
+			}
+		}
+		fields = f
+	}
+	pfs, err := i.partialFilter()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal([]interface{}{i.indexType(), fields, pfs})
+	return string(data), err
+}
+
+func (i *MangoIndex) matches(existing *MangoIndex) (bool, error) {
+	want, err := i.canonical()
+	if err != nil {
+		return false, err
+	}
+	have, err := existing.canonical()
+	if err != nil {
+		return false, err
+	}
+	if want != have {
+		return false, nil
+	}
+	if i.Partitioned != nil && existing.Partitioned != nil && *i.Partitioned != *existing.Partitioned {
+		return false, nil
+	}
+	return true, nil
+}
+
+type rawIndex struct {
+	DesignDoc   *string `json:"ddoc"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Partitioned *bool   `json:"partitioned"`
+	Def         struct {
+		Fields                json.RawMessage        `json:"fields"`
+		PartialFilterSelector map[string]interface{} `json:"partial_filter_selector"`
+		Selector              map[string]interface{} `json:"selector"`
+	} `json:"def"`
+}
+
+func (r *rawIndex) mangoIndex() (MangoIndex, error) {
+	idx := MangoIndex{
+		Name:        r.Name,
+		Type:        r.Type,
+		Partitioned: r.Partitioned,
+	}
+	if r.DesignDoc != nil {
+		idx.DesignDoc = strings.TrimPrefix(*r.DesignDoc, "_design/")
+	}
+	if len(r.Def.PartialFilterSelector) > 0 {
+		idx.PartialFilterSelector = r.Def.PartialFilterSelector
+	}
+	if len(r.Def.Selector) > 0 {
+		idx.PartialFilterSelector = r.Def.Selector
+	}
+	// Text indexes over all fields report "all_fields" rather than a list.
+	if len(r.Def.Fields) == 0 || r.Def.Fields[0] != '[' {
+		return idx, nil
+	}
+	if r.Type == IndexTypeText {
+		// Text index fields are reported as [{"name":"type"}].
+		var fields []map[string]string
+		if err := json.Unmarshal(r.Def.Fields, &fields); err != nil {
+			return idx, err
+		}
+		for _, field := range fields {
+			for name, typ := range field {
+				idx.TextFields = append(idx.TextFields, TextIndexField{Name: name, Type: typ})
+			}
+		}
+		return idx, nil
+	}
+	err := json.Unmarshal(r.Def.Fields, &idx.Fields)
+	return idx, err
+}
+
+func (d *db) MangoIndexes(ctx context.Context) ([]MangoIndex, error) {
+	var result struct {
+		Indexes []rawIndex `json:"indexes"`
+	}
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(pathIndex), nil, &result); err != nil {
+		return nil, err
+	}
+	indexes := make([]MangoIndex, 0, len(result.Indexes))
+	for _, raw := range result.Indexes {
+		if raw.Type == "special" {
+			continue
+		}
+		idx, err := raw.mangoIndex()
+		if err != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+func (d *db) CreateMangoIndex(ctx context.Context, index MangoIndex) error {
+	if err := index.validate(); err != nil {
+		return err
+	}
+	def, err := index.definition()
+	if err != nil {
+		return err
+	}
+	ddoc := index.DesignDoc
+	if ddoc != "" && !strings.HasPrefix(ddoc, "_design/") {
+		ddoc = "_design/" + ddoc
+	}
+	parameters := struct {
+		Index       interface{} `json:"index"`
+		Ddoc        string      `json:"ddoc,omitempty"`
+		Name        string      `json:"name,omitempty"`
+		Type        string      `json:"type"`
+		Partitioned *bool       `json:"partitioned,omitempty"`
+	}{
+		Index:       def,
+		Ddoc:        ddoc,
+		Name:        index.Name,
+		Type:        index.indexType(),
+		Partitioned: index.Partitioned,
+	}
+	options := &chttp.Options{
+		Body: chttp.EncodeBody(parameters),
+	}
+	_, err = d.Client.DoError(ctx, http.MethodPost, d.path(pathIndex), options)
+	return err
+}
+
+func (d *db) DeleteMangoIndex(ctx context.Context, index MangoIndex) error {
+	if index.DesignDoc == "" {
+		return missingArg("DesignDoc")
+	}
+	if index.Name == "" {
+		return missingArg("Name")
+	}
+	path := fmt.Sprintf("%s/%s/%s/%s", pathIndex,
+		strings.TrimPrefix(index.DesignDoc, "_design/"), index.indexType(), url.PathEscape(index.Name))
+	_, err := d.Client.DoError(ctx, http.MethodDelete, d.path(path), nil)
+	return err
+}
+
+// EnsureIndexesOptions are optional parameters to EnsureIndexes.
+type EnsureIndexesOptions struct {
+	// DryRun, when true, computes the plan without changing any index.
+	DryRun bool
+}
+
+// IndexPlan is the set of changes made, or to be made, by EnsureIndexes. An
+// index whose definition changed appears in both lists.
+type IndexPlan struct {
+	Delete []MangoIndex
+	Create []MangoIndex
+}
+
+// Empty returns true if the plan contains no changes.
+func (p *IndexPlan) Empty() bool {
+	return len(p.Delete) == 0 && len(p.Create) == 0
+}
+
+// String renders the plan in a human-readable form, one change per line.
+func (p *IndexPlan) String() string {
+	var buf bytes.Buffer
+	for _, idx := range p.Delete {
+		fmt.Fprintf(&buf, "- %s %s\n", idx.indexType(), idx.key())
+	}
+	for _, idx := range p.Create {
+		fmt.Fprintf(&buf, "+ %s %s\n", idx.indexType(), idx.key())
+	}
+	return buf.String()
+}
+
+func (d *db) EnsureIndexes(ctx context.Context, desired []MangoIndex, opts *EnsureIndexesOptions) (*IndexPlan, error) {
+	if opts == nil {
+		opts = &EnsureIndexesOptions{}
+	}
+	want := make(map[string]*MangoIndex, len(desired))
+	for n := range desired {
+		idx := &desired[n]
+		if idx.DesignDoc == "" || idx.Name == "" {
+			return nil, mangoError("index %d: design doc and name required", n)
+		}
+		if err := idx.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := want[idx.key()]; ok {
+			return nil, mangoError("index `%s` specified more than once", idx.key())
+		}
+		want[idx.key()] = idx
+	}
+	existing, err := d.MangoIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan := &IndexPlan{}
+	current := make(map[string]bool, len(existing))
+	for n := range existing {
+		idx := &existing[n]
+		if w, ok := want[idx.key()]; ok {
+			same, err := w.matches(idx)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				current[idx.key()] = true
+				continue
+			}
+		}
+		plan.Delete = append(plan.Delete, *idx)
+	}
+	for _, idx := range desired {
+		if !current[idx.key()] {
+			plan.Create = append(plan.Create, idx)
+		}
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+	for _, idx := range plan.Delete {
+		if err := d.DeleteMangoIndex(ctx, idx); err != nil {
+			return plan, err
+		}
+	}
+	for _, idx := range plan.Create {
+		if err := d.CreateMangoIndex(ctx, idx); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
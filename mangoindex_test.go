@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+const indexesResponse = `{"total_rows":5,"indexes":[
+{"ddoc":null,"name":"_all_docs","type":"special","def":{"fields":[{"_id":"asc"}]}},
+{"ddoc":"_design/app","name":"by-type","type":"json","partitioned":false,"def":{"fields":[{"type":"asc"},{"created":"asc"}],"partial_filter_selector":{"status":{"$ne":"archived"}}}},
+{"ddoc":"_design/app","name":"by-name","type":"json","partitioned":false,"def":{"fields":[{"name":"asc"}]}},
+{"ddoc":"_design/old","name":"by-date","type":"json","partitioned":false,"def":{"fields":[{"date":"desc"}]}},
+{"ddoc":"_design/search","name":"fulltext","type":"text","partitioned":false,"def":{"default_analyzer":"keyword","default_field":{},"selector":{},"fields":[{"title":"string"},{"body":"string"}],"index_array_lengths":true}}
+]}`
+
+func desiredIndexes() []MangoIndex {
+	return []MangoIndex{
+		{
+			DesignDoc:             "app",
+			Name:                  "by-type",
+			Fields:                []IndexField{{Name: "type"}, {Name: "created", Direction: SortAsc}},
+			PartialFilterSelector: Ne("status", "archived"),
+		},
+		{
+			DesignDoc: "app",
+			Name:      "by-name",
+			Fields:    []IndexField{{Name: "name", Direction: SortDesc}},
+		},
+		{
+			DesignDoc:  "search",
+			Name:       "fulltext",
+			Type:       IndexTypeText,
+			TextFields: []TextIndexField{{Name: "body", Type: "string"}, {Name: "title", Type: "string"}},
+		},
+		{
+			DesignDoc:   "app",
+			Name:        "by-owner",
+			Fields:      []IndexField{{Name: "owner"}},
+			Partitioned: func() *bool { b := true; return &b }(),
+		},
+	}
+}
+
+// indexHandler serves indexesResponse from _index, and accepts any change.
+func indexHandler(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		return jsonResponse(http.StatusOK, indexesResponse), nil
+	}
+	return jsonResponse(http.StatusOK, `{"ok":true}`), nil
+}
+
+func TestIndexFieldJSON(t *testing.T) {
+	var fields []IndexField
+	if err := json.Unmarshal([]byte(`["a",{"b":"desc"}]`), &fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := []IndexField{{Name: "a", Direction: SortAsc}, {Name: "b", Direction: SortDesc}}
+	if d := testy.DiffInterface(expected, fields); d != nil {
+		t.Error(d)
+	}
+	data, err := json.Marshal([]IndexField{{Name: "a"}, {Name: "b", Direction: SortDesc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffText(`[{"a":"asc"},{"b":"desc"}]`, string(data)); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestMangoIndexes(t *testing.T) {
+	indexes, err := newCustomDB(indexHandler).MangoIndexes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := false
+	expected := []MangoIndex{
+		{
+			DesignDoc:             "app",
+			Name:                  "by-type",
+			Type:                  IndexTypeJSON,
+			Fields:                []IndexField{{Name: "type", Direction: SortAsc}, {Name: "created", Direction: SortAsc}},
+			PartialFilterSelector: map[string]interface{}{"status": map[string]interface{}{"$ne": "archived"}},
+			Partitioned:           &f,
+		},
+		{
+			DesignDoc:   "app",
+			Name:        "by-name",
+			Type:        IndexTypeJSON,
+			Fields:      []IndexField{{Name: "name", Direction: SortAsc}},
+			Partitioned: &f,
+		},
+		{
+			DesignDoc:   "old",
+			Name:        "by-date",
+			Type:        IndexTypeJSON,
+			Fields:      []IndexField{{Name: "date", Direction: SortDesc}},
+			Partitioned: &f,
+		},
+		{
+			DesignDoc:   "search",
+			Name:        "fulltext",
+			Type:        IndexTypeText,
+			TextFields:  []TextIndexField{{Name: "title", Type: "string"}, {Name: "body", Type: "string"}},
+			Partitioned: &f,
+		},
+	}
+	if d := testy.DiffInterface(expected, indexes); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	t.Run("dry run", func(t *testing.T) {
+		var requests []string
+		plan, err := newRecordingDB(&requests, indexHandler).EnsureIndexes(context.Background(), desiredIndexes(), &EnsureIndexesOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "- json app/by-name\n- json old/by-date\n+ json app/by-name\n+ json app/by-owner\n"
+		if d := testy.DiffText(expected, plan.String()); d != nil {
+			t.Error(d)
+		}
+		if d := testy.DiffInterface([]string{"GET /testdb/_index"}, requests); d != nil {
+			t.Errorf("Unexpected requests in dry run:\n%s", d)
+		}
+	})
+	t.Run("apply", func(t *testing.T) {
+		var requests []string
+		plan, err := newRecordingDB(&requests, indexHandler).EnsureIndexes(context.Background(), desiredIndexes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Empty() {
+			t.Error("Expected a non-empty plan")
+		}
+		expected := []string{
+			"GET /testdb/_index",
+			"DELETE /testdb/_index/app/json/by-name",
+			"DELETE /testdb/_index/old/json/by-date",
+			`POST /testdb/_index {"index":{"fields":[{"name":"desc"}]},"ddoc":"_design/app","name":"by-name","type":"json"}`,
+			`POST /testdb/_index {"index":{"fields":[{"owner":"asc"}]},"ddoc":"_design/app","name":"by-owner","type":"json","partitioned":true}`,
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			desired []MangoIndex
+			err     string
+		}{
+			{
+				name:    "missing name",
+				desired: []MangoIndex{{DesignDoc: "a", Fields: []IndexField{{Name: "x"}}}},
+				err:     "kivik: index 0: design doc and name required",
+			},
+			{
+				name:    "no fields",
+				desired: []MangoIndex{{DesignDoc: "a", Name: "b"}},
+				err:     "kivik: index `b`: fields required",
+			},
+			{
+				name:    "invalid direction",
+				desired: []MangoIndex{{DesignDoc: "a", Name: "b", Fields: []IndexField{{Name: "x", Direction: "up"}}}},
+				err:     "kivik: index `b`: invalid sort direction `up`",
+			},
+			{
+				name:    "invalid text field",
+				desired: []MangoIndex{{DesignDoc: "a", Name: "b", Type: IndexTypeText, TextFields: []TextIndexField{{Name: "x", Type: "date"}}}},
+				err:     "kivik: index `b`: invalid text field type `date`",
+			},
+			{
+				name: "duplicate",
+				desired: []MangoIndex{
+					{DesignDoc: "a", Name: "b", Fields: []IndexField{{Name: "x"}}},
+					{DesignDoc: "_design/a", Name: "b", Fields: []IndexField{{Name: "y"}}},
+				},
+				err: "kivik: index `a/b` specified more than once",
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				_, err := newCustomDB(indexHandler).EnsureIndexes(context.Background(), test.desired, nil)
+				testy.StatusError(t, test.err, http.StatusBadRequest, err)
+			})
+		}
+	})
+}
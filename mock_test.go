@@ -13,9 +13,11 @@
 package couchdb
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"strings"
@@ -50,6 +52,31 @@ func newCustomDB(fn func(*http.Request) (*http.Response, error)) *db {
 	}
 }
 
+// newRecordingDB returns a test database which records each request in
+// requests, as its method and URI followed by any body, before answering it
+// with fn. The body remains readable by fn. Multipart bodies, whose
+// boundaries are random, are recorded as "<multipart>".
+func newRecordingDB(requests *[]string, fn func(*http.Request) (*http.Response, error)) *db {
+	return newCustomDB(func(req *http.Request) (*http.Response, error) {
+		entry := req.Method + " " + req.URL.RequestURI()
+		if req.Body != nil {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); ct == typeMPRelated {
+				body = []byte("<multipart>")
+			}
+			if body = bytes.TrimSpace(body); len(body) > 0 {
+				entry += " " + string(body)
+			}
+		}
+		*requests = append(*requests, entry)
+		return fn(req)
+	})
+}
+
 func newTestClient(response *http.Response, err error) *client {
 	return newCustomClient(func(req *http.Request) (*http.Response, error) {
 		if e := consume(req.Body); e != nil {
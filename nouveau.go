@@ -0,0 +1,230 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// NouveauSearcher is implemented by the DB handles returned by this driver. It
+// queries Nouveau search indexes, added in CouchDB 3.4. Whether the server
+// supports Nouveau can be checked with HasFeature and FeatureNouveau.
+type NouveauSearcher interface {
+	// NouveauSearch queries the named Nouveau index of ddoc. query is the
+	// Lucene query. opts are sent in the request body, so may include any
+	// parameter of the _nouveau endpoint, such as sort, limit, bookmark,
+	// counts, ranges, include_docs or update.
+	//
+	// Each row has the document ID, the sort order as its key, the stored
+	// fields as its value, and the document, if include_docs was set.
+	// TotalRows returns the number of hits. The returned rows implement
+	// driver.Bookmarker and NouveauReporter.
+	NouveauSearch(ctx context.Context, ddoc, index, query string, opts map[string]interface{}) (driver.Rows, error)
+	// NouveauInfo returns information about the named Nouveau index of ddoc.
+	NouveauInfo(ctx context.Context, ddoc, index string) (*NouveauInfo, error)
+}
+
+var _ NouveauSearcher = &db{}
+
+// NouveauReporter is implemented by the rows returned by NouveauSearch.
+type NouveauReporter interface {
+	// TotalHitsRelation returns "EQUAL_TO" if TotalRows is the exact number
+	// of hits, or "GREATER_THAN_OR_EQUAL_TO" if it is a lower bound.
+	TotalHitsRelation() string
+	// Counts returns the facet counts requested with the counts option, by
+	// field and value.
+	Counts() map[string]map[string]int64
+	// Ranges returns the facet counts requested with the ranges option, by
+	// field and range label.
+	Ranges() map[string]map[string]int64
+}
+
+// NouveauInfo is the response to
+// GET /{db}/_design/{ddoc}/_nouveau_info/{index}.
+type NouveauInfo struct {
+	Name        string           `json:"name"`
+	SearchIndex NouveauIndexInfo `json:"search_index"`
+	RawResponse json.RawMessage  `json:"-"`
+}
+
+// NouveauIndexInfo describes the state of a Nouveau index.
+type NouveauIndexInfo struct {
+	UpdateSeq int64  `json:"update_seq"`
+	PurgeSeq  int64  `json:"purge_seq"`
+	NumDocs   int64  `json:"num_docs"`
+	DiskSize  int64  `json:"disk_size"`
+	Signature string `json:"signature"`
+}
+
+type nouveauMeta struct {
+	totalHits         int64
+	totalHitsRelation string
+	bookmark          string
+	counts            map[string]map[string]int64
+	ranges            map[string]map[string]int64
+}
+
+func (m *nouveauMeta) parseMeta(key string, dec *json.Decoder) error {
+	switch key {
+	case "total_hits":
+		return dec.Decode(&m.totalHits)
+	case "total_hits_relation":
+		return dec.Decode(&m.totalHitsRelation)
+	case "bookmark":
+		return dec.Decode(&m.bookmark)
+	case "counts":
+		return dec.Decode(&m.counts)
+	case "ranges":
+		return dec.Decode(&m.ranges)
+	}
+	// Skip keys added by newer versions of CouchDB, such as update_latency.
+	var ignored json.RawMessage
+	return dec.Decode(&ignored)
+}
+
+type nouveauParser struct{}
+
+var _ parser = &nouveauParser{}
+
+func (p *nouveauParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
+	return i.(*nouveauMeta).parseMeta(key, dec)
+}
+
+func (p *nouveauParser) decodeItem(i interface{}, dec *json.Decoder) error {
+	var hit struct {
+		ID     string          `json:"id"`
+		Order  json.RawMessage `json:"order"`
+		Fields json.RawMessage `json:"fields"`
+		Doc    json.RawMessage `json:"doc"`
+	}
+	if err := dec.Decode(&hit); err != nil {
+		return err
+	}
+	row := i.(*driver.Row)
+	row.ID = hit.ID
+	row.Key = hit.Order
+	row.Value = hit.Fields
+	row.Doc = hit.Doc
+	return nil
+}
+
+type nouveauRows struct {
+	*iter
+	*nouveauMeta
+}
+
+var (
+	_ driver.Rows       = &nouveauRows{}
+	_ driver.Bookmarker = &nouveauRows{}
+	_ NouveauReporter   = &nouveauRows{}
+)
+
+func newNouveauRows(ctx context.Context, in io.ReadCloser) *nouveauRows {
+	meta := &nouveauMeta{}
+	return &nouveauRows{
+		iter:        newIter(ctx, meta, "hits", in, &nouveauParser{}),
+		nouveauMeta: meta,
+	}
+}
+
+func (r *nouveauRows) Next(row *driver.Row) error {
+	row.Error = nil
+	return r.iter.next(row)
+}
+
+func (r *nouveauRows) Offset() int64                       { return 0 }
+func (r *nouveauRows) TotalRows() int64                    { return r.totalHits }
+func (r *nouveauRows) UpdateSeq() string                   { return "" }
+func (r *nouveauRows) Bookmark() string                    { return r.bookmark }
+func (r *nouveauRows) TotalHitsRelation() string           { return r.totalHitsRelation }
+func (r *nouveauRows) Counts() map[string]map[string]int64 { return r.counts }
+func (r *nouveauRows) Ranges() map[string]map[string]int64 { return r.ranges }
+
+func nouveauPath(ddoc, endpoint, index string) string {
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+	return fmt.Sprintf("_design/%s/%s/%s", chttp.EncodeDocID(ddoc), endpoint, chttp.EncodeDocID(index))
+}
+
+// nouveauError replaces the error returned by a server without Nouveau
+// support, which does not know the _nouveau endpoints, with a clearer one.
+func (d *db) nouveauError(ctx context.Context, err error) error {
+	switch kivik.StatusCode(err) {
+	case http.StatusBadRequest, http.StatusNotFound:
+	default:
+		return err
+	}
+	if ok, e := d.hasFeature(ctx, FeatureNouveau); e != nil || ok {
+		return err
+	}
+	return &kivik.Error{HTTPStatus: http.StatusNotImplemented, Err: errors.New("kivik: server does not support Nouveau")}
+}
+
+func (d *db) NouveauSearch(ctx context.Context, ddoc, index, query string, opts map[string]interface{}) (driver.Rows, error) {
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	if index == "" {
+		return nil, missingArg("index")
+	}
+	if query == "" {
+		return nil, missingArg("query")
+	}
+	body := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		body[k] = v
+	}
+	body["q"] = query
+	options := &chttp.Options{
+		GetBody: chttp.BodyEncoder(body),
+		Header: http.Header{
+			chttp.HeaderIdempotencyKey: []string{},
+		},
+	}
+	resp, err := d.Client.DoReq(ctx, http.MethodPost, d.path(nouveauPath(ddoc, "_nouveau", index)), options)
+	if err != nil {
+		return nil, err
+	}
+	if err = chttp.ResponseError(resp); err != nil {
+		return nil, d.nouveauError(ctx, err)
+	}
+	return newNouveauRows(ctx, resp.Body), nil
+}
+
+func (d *db) NouveauInfo(ctx context.Context, ddoc, index string) (*NouveauInfo, error) {
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	if index == "" {
+		return nil, missingArg("index")
+	}
+	var raw json.RawMessage
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(nouveauPath(ddoc, "_nouveau_info", index)), nil, &raw); err != nil {
+		return nil, d.nouveauError(ctx, err)
+	}
+	info := &NouveauInfo{RawResponse: raw}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+	return info, nil
+}
@@ -0,0 +1,147 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+func TestNouveauSearch(t *testing.T) {
+	t.Run("validation", func(t *testing.T) {
+		db := newTestDB(nil, nil)
+		_, err := db.NouveauSearch(context.Background(), "", "idx", "q", nil)
+		testy.StatusError(t, "kivik: ddoc required", http.StatusBadRequest, err)
+		_, err = db.NouveauSearch(context.Background(), "ddoc", "", "q", nil)
+		testy.StatusError(t, "kivik: index required", http.StatusBadRequest, err)
+		_, err = db.NouveauSearch(context.Background(), "ddoc", "idx", "", nil)
+		testy.StatusError(t, "kivik: query required", http.StatusBadRequest, err)
+	})
+	t.Run("hits", func(t *testing.T) {
+		var body map[string]interface{}
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodPost || req.URL.Path != "/testdb/_design/products/_nouveau/by-text" {
+				t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(http.StatusOK, `{"total_hits_relation":"EQUAL_TO","update_latency":12,"total_hits":2,"ranges":null,"counts":{"color":{"red":2}},"hits":[
+{"order":[{"@type":"float","value":1.5},{"@type":"string","value":"a"}],"id":"a","fields":{"name":"Red shirt"},"doc":{"_id":"a"}},
+{"order":[{"@type":"float","value":0.5},{"@type":"string","value":"b"}],"id":"b","fields":{"name":"Red hat"}}
+],"bookmark":"W3siQHR5cGUiOiJmbG9hdCJ9XQ=="}`), nil
+		})
+		rows, err := db.NouveauSearch(context.Background(), "_design/products", "by-text", "name:red", map[string]interface{}{
+			"include_docs": true,
+			"counts":       []string{"color"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedBody := map[string]interface{}{
+			"q":            "name:red",
+			"include_docs": true,
+			"counts":       []interface{}{"color"},
+		}
+		if d := testy.DiffInterface(expectedBody, body); d != nil {
+			t.Errorf("Unexpected request body:\n%s", d)
+		}
+		var results []string
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			results = append(results, row.ID+" "+string(row.Value)+" "+string(row.Doc))
+		}
+		expected := []string{
+			`a {"name":"Red shirt"} {"_id":"a"}`,
+			`b {"name":"Red hat"} `,
+		}
+		if d := testy.DiffInterface(expected, results); d != nil {
+			t.Error(d)
+		}
+		if rows.TotalRows() != 2 {
+			t.Errorf("Unexpected total rows: %d", rows.TotalRows())
+		}
+		if bm := rows.(driver.Bookmarker).Bookmark(); bm != "W3siQHR5cGUiOiJmbG9hdCJ9XQ==" {
+			t.Errorf("Unexpected bookmark: %s", bm)
+		}
+		reporter := rows.(NouveauReporter)
+		if rel := reporter.TotalHitsRelation(); rel != "EQUAL_TO" {
+			t.Errorf("Unexpected relation: %s", rel)
+		}
+		if d := testy.DiffInterface(map[string]map[string]int64{"color": {"red": 2}}, reporter.Counts()); d != nil {
+			t.Error(d)
+		}
+		if reporter.Ranges() != nil {
+			t.Errorf("Unexpected ranges: %v", reporter.Ranges())
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/" {
+				return jsonResponse(http.StatusOK, `{"couchdb":"Welcome","version":"3.3.3","features":["access-ready","partitioned","pluggable-storage-engines","reshard","scheduler"]}`), nil
+			}
+			return notFoundResponse(req), nil
+		})
+		_, err := db.NouveauSearch(context.Background(), "products", "by-text", "red", nil)
+		testy.StatusError(t, "kivik: server does not support Nouveau", http.StatusNotImplemented, err)
+	})
+	t.Run("missing index", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/" {
+				return jsonResponse(http.StatusOK, `{"couchdb":"Welcome","version":"3.4.1","features":["nouveau","partitioned"]}`), nil
+			}
+			return notFoundResponse(req), nil
+		})
+		_, err := db.NouveauSearch(context.Background(), "products", "by-text", "red", nil)
+		testy.StatusError(t, "Not Found", http.StatusNotFound, err)
+	})
+}
+
+func TestNouveauInfo(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/testdb/_design/products/_nouveau_info/by-text" {
+			t.Errorf("Unexpected path: %s", req.URL.Path)
+		}
+		return jsonResponse(http.StatusOK, `{"name":"_design/products/by-text","search_index":{"update_seq":12,"purge_seq":0,"num_docs":42,"disk_size":8192,"signature":"f3a1"}}`), nil
+	})
+	info, err := db.NouveauInfo(context.Background(), "products", "by-text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.RawResponse = nil
+	expected := &NouveauInfo{
+		Name: "_design/products/by-text",
+		SearchIndex: NouveauIndexInfo{
+			UpdateSeq: 12,
+			NumDocs:   42,
+			DiskSize:  8192,
+			Signature: "f3a1",
+		},
+	}
+	if d := testy.DiffInterface(expected, info); d != nil {
+		t.Error(d)
+	}
+}
@@ -47,3 +47,16 @@ func ifNoneMatch(opts map[string]interface{}) (string, error) {
 	}
 	return inmString, nil
 }
+
+func lastEventID(opts map[string]interface{}) (string, error) {
+	id, ok := opts[OptionLastEventID]
+	if !ok {
+		return "", nil
+	}
+	idString, ok := id.(string)
+	if !ok {
+		return "", &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: option '%s' must be string, not %T", OptionLastEventID, id)}
+	}
+	delete(opts, OptionLastEventID)
+	return idString, nil
+}
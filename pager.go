@@ -0,0 +1,251 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// PageCursor identifies the first row of a page of view results.
+type PageCursor struct {
+	// Key is the raw JSON key of the first row of the page.
+	Key json.RawMessage `json:"k"`
+	// DocID is the document ID of the first row of the page. It is empty for
+	// _all_docs, where the key is the document ID, and for reduced results.
+	DocID string `json:"d,omitempty"`
+}
+
+// Encode returns the cursor as an opaque, URL-safe string, suitable for
+// passing to a client of a paginated API.
+func (c *PageCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodePageCursor decodes a cursor previously encoded with Encode.
+func DecodePageCursor(cursor string) (*PageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	c := &PageCursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	if len(c.Key) == 0 {
+		return nil, viewQueryError("invalid page cursor")
+	}
+	return c, nil
+}
+
+// Pager pages through the complete results of a view or _all_docs query, using
+// keyset pagination: each page is requested with the key and document ID of
+// the first row of that page, and one row more than the page size, which
+// becomes the start of the following page. Unlike paging with skip, each
+// request costs the server the same regardless of how deep into the results it
+// starts.
+type Pager struct {
+	query    func(context.Context, map[string]interface{}) (driver.Rows, error)
+	opts     map[string]interface{}
+	pageSize int
+	useDocID bool
+
+	cursor *PageCursor
+	done   bool
+	page   *pageRows
+}
+
+// NewViewPager returns a Pager over the named view. opts may contain any
+// option accepted by Query, except limit, skip, key and keys, which conflict
+// with paging. startkey and startkey_docid, if provided, set the start of the
+// first page.
+func NewViewPager(db driver.DB, ddoc, view string, pageSize int, opts map[string]interface{}) (*Pager, error) {
+	query := func(ctx context.Context, options map[string]interface{}) (driver.Rows, error) {
+		return db.Query(ctx, ddoc, view, options)
+	}
+	return newPager(query, true, pageSize, opts)
+}
+
+// NewAllDocsPager returns a Pager over _all_docs. opts are interpreted as by
+// NewViewPager.
+func NewAllDocsPager(db driver.DB, pageSize int, opts map[string]interface{}) (*Pager, error) {
+	return newPager(db.AllDocs, false, pageSize, opts)
+}
+
+func newPager(query func(context.Context, map[string]interface{}) (driver.Rows, error), useDocID bool, pageSize int, opts map[string]interface{}) (*Pager, error) {
+	if pageSize <= 0 {
+		return nil, viewQueryError("page size must be positive")
+	}
+	options, err := normalizeViewOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"limit", "skip", "key", "keys", "queries"} {
+		if _, ok := options[key]; ok {
+			return nil, viewQueryError("`%s` cannot be used with a pager", key)
+		}
+	}
+	p := &Pager{
+		query:    query,
+		pageSize: pageSize,
+		useDocID: useDocID,
+	}
+	if start, ok := options["startkey"]; ok {
+		key, err := encodeKey(start)
+		if err != nil {
+			return nil, err
+		}
+		p.cursor = &PageCursor{Key: json.RawMessage(key)}
+		p.cursor.DocID, _ = options["startkey_docid"].(string)
+	}
+	delete(options, "startkey")
+	delete(options, "startkey_docid")
+	p.opts = options
+	return p, nil
+}
+
+// Cursor returns the cursor for the next page, or nil if the first page has
+// not yet been requested without an explicit start key, or all pages have been
+// read. The cursor is only known once the current page has been read to the
+// end, or closed.
+func (p *Pager) Cursor() *PageCursor {
+	if p.done {
+		return nil
+	}
+	return p.cursor
+}
+
+// SetCursor sets the start of the next page, to resume paging from a saved
+// cursor.
+func (p *Pager) SetCursor(c *PageCursor) {
+	p.cursor = c
+	p.done = false
+}
+
+// Done returns true once the last page has been read.
+func (p *Pager) Done() bool {
+	return p.done
+}
+
+// Next returns a rows iterator over the next page of results. It returns
+// io.EOF when there are no more pages. Any previous page is closed.
+func (p *Pager) Next(ctx context.Context) (driver.Rows, error) {
+	if p.page != nil {
+		if err := p.page.Close(); err != nil {
+			return nil, err
+		}
+		p.page = nil
+	}
+	if p.done {
+		return nil, io.EOF
+	}
+	opts := make(map[string]interface{}, len(p.opts)+3)
+	for k, v := range p.opts {
+		opts[k] = v
+	}
+	opts["limit"] = p.pageSize + 1
+	if p.cursor != nil {
+		opts["startkey"] = p.cursor.Key
+		if p.useDocID && p.cursor.DocID != "" {
+			opts["startkey_docid"] = p.cursor.DocID
+		}
+	}
+	rows, err := p.query(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	p.page = &pageRows{
+		Rows:      rows,
+		pager:     p,
+		remaining: p.pageSize,
+	}
+	return p.page, nil
+}
+
+// pageRows wraps a rows iterator, returning at most pageSize rows, and
+// reading the following row to determine the next page's cursor.
+type pageRows struct {
+	driver.Rows
+	pager     *Pager
+	remaining int
+	finished  bool
+	closed    bool
+}
+
+var _ driver.Rows = &pageRows{}
+
+func (r *pageRows) Next(row *driver.Row) error {
+	if r.finished {
+		return io.EOF
+	}
+	if r.remaining == 0 {
+		return r.finish()
+	}
+	if err := r.Rows.Next(row); err != nil {
+		if err == io.EOF {
+			r.finished = true
+			r.pager.done = true
+		}
+		return err
+	}
+	r.remaining--
+	return nil
+}
+
+// finish reads the row following the last row of the page, and stores its
+// position as the pager's cursor.
+func (r *pageRows) finish() error {
+	r.finished = true
+	var next driver.Row
+	if err := r.Rows.Next(&next); err != nil {
+		if err == io.EOF {
+			r.pager.done = true
+		}
+		return err
+	}
+	r.pager.cursor = &PageCursor{
+		Key: append(json.RawMessage(nil), next.Key...),
+	}
+	if r.pager.useDocID {
+		r.pager.cursor.DocID = next.ID
+	}
+	return io.EOF
+}
+
+// Close reads any remaining rows of the page, so the next page's cursor is
+// known, then closes the underlying iterator.
+func (r *pageRows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	var err error
+	for err == nil {
+		var row driver.Row
+		err = r.Next(&row)
+	}
+	if e := r.Rows.Close(); e != nil {
+		return e
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+type viewRow struct {
+	key string
+	id  string
+}
+
+// viewHandler serves the rows of a single view, which has string keys,
+// honoring startkey, startkey_docid, descending and limit.
+func viewHandler(data []viewRow) func(*http.Request) (*http.Response, error) {
+	sort.Slice(data, func(i, j int) bool {
+		if data[i].key != data[j].key {
+			return data[i].key < data[j].key
+		}
+		return data[i].id < data[j].id
+	})
+	return func(req *http.Request) (*http.Response, error) {
+		query := req.URL.Query()
+		desc := query.Get("descending") == "true"
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		var startKey string
+		hasStart := query.Get("startkey") != ""
+		if hasStart {
+			if err := json.Unmarshal([]byte(query.Get("startkey")), &startKey); err != nil {
+				return nil, err
+			}
+		}
+		startID := query.Get("startkey_docid")
+		rows := make([]viewRow, len(data))
+		copy(rows, data)
+		if desc {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+		result := []string{}
+		for _, row := range rows {
+			if hasStart {
+				before := row.key < startKey || (row.key == startKey && startID != "" && row.id < startID)
+				if desc {
+					before = row.key > startKey || (row.key == startKey && startID != "" && row.id > startID)
+				}
+				if before {
+					continue
+				}
+			}
+			if len(result) == limit {
+				break
+			}
+			result = append(result, fmt.Sprintf(`{"id":%q,"key":%q,"value":null}`, row.id, row.key))
+		}
+		return jsonResponse(http.StatusOK, `{"total_rows":`+strconv.Itoa(len(data))+`,"offset":0,"rows":[`+strings.Join(result, ",")+`]}`), nil
+	}
+}
+
+func readPages(t *testing.T, pager *Pager) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		rows, err := pager.Next(context.Background())
+		if err == io.EOF {
+			return pages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page []string
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			page = append(page, row.ID)
+		}
+		pages = append(pages, page)
+	}
+}
+
+func TestPager(t *testing.T) {
+	data := []viewRow{
+		{"a", "1"}, {"b", "2"}, {"b", "3"}, {"b", "4"}, {"c", "5"}, {"d", "6"},
+	}
+	t.Run("duplicate keys", func(t *testing.T) {
+		var requests []string
+		pager, err := NewViewPager(newRecordingDB(&requests, viewHandler(data)), "ddoc", "view", 2, map[string]interface{}{"reduce": false})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := readPages(t, pager)
+		expected := [][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}
+		if d := testy.DiffInterface(expected, pages); d != nil {
+			t.Error(d)
+		}
+		expectedRequests := []string{
+			"GET /testdb/_design/ddoc/_view/view?limit=3&reduce=false",
+			"GET /testdb/_design/ddoc/_view/view?limit=3&reduce=false&startkey=%22b%22&startkey_docid=3",
+			"GET /testdb/_design/ddoc/_view/view?limit=3&reduce=false&startkey=%22c%22&startkey_docid=5",
+		}
+		if d := testy.DiffInterface(expectedRequests, requests); d != nil {
+			t.Error(d)
+		}
+		if !pager.Done() {
+			t.Error("Expected pager to be done")
+		}
+	})
+	t.Run("descending", func(t *testing.T) {
+		pager, err := NewViewPager(newCustomDB(viewHandler(data)), "ddoc", "view", 4, map[string]interface{}{"descending": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := readPages(t, pager)
+		expected := [][]string{{"6", "5", "4", "3"}, {"2", "1"}}
+		if d := testy.DiffInterface(expected, pages); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("resume from saved cursor", func(t *testing.T) {
+		d := newCustomDB(viewHandler(data))
+		pager, err := NewViewPager(d, "ddoc", "view", 3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := pager.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Closing the page early still determines the next cursor.
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		saved := pager.Cursor().Encode()
+
+		cursor, err := DecodePageCursor(saved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed, err := NewViewPager(d, "ddoc", "view", 3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed.SetCursor(cursor)
+		pages := readPages(t, resumed)
+		expected := [][]string{{"4", "5", "6"}}
+		if d := testy.DiffInterface(expected, pages); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("exact final page", func(t *testing.T) {
+		var requests []string
+		docs := []viewRow{{"a", "a"}, {"b", "b"}, {"c", "c"}, {"d", "d"}, {"e", "e"}, {"f", "f"}}
+		pager, err := NewAllDocsPager(newRecordingDB(&requests, viewHandler(docs)), 3, map[string]interface{}{"start_key": "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := readPages(t, pager)
+		expected := [][]string{{"a", "b", "c"}, {"d", "e", "f"}}
+		if d := testy.DiffInterface(expected, pages); d != nil {
+			t.Error(d)
+		}
+		expectedRequests := []string{
+			"GET /testdb/_all_docs?limit=4&startkey=%22a%22",
+			"GET /testdb/_all_docs?limit=4&startkey=%22d%22",
+		}
+		if d := testy.DiffInterface(expectedRequests, requests); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestNewPagerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int
+		opts     map[string]interface{}
+		err      string
+	}{
+		{
+			name:     "invalid page size",
+			pageSize: 0,
+			err:      "kivik: page size must be positive",
+		},
+		{
+			name:     "skip",
+			pageSize: 10,
+			opts:     map[string]interface{}{"skip": 10},
+			err:      "kivik: `skip` cannot be used with a pager",
+		},
+		{
+			name:     "unknown option",
+			pageSize: 10,
+			opts:     map[string]interface{}{"startkey_doc_id": "x"},
+			err:      "kivik: unknown view option `startkey_doc_id`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewViewPager(newTestDB(nil, nil), "ddoc", "view", test.pageSize, test.opts)
+			testy.StatusError(t, test.err, http.StatusBadRequest, err)
+		})
+	}
+}
+
+func TestDecodePageCursor(t *testing.T) {
+	c := &PageCursor{Key: json.RawMessage(`["a",1]`), DocID: "foo"}
+	result, err := DecodePageCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(c, result); d != nil {
+		t.Error(d)
+	}
+	_, err = DecodePageCursor("!!!")
+	testy.StatusErrorRE(t, "illegal base64", http.StatusBadRequest, err)
+}
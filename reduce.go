@@ -0,0 +1,341 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The built-in reduce functions.
+const (
+	ReduceSum                 = "_sum"
+	ReduceCount               = "_count"
+	ReduceStats               = "_stats"
+	ReduceApproxCountDistinct = "_approx_count_distinct"
+)
+
+// SumKind is the shape of a _sum result.
+type SumKind int
+
+// The shapes of a _sum result.
+const (
+	SumNumber SumKind = iota
+	SumArray
+	SumObject
+)
+
+// Sum is the result of the built-in _sum reduce function, which is a number,
+// an array of numbers, or an object whose values are themselves Sums,
+// according to the values emitted by the view.
+type Sum struct {
+	Kind   SumKind
+	Number float64
+	Array  []float64
+	Object map[string]Sum
+}
+
+var (
+	_ json.Marshaler   = Sum{}
+	_ json.Unmarshaler = &Sum{}
+)
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (s *Sum) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return errors.New("kivik: invalid _sum result")
+	}
+	switch data[0] {
+	case '[':
+		*s = Sum{Kind: SumArray}
+		return json.Unmarshal(data, &s.Array)
+	case '{':
+		*s = Sum{Kind: SumObject}
+		return json.Unmarshal(data, &s.Object)
+	}
+	*s = Sum{Kind: SumNumber}
+	return json.Unmarshal(data, &s.Number)
+}
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (s Sum) MarshalJSON() ([]byte, error) {
+	switch s.Kind {
+	case SumArray:
+		if s.Array == nil {
+			return []byte("[]"), nil
+		}
+		return json.Marshal(s.Array)
+	case SumObject:
+		if s.Object == nil {
+			return []byte("{}"), nil
+		}
+		return json.Marshal(s.Object)
+	}
+	return json.Marshal(s.Number)
+}
+
+// Add returns the sum of s and o, as the _sum reducer would compute it:
+// arrays are summed element by element, objects field by field, and a number
+// added to an array is treated as an array of one element. Objects may not be
+// added to numbers or arrays.
+func (s Sum) Add(o Sum) (Sum, error) {
+	switch {
+	case s.Kind == SumNumber && o.Kind == SumNumber:
+		return Sum{Kind: SumNumber, Number: s.Number + o.Number}, nil
+	case s.Kind == SumObject && o.Kind == SumObject:
+		result := Sum{Kind: SumObject, Object: make(map[string]Sum, len(s.Object)+len(o.Object))}
+		for k, v := range s.Object {
+			result.Object[k] = v
+		}
+		for k, v := range o.Object {
+			if existing, ok := result.Object[k]; ok {
+				sum, err := existing.Add(v)
+				if err != nil {
+					return Sum{}, fmt.Errorf("%s: %s", k, err)
+				}
+				v = sum
+			}
+			result.Object[k] = v
+		}
+		return result, nil
+	case s.Kind == SumObject || o.Kind == SumObject:
+		return Sum{}, errors.New("kivik: cannot add an object to a number or array in _sum")
+	}
+	a, b := s.asArray(), o.asArray()
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	result := make([]float64, len(a))
+	copy(result, a)
+	for i, v := range b {
+		result[i] += v
+	}
+	return Sum{Kind: SumArray, Array: result}, nil
+}
+
+func (s Sum) asArray() []float64 {
+	if s.Kind == SumNumber {
+		return []float64{s.Number}
+	}
+	return s.Array
+}
+
+// Stats is the result of the built-in _stats reduce function, for a view
+// which emits numbers. For a view which emits arrays of numbers, the result is
+// a []Stats, with one element per array position.
+type Stats struct {
+	Sum    float64 `json:"sum"`
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	SumSqr float64 `json:"sumsqr"`
+}
+
+// Add returns the combined statistics of s and o.
+func (s Stats) Add(o Stats) Stats {
+	return Stats{
+		Sum:    s.Sum + o.Sum,
+		Count:  s.Count + o.Count,
+		Min:    math.Min(s.Min, o.Min),
+		Max:    math.Max(s.Max, o.Max),
+		SumSqr: s.SumSqr + o.SumSqr,
+	}
+}
+
+// Mean returns the arithmetic mean of the reduced values.
+func (s Stats) Mean() float64 {
+	return s.Sum / float64(s.Count)
+}
+
+// DecodeSum decodes the value of a row reduced by _sum.
+func DecodeSum(value json.RawMessage) (Sum, error) {
+	var sum Sum
+	err := json.Unmarshal(value, &sum)
+	return sum, err
+}
+
+// DecodeCount decodes the value of a row reduced by _count.
+func DecodeCount(value json.RawMessage) (int64, error) {
+	var count int64
+	err := json.Unmarshal(value, &count)
+	return count, err
+}
+
+// DecodeApproxCountDistinct decodes the value of a row reduced by
+// _approx_count_distinct.
+func DecodeApproxCountDistinct(value json.RawMessage) (int64, error) {
+	var count int64
+	err := json.Unmarshal(value, &count)
+	return count, err
+}
+
+// DecodeStats decodes the value of a row reduced by _stats, for a view which
+// emits numbers.
+func DecodeStats(value json.RawMessage) (Stats, error) {
+	var stats Stats
+	err := json.Unmarshal(value, &stats)
+	return stats, err
+}
+
+// DecodeStatsArray decodes the value of a row reduced by _stats, for a view
+// which emits arrays of numbers.
+func DecodeStatsArray(value json.RawMessage) ([]Stats, error) {
+	var stats []Stats
+	err := json.Unmarshal(value, &stats)
+	return stats, err
+}
+
+// MergeReduce combines values reduced by the named built-in reducer, as the
+// reducer itself would when re-reducing. _approx_count_distinct results are
+// estimates, and cannot be merged.
+func MergeReduce(reducer string, values ...json.RawMessage) (json.RawMessage, error) {
+	if len(values) == 0 {
+		return nil, errors.New("kivik: no values to merge")
+	}
+	switch reducer {
+	case ReduceSum:
+		var total Sum
+		for i, value := range values {
+			sum, err := DecodeSum(value)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				total = sum
+				continue
+			}
+			if total, err = total.Add(sum); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(total)
+	case ReduceCount:
+		var total int64
+		for _, value := range values {
+			count, err := DecodeCount(value)
+			if err != nil {
+				return nil, err
+			}
+			total += count
+		}
+		return json.Marshal(total)
+	case ReduceStats:
+		return mergeStats(values)
+	case ReduceApproxCountDistinct:
+		return nil, errors.New("kivik: _approx_count_distinct results cannot be merged")
+	}
+	return nil, fmt.Errorf("kivik: unknown reducer `%s`", reducer)
+}
+
+// mergeStats merges _stats values, which are objects for views which emit
+// numbers, and arrays for views which emit arrays.
+func mergeStats(values []json.RawMessage) (json.RawMessage, error) {
+	var total []Stats
+	var array bool
+	for i, value := range values {
+		value = bytes.TrimSpace(value)
+		isArray := len(value) > 0 && value[0] == '['
+		if i > 0 && isArray != array {
+			return nil, errors.New("kivik: cannot merge _stats of numbers and arrays")
+		}
+		array = isArray
+		var stats []Stats
+		if array {
+			var err error
+			if stats, err = DecodeStatsArray(value); err != nil {
+				return nil, err
+			}
+		} else {
+			s, err := DecodeStats(value)
+			if err != nil {
+				return nil, err
+			}
+			stats = []Stats{s}
+		}
+		if i == 0 {
+			total = stats
+			continue
+		}
+		if len(stats) != len(total) {
+			return nil, errors.New("kivik: cannot merge _stats of arrays of different lengths")
+		}
+		for j := range total {
+			total[j] = total[j].Add(stats[j])
+		}
+	}
+	if array {
+		return json.Marshal(total)
+	}
+	return json.Marshal(total[0])
+}
+
+// ReduceRow is one row of a grouped, reduced view result.
+type ReduceRow struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MergeGroupedReduce combines the rows of several grouped view results, such
+// as the results of the same query against several partitions or databases,
+// reduced by the named built-in reducer. The values of rows with equal keys
+// are merged with MergeReduce. Rows are returned in the order of their keys,
+// by Compare, as in a grouped view result.
+func MergeGroupedReduce(reducer string, results ...[]ReduceRow) ([]ReduceRow, error) {
+	var keys []string
+	groups := make(map[string][]json.RawMessage)
+	rawKeys := make(map[string]json.RawMessage)
+	for _, rows := range results {
+		for _, row := range rows {
+			key, err := canonicalJSON(row.Key)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+				rawKeys[key] = row.Key
+			}
+			groups[key] = append(groups[key], row.Value)
+		}
+	}
+	merged := make([]ReduceRow, 0, len(keys))
+	for _, key := range keys {
+		value, err := MergeReduce(reducer, groups[key]...)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", key, err)
+		}
+		merged = append(merged, ReduceRow{Key: rawKeys[key], Value: value})
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return Compare(merged[i].Key, merged[j].Key) < 0
+	})
+	return merged, nil
+}
+
+// canonicalJSON re-encodes a JSON value, so that equal values compare equal
+// regardless of whitespace and object key order.
+func canonicalJSON(data json.RawMessage) (string, error) {
+	if len(data) == 0 {
+		return "null", nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(v)
+	return string(out), err
+}
@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestDecodeReduce(t *testing.T) {
+	sum, err := DecodeSum(json.RawMessage(`{"a":1,"b":[1,2],"c":{"d":3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSum := Sum{Kind: SumObject, Object: map[string]Sum{
+		"a": {Kind: SumNumber, Number: 1},
+		"b": {Kind: SumArray, Array: []float64{1, 2}},
+		"c": {Kind: SumObject, Object: map[string]Sum{"d": {Kind: SumNumber, Number: 3}}},
+	}}
+	if d := testy.DiffInterface(expectedSum, sum); d != nil {
+		t.Error(d)
+	}
+	count, err := DecodeCount(json.RawMessage(`42`))
+	if err != nil || count != 42 {
+		t.Errorf("Unexpected count: %d, %v", count, err)
+	}
+	distinct, err := DecodeApproxCountDistinct(json.RawMessage(`7`))
+	if err != nil || distinct != 7 {
+		t.Errorf("Unexpected distinct count: %d, %v", distinct, err)
+	}
+	stats, err := DecodeStats(json.RawMessage(`{"sum":10,"count":4,"min":1,"max":4,"sumsqr":30}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface(Stats{Sum: 10, Count: 4, Min: 1, Max: 4, SumSqr: 30}, stats); d != nil {
+		t.Error(d)
+	}
+	if mean := stats.Mean(); mean != 2.5 {
+		t.Errorf("Unexpected mean: %v", mean)
+	}
+	statsArray, err := DecodeStatsArray(json.RawMessage(`[{"sum":1,"count":1,"min":1,"max":1,"sumsqr":1}]`))
+	if err != nil || len(statsArray) != 1 {
+		t.Errorf("Unexpected stats array: %v, %v", statsArray, err)
+	}
+}
+
+func TestMergeReduce(t *testing.T) {
+	tests := []struct {
+		name     string
+		reducer  string
+		values   []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "sum numbers",
+			reducer:  ReduceSum,
+			values:   []string{`1`, `2.5`},
+			expected: `3.5`,
+		},
+		{
+			name:     "sum arrays of different lengths",
+			reducer:  ReduceSum,
+			values:   []string{`[1,2]`, `[1,2,3]`, `4`},
+			expected: `[6,4,3]`,
+		},
+		{
+			name:     "sum objects",
+			reducer:  ReduceSum,
+			values:   []string{`{"a":1,"b":{"c":[1]}}`, `{"b":{"c":[2,2]},"d":5}`},
+			expected: `{"a":1,"b":{"c":[3,2]},"d":5}`,
+		},
+		{
+			name:    "sum object and number",
+			reducer: ReduceSum,
+			values:  []string{`{"a":1}`, `1`},
+			err:     "kivik: cannot add an object to a number or array in _sum",
+		},
+		{
+			name:     "count",
+			reducer:  ReduceCount,
+			values:   []string{`3`, `4`},
+			expected: `7`,
+		},
+		{
+			name:     "stats",
+			reducer:  ReduceStats,
+			values:   []string{`{"sum":10,"count":4,"min":1,"max":4,"sumsqr":30}`, `{"sum":-2,"count":1,"min":-2,"max":-2,"sumsqr":4}`},
+			expected: `{"sum":8,"count":5,"min":-2,"max":4,"sumsqr":34}`,
+		},
+		{
+			name:     "stats arrays",
+			reducer:  ReduceStats,
+			values:   []string{`[{"sum":1,"count":1,"min":1,"max":1,"sumsqr":1}]`, `[{"sum":3,"count":1,"min":3,"max":3,"sumsqr":9}]`},
+			expected: `[{"sum":4,"count":2,"min":1,"max":3,"sumsqr":10}]`,
+		},
+		{
+			name:    "stats mixed",
+			reducer: ReduceStats,
+			values:  []string{`[{"sum":1,"count":1,"min":1,"max":1,"sumsqr":1}]`, `{"sum":3,"count":1,"min":3,"max":3,"sumsqr":9}`},
+			err:     "kivik: cannot merge _stats of numbers and arrays",
+		},
+		{
+			name:    "approx count distinct",
+			reducer: ReduceApproxCountDistinct,
+			values:  []string{`3`, `4`},
+			err:     "kivik: _approx_count_distinct results cannot be merged",
+		},
+		{
+			name:    "unknown reducer",
+			reducer: "_max",
+			values:  []string{`3`},
+			err:     "kivik: unknown reducer `_max`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := make([]json.RawMessage, len(test.values))
+			for i, v := range test.values {
+				values[i] = json.RawMessage(v)
+			}
+			result, err := MergeReduce(test.reducer, values...)
+			testy.Error(t, test.err, err)
+			if d := testy.DiffText(test.expected, string(result)); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestMergeGroupedReduce(t *testing.T) {
+	p1 := []ReduceRow{
+		{Key: json.RawMessage(`["a", 1]`), Value: json.RawMessage(`2`)},
+		{Key: json.RawMessage(`["b",1]`), Value: json.RawMessage(`1`)},
+	}
+	p2 := []ReduceRow{
+		{Key: json.RawMessage(`["c",1]`), Value: json.RawMessage(`5`)},
+		{Key: json.RawMessage(`["a",1]`), Value: json.RawMessage(`3`)},
+		{Key: json.RawMessage(`["A",1]`), Value: json.RawMessage(`2`)},
+		{Key: json.RawMessage(`[1,1]`), Value: json.RawMessage(`4`)},
+	}
+	merged, err := MergeGroupedReduce(ReduceCount, p1, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ReduceRow{
+		{Key: json.RawMessage(`[1,1]`), Value: json.RawMessage(`4`)},
+		{Key: json.RawMessage(`["a", 1]`), Value: json.RawMessage(`5`)},
+		{Key: json.RawMessage(`["A",1]`), Value: json.RawMessage(`2`)},
+		{Key: json.RawMessage(`["b",1]`), Value: json.RawMessage(`1`)},
+		{Key: json.RawMessage(`["c",1]`), Value: json.RawMessage(`5`)},
+	}
+	if d := testy.DiffAsJSON(expected, merged); d != nil {
+		t.Error(d)
+	}
+}
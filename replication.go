@@ -62,6 +62,12 @@ func (re *replicationError) UnmarshalJSON(data []byte) error {
 type replicationStateTime time.Time
 
 func (t *replicationStateTime) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "null", `""`:
+		// An empty timestamp, such as the end time of an unfinished
+		// replication history entry, is left as the zero time.
+		return nil
+	}
 	input := string(bytes.Trim(data, `"`))
 	if ts, err := time.Parse(time.RFC3339, input); err == nil {
 		*t = replicationStateTime(ts)
@@ -73,9 +79,43 @@ func (t *replicationStateTime) UnmarshalJSON(data []byte) error {
 		*t = epochTime
 		return nil
 	}
+	// Replication history timestamps use the HTTP date format.
+	if ts, err := http.ParseTime(input); err == nil {
+		*t = replicationStateTime(ts)
+		return nil
+	}
 	return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("kivik: '%s' does not appear to be a valid timestamp", string(data))}
 }
 
+// replicationEndpoint is the source or target of a replication document,
+// given either as a URL or, as written by ReplicationBuilder, as an object
+// with the URL and credentials. Only the URL is kept, with the username but
+// not the password.
+type replicationEndpoint string
+
+func (e *replicationEndpoint) UnmarshalJSON(data []byte) error {
+	var endpoint struct {
+		URL  string `json:"url"`
+		Auth struct {
+			Basic struct {
+				Username string `json:"username"`
+			} `json:"basic"`
+		} `json:"auth"`
+	}
+	target := interface{}(&endpoint.URL)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		target = &endpoint
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return err
+	}
+	*e = replicationEndpoint(endpoint.URL)
+	if redacted := (ReplicationEndpoint{URL: endpoint.URL, Username: endpoint.Auth.Basic.Username}).String(); redacted != "" {
+		*e = replicationEndpoint(redacted)
+	}
+	return nil
+}
+
 type replication struct {
 	docID         string
 	replicationID string
@@ -156,6 +196,7 @@ type activeTask struct {
 	DocsWritten      int64  `json:"docs_written"`
 	DocsRead         int64  `json:"docs_read"`
 	DocWriteFailures int64  `json:"doc_write_failures"`
+	ChangesPending   int64  `json:"changes_pending"`
 }
 
 func (r *replication) updateActiveTasks(ctx context.Context) (*activeTask, error) {
@@ -220,10 +261,10 @@ func (r *replication) setFromReplicatorDoc(doc *replicatorDoc) {
 		r.err = nil
 	}
 	if r.source == "" {
-		r.source = doc.Source
+		r.source = string(doc.Source)
 	}
 	if r.target == "" {
-		r.target = doc.Target
+		r.target = string(doc.Target)
 	}
 	if r.replicationID == "" {
 		r.replicationID = doc.ReplicationID
@@ -242,8 +283,8 @@ func (r *replication) Delete(ctx context.Context) error {
 type replicatorDoc struct {
 	DocID         string               `json:"_id"`
 	ReplicationID string               `json:"_replication_id"`
-	Source        string               `json:"source"`
-	Target        string               `json:"target"`
+	Source        replicationEndpoint  `json:"source"`
+	Target        replicationEndpoint  `json:"target"`
 	State         string               `json:"_replication_state"`
 	StateTime     replicationStateTime `json:"_replication_state_time"`
 	Error         *replicationError    `json:"_replication_state_reason,omitempty"`
@@ -292,7 +333,8 @@ func (c *client) legacyGetReplications(ctx context.Context, options map[string]i
 	return reps, nil
 }
 
-func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (driver.Replication, error) {
+// replicationOptions returns the body of a replication request.
+func replicationOptions(targetDSN, sourceDSN string, options map[string]interface{}) (map[string]interface{}, error) {
 	if options == nil {
 		options = make(map[string]interface{})
 	}
@@ -309,6 +351,14 @@ func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, opt
 	if s := options["source"]; s == "" {
 		return nil, missingArg("sourceDSN")
 	}
+	return options, nil
+}
+
+func (c *client) Replicate(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (driver.Replication, error) {
+	options, err := replicationOptions(targetDSN, sourceDSN, options)
+	if err != nil {
+		return nil, err
+	}
 
 	scheduler, err := c.schedulerSupported(ctx)
 	if err != nil {
@@ -57,6 +57,21 @@ func TestStateTime(t *testing.T) {
 			Input:    "1492543959",
 			Expected: "2017-04-18 19:32:39 +0000",
 		},
+		{
+			Name:     "HTTP date",
+			Input:    `"Thu, 10 Oct 2019 12:00:05 GMT"`,
+			Expected: "2019-10-10 12:00:05 +0000",
+		},
+		{
+			Name:     "empty string",
+			Input:    `""`,
+			Expected: "0001-01-01 00:00:00 +0000",
+		},
+		{
+			Name:     "null",
+			Input:    "null",
+			Expected: "0001-01-01 00:00:00 +0000",
+		},
 		{
 			Name:     "invalid timestamp",
 			Input:    `"foo"`,
@@ -0,0 +1,275 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// ReplicationEndpoint is the source or target of a replication.
+type ReplicationEndpoint struct {
+	// URL is the URL of the database.
+	URL string
+	// Username and Password, if set, are sent with basic authentication,
+	// and must not also be included in URL.
+	Username string
+	Password string
+	// IAMAPIKey, if set, authenticates with IBM Cloud IAM, which Cloudant
+	// supports.
+	IAMAPIKey string
+	// Headers are sent with each request to the endpoint.
+	Headers map[string]string
+}
+
+// String returns the URL of the endpoint, without its password.
+func (e ReplicationEndpoint) String() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case e.Username != "":
+		u.User = url.User(e.Username)
+	case u.User != nil:
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
+
+func (e ReplicationEndpoint) options(name string) (interface{}, error) {
+	if e.URL == "" {
+		return nil, missingArg(name + " URL")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	if e.Username != "" || e.Password != "" {
+		if u.User != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: %s has credentials in both URL and Username", name)}
+		}
+		if e.IAMAPIKey != "" {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: %s basic auth and IAM are mutually exclusive", name)}
+		}
+	}
+	if e.Username == "" && e.Password == "" && e.IAMAPIKey == "" && len(e.Headers) == 0 {
+		return e.URL, nil
+	}
+	endpoint := map[string]interface{}{"url": e.URL}
+	switch {
+	case e.IAMAPIKey != "":
+		endpoint["auth"] = map[string]interface{}{
+			"iam": map[string]interface{}{"api_key": e.IAMAPIKey},
+		}
+	case e.Username != "" || e.Password != "":
+		endpoint["auth"] = map[string]interface{}{
+			"basic": map[string]interface{}{"username": e.Username, "password": e.Password},
+		}
+	}
+	if len(e.Headers) > 0 {
+		endpoint["headers"] = e.Headers
+	}
+	return endpoint, nil
+}
+
+// CreateTargetParams are the parameters of a target database created by a
+// replication.
+type CreateTargetParams struct {
+	// Q is the number of shards. Zero uses the server's default.
+	Q int
+	// N is the number of replicas of each shard. Zero uses the server's
+	// default.
+	N int
+	// Partitioned creates a partitioned database.
+	Partitioned bool
+}
+
+// ReplicationBuilder builds the options of a replication, for Replicate or
+// ReplicateTransient. Each method sets an option, and returns the builder,
+// so calls can be chained:
+//
+//	opts, err := couchdb.NewReplication(source, target).
+//		Continuous(true).
+//		DocIDs("a", "b").
+//		Options()
+//	if err != nil {
+//		return err
+//	}
+//	rep, err := client.Replicate(ctx, "", "", opts)
+type ReplicationBuilder struct {
+	source, target ReplicationEndpoint
+	opts           map[string]interface{}
+}
+
+// NewReplication returns a builder for a replication from source to target.
+func NewReplication(source, target ReplicationEndpoint) *ReplicationBuilder {
+	return &ReplicationBuilder{
+		source: source,
+		target: target,
+		opts:   map[string]interface{}{},
+	}
+}
+
+// Source returns the URL of the source, without its password.
+func (b *ReplicationBuilder) Source() string { return b.source.String() }
+
+// Target returns the URL of the target, without its password.
+func (b *ReplicationBuilder) Target() string { return b.target.String() }
+
+func (b *ReplicationBuilder) set(key string, value interface{}) *ReplicationBuilder {
+	b.opts[key] = value
+	return b
+}
+
+// Continuous sets whether the replication continues to replicate new
+// changes once the target has caught up.
+func (b *ReplicationBuilder) Continuous(continuous bool) *ReplicationBuilder {
+	return b.set("continuous", continuous)
+}
+
+// CreateTarget creates the target database if it does not exist. params may
+// be nil.
+func (b *ReplicationBuilder) CreateTarget(params *CreateTargetParams) *ReplicationBuilder {
+	b.set("create_target", true)
+	if params == nil {
+		delete(b.opts, "create_target_params")
+		return b
+	}
+	p := map[string]interface{}{}
+	if params.Q != 0 {
+		p["q"] = params.Q
+	}
+	if params.N != 0 {
+		p["n"] = params.N
+	}
+	if params.Partitioned {
+		p["partitioned"] = true
+	}
+	return b.set("create_target_params", p)
+}
+
+// DocIDs replicates only the documents with the given IDs.
+func (b *ReplicationBuilder) DocIDs(docIDs ...string) *ReplicationBuilder {
+	if docIDs == nil {
+		docIDs = []string{}
+	}
+	return b.set("doc_ids", docIDs)
+}
+
+// Selector replicates only the documents which match a Mango selector.
+func (b *ReplicationBuilder) Selector(selector interface{}) *ReplicationBuilder {
+	return b.set("selector", selector)
+}
+
+// Filter replicates only the changes selected by the filter function filter,
+// given as "ddoc/filter". params, which may be nil, are passed to the
+// function as req.query.
+func (b *ReplicationBuilder) Filter(filter string, params map[string]interface{}) *ReplicationBuilder {
+	b.set("filter", strings.TrimPrefix(filter, designPrefix))
+	if params == nil {
+		delete(b.opts, "query_params")
+		return b
+	}
+	return b.set("query_params", params)
+}
+
+// SinceSeq starts the replication from the given source sequence, instead
+// of from its last checkpoint.
+func (b *ReplicationBuilder) SinceSeq(seq string) *ReplicationBuilder {
+	return b.set("since_seq", seq)
+}
+
+// UseCheckpoints sets whether the replication records checkpoints.
+func (b *ReplicationBuilder) UseCheckpoints(use bool) *ReplicationBuilder {
+	return b.set("use_checkpoints", use)
+}
+
+// CheckpointInterval sets how often the replication records a checkpoint.
+func (b *ReplicationBuilder) CheckpointInterval(interval time.Duration) *ReplicationBuilder {
+	return b.set("checkpoint_interval", int64(interval/time.Millisecond))
+}
+
+// WorkerProcesses sets the number of processes which replicate in parallel.
+func (b *ReplicationBuilder) WorkerProcesses(n int) *ReplicationBuilder {
+	return b.set("worker_processes", n)
+}
+
+// HTTPConnections sets the maximum number of connections to each endpoint.
+func (b *ReplicationBuilder) HTTPConnections(n int) *ReplicationBuilder {
+	return b.set("http_connections", n)
+}
+
+// BatchSize sets the number of documents each worker process replicates at
+// once.
+func (b *ReplicationBuilder) BatchSize(n int) *ReplicationBuilder {
+	return b.set("worker_batch_size", n)
+}
+
+// WinningRevsOnly sets whether only the winning revision of each document is
+// replicated, without its conflicts. It requires CouchDB 3.3 or later.
+func (b *ReplicationBuilder) WinningRevsOnly(winningOnly bool) *ReplicationBuilder {
+	return b.set("winning_revs_only", winningOnly)
+}
+
+// Options validates the options, and returns them for Replicate or
+// ReplicateTransient, including the source and target, with their
+// credentials.
+func (b *ReplicationBuilder) Options() (map[string]interface{}, error) {
+	source, err := b.source.options("source")
+	if err != nil {
+		return nil, err
+	}
+	target, err := b.target.options("target")
+	if err != nil {
+		return nil, err
+	}
+	var filters []string
+	for _, key := range []string{"doc_ids", "selector", "filter"} {
+		if _, ok := b.opts[key]; ok {
+			filters = append(filters, key)
+		}
+	}
+	if len(filters) > 1 {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: %s and %s are mutually exclusive", filters[0], filters[1])}
+	}
+	if b.opts["filter"] == "" {
+		return nil, missingArg("filter")
+	}
+	if b.opts["use_checkpoints"] == false {
+		if _, ok := b.opts["checkpoint_interval"]; ok {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: checkpoint_interval requires use_checkpoints")}
+		}
+	}
+	if i, ok := b.opts["checkpoint_interval"].(int64); ok && i <= 0 {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: checkpoint_interval must be positive")}
+	}
+	for _, key := range []string{"worker_processes", "http_connections", "worker_batch_size"} {
+		if n, ok := b.opts[key].(int); ok && n <= 0 {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: %s must be positive", key)}
+		}
+	}
+	opts := make(map[string]interface{}, len(b.opts)+2)
+	for k, v := range b.opts {
+		opts[k] = v
+	}
+	opts["source"] = source
+	opts["target"] = target
+	return opts, nil
+}
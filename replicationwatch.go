@@ -0,0 +1,235 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+const defaultWatchInterval = time.Second
+
+// ReplicationWatcher is implemented by the driver's client, to follow the
+// state of a replication defined by a document in a replicator database.
+type ReplicationWatcher interface {
+	WatchReplication(docID string, opts *ReplicationWatchOptions) *ReplicationWatch
+}
+
+var _ ReplicationWatcher = &client{}
+
+// ReplicationWatchOptions are optional parameters to WatchReplication.
+type ReplicationWatchOptions struct {
+	// Database is the replicator database containing the replication
+	// document. Defaults to _replicator.
+	Database string
+	// Interval is how often the state of the replication is read. Defaults
+	// to one second.
+	Interval time.Duration
+}
+
+// ReplicationEvent reports a change to the state of a replication.
+type ReplicationEvent struct {
+	// State is the new state of the replication.
+	State kivik.ReplicationState
+	// Previous is the state before, or ReplicationNotStarted for the first
+	// event.
+	Previous kivik.ReplicationState
+	// Time is when the change was seen.
+	Time time.Time
+	// Err is the reason for an error, crashing or failed state.
+	Err error
+	// DocsRead, DocsWritten, and DocWriteFailures count the documents
+	// replicated so far. ChangesPending is the number of source changes yet
+	// to be replicated.
+	DocsRead         int64
+	DocsWritten      int64
+	DocWriteFailures int64
+	ChangesPending   int64
+}
+
+// ReplicationWatch follows the state of a replication, by polling
+// _scheduler/docs or, for CouchDB before 2.1, the replication document and
+// _active_tasks.
+type ReplicationWatch struct {
+	c        *client
+	docID    string
+	database string
+	interval time.Duration
+}
+
+// WatchReplication returns a watch of the replication defined by the
+// document docID, such as the document created by Replicate.
+func (c *client) WatchReplication(docID string, opts *ReplicationWatchOptions) *ReplicationWatch {
+	w := &ReplicationWatch{
+		c:        c,
+		docID:    docID,
+		database: "_replicator",
+		interval: defaultWatchInterval,
+	}
+	if opts != nil {
+		if opts.Database != "" {
+			w.database = opts.Database
+		}
+		if opts.Interval > 0 {
+			w.interval = opts.Interval
+		}
+	}
+	return w
+}
+
+// terminal reports whether a replication in state can change state no more.
+func terminal(state kivik.ReplicationState) bool {
+	return state == kivik.ReplicationComplete || state == kivik.ReplicationFailed
+}
+
+// Run calls fn with an event each time the state of the replication changes,
+// until the replication is completed or failed, fn returns an error, or ctx
+// is cancelled. Run returns the error from fn, or nil once the replication is
+// completed or failed. Until the replicator has read the replication
+// document, the replication is reported as initializing.
+func (w *ReplicationWatch) Run(ctx context.Context, fn func(*ReplicationEvent) error) error {
+	if w.docID == "" {
+		return missingArg("docID")
+	}
+	scheduler, err := w.c.schedulerSupported(ctx)
+	if err != nil {
+		return err
+	}
+	poll := w.pollLegacy
+	if scheduler {
+		poll = w.pollScheduler
+	}
+	var state kivik.ReplicationState
+	var seen bool
+	for {
+		event, err := poll(ctx)
+		if err != nil {
+			return err
+		}
+		if !seen || event.State != state {
+			event.Previous = state
+			event.Time = time.Now()
+			if err := fn(event); err != nil {
+				return err
+			}
+			seen, state = true, event.State
+		}
+		if terminal(state) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+var errReplicationWatchDone = errors.New("done")
+
+// WaitUntil blocks until the replication reaches one of states, and returns
+// the event reporting it. If the replication is completed or failed first, it
+// returns the event with an error, with a status of 409.
+func (w *ReplicationWatch) WaitUntil(ctx context.Context, states ...kivik.ReplicationState) (*ReplicationEvent, error) {
+	if len(states) == 0 {
+		return nil, missingArg("states")
+	}
+	var last *ReplicationEvent
+	err := w.Run(ctx, func(event *ReplicationEvent) error {
+		last = event
+		for _, state := range states {
+			if event.State == state {
+				return errReplicationWatchDone
+			}
+		}
+		return nil
+	})
+	switch {
+	case err == errReplicationWatchDone:
+		return last, nil
+	case err != nil:
+		return last, err
+	}
+	reason := fmt.Sprintf("kivik: replication %s", last.State)
+	if last.Err != nil {
+		reason += ": " + last.Err.Error()
+	}
+	return last, &kivik.Error{HTTPStatus: http.StatusConflict, Err: errors.New(reason)}
+}
+
+func (w *ReplicationWatch) pollScheduler(ctx context.Context) (*ReplicationEvent, error) {
+	rep := &schedulerReplication{
+		docID:    w.docID,
+		database: w.database,
+		db:       &db{client: w.c, dbName: w.database},
+	}
+	if err := rep.update(ctx); err != nil {
+		if kivik.StatusCode(err) == http.StatusNotFound {
+			// The replicator has not yet read the document.
+			return &ReplicationEvent{State: kivik.ReplicationInitializing}, nil
+		}
+		return nil, err
+	}
+	return &ReplicationEvent{
+		State:            kivik.ReplicationState(rep.state),
+		Err:              rep.info.Error,
+		DocsRead:         rep.info.DocsRead,
+		DocsWritten:      rep.info.DocsWritten,
+		DocWriteFailures: rep.info.DocWriteFailures,
+		ChangesPending:   rep.info.Pending,
+	}, nil
+}
+
+// pollLegacy reads the state from the replication document, in which CouchDB
+// 1.x and 2.0 record only whether the replication was triggered, completed or
+// stopped by an error, reported as failed, and the document counts from
+// _active_tasks.
+func (w *ReplicationWatch) pollLegacy(ctx context.Context) (*ReplicationEvent, error) {
+	rep := w.c.newReplication(w.docID)
+	rep.db.dbName = w.database
+	if err := rep.updateMain(ctx); err != nil {
+		return nil, err
+	}
+	event := &ReplicationEvent{Err: rep.Err()}
+	switch kivik.ReplicationState(rep.State()) {
+	case kivik.ReplicationNotStarted:
+		event.State = kivik.ReplicationInitializing
+	case kivik.ReplicationStarted:
+		event.State = kivik.ReplicationRunning
+	case kivik.ReplicationError:
+		// The replicator does not restart a replication stopped by an error.
+		event.State = kivik.ReplicationFailed
+	default:
+		event.State = kivik.ReplicationState(rep.State())
+	}
+	if event.State != kivik.ReplicationRunning {
+		return event, nil
+	}
+	task, err := rep.updateActiveTasks(ctx)
+	if err != nil {
+		if kivik.StatusCode(err) == http.StatusNotFound {
+			return event, nil
+		}
+		return nil, err
+	}
+	event.DocsRead = task.DocsRead
+	event.DocsWritten = task.DocsWritten
+	event.DocWriteFailures = task.DocWriteFailures
+	event.ChangesPending = task.ChangesPending
+	return event, nil
+}
@@ -0,0 +1,206 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"gitlab.com/flimzy/testy"
+)
+
+// watchServer serves each of a sequence of responses to polls of a
+// replication in turn, repeating the last.
+type watchServer struct {
+	t         *testing.T
+	scheduler bool
+	// docs are the scheduler docs, or legacy replication documents. An empty
+	// string is served as not found.
+	docs []string
+	// tasks are served from _active_tasks.
+	tasks string
+
+	mu    sync.Mutex
+	polls int
+}
+
+func (s *watchServer) client() *client {
+	c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+		var resp *http.Response
+		switch req.URL.Path {
+		case "/_scheduler/docs/_replicator/rep", "/_replicator/rep":
+			s.mu.Lock()
+			doc := s.docs[len(s.docs)-1]
+			if s.polls < len(s.docs) {
+				doc = s.docs[s.polls]
+			}
+			s.polls++
+			s.mu.Unlock()
+			if doc == "" {
+				resp = jsonResponse(http.StatusNotFound, `{"error":"not_found","reason":"missing"}`)
+				break
+			}
+			resp = jsonResponse(http.StatusOK, doc)
+			resp.Header.Set("ETag", `"1-x"`)
+		case "/_active_tasks":
+			resp = jsonResponse(http.StatusOK, s.tasks)
+		default:
+			s.t.Errorf("Unexpected request: %s %s", req.Method, req.URL)
+			resp = jsonResponse(http.StatusInternalServerError, `{}`)
+		}
+		resp.Request = req
+		return resp, nil
+	})
+	c.schedulerDetected = &s.scheduler
+	return c
+}
+
+func schedulerDocJSON(state, info string) string {
+	return fmt.Sprintf(`{"database":"_replicator","doc_id":"rep","id":"abc","source":"http://localhost:5984/foo/","target":"http://localhost:5984/bar/","state":%q,"info":%s,"start_time":"2017-11-01T21:05:03Z","last_updated":"2017-11-01T21:05:06Z"}`, state, info)
+}
+
+func TestReplicationWatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   *watchServer
+		expected []ReplicationEvent
+	}{
+		{
+			name: "scheduler",
+			server: &watchServer{
+				scheduler: true,
+				docs: []string{
+					"",
+					schedulerDocJSON("initializing", "null"),
+					schedulerDocJSON("running", `{"docs_read":1,"docs_written":1,"doc_write_failures":0,"changes_pending":9}`),
+					schedulerDocJSON("running", `{"docs_read":5,"docs_written":5,"doc_write_failures":0,"changes_pending":5}`),
+					schedulerDocJSON("crashing", `{"error":"db_not_found: could not open foo"}`),
+					schedulerDocJSON("completed", `{"docs_read":10,"docs_written":9,"doc_write_failures":1,"changes_pending":null}`),
+				},
+			},
+			expected: []ReplicationEvent{
+				{State: kivik.ReplicationInitializing},
+				{State: kivik.ReplicationRunning, Previous: kivik.ReplicationInitializing, DocsRead: 1, DocsWritten: 1, ChangesPending: 9},
+				{State: kivik.ReplicationCrashing, Previous: kivik.ReplicationRunning, Err: &replicationError{status: http.StatusNotFound, reason: "db_not_found: could not open foo"}},
+				{State: kivik.ReplicationComplete, Previous: kivik.ReplicationCrashing, DocsRead: 10, DocsWritten: 9, DocWriteFailures: 1},
+			},
+		},
+		{
+			name: "legacy",
+			server: &watchServer{
+				docs: []string{
+					`{"_id":"rep","source":"foo","target":"bar"}`,
+					`{"_id":"rep","source":"foo","target":"bar","_replication_state":"triggered","_replication_state_time":"2017-10-30T20:03:34+00:00","_replication_id":"abc"}`,
+					`{"_id":"rep","source":"foo","target":"bar","_replication_state":"completed","_replication_state_time":"2017-10-30T20:04:34+00:00","_replication_id":"abc"}`,
+				},
+				tasks: `[{"type":"indexer"},{"type":"replication","replication_id":"abc+continuous","docs_read":3,"docs_written":2,"doc_write_failures":1,"changes_pending":4}]`,
+			},
+			expected: []ReplicationEvent{
+				{State: kivik.ReplicationInitializing},
+				{State: kivik.ReplicationRunning, Previous: kivik.ReplicationInitializing, DocsRead: 3, DocsWritten: 2, DocWriteFailures: 1, ChangesPending: 4},
+				{State: kivik.ReplicationComplete, Previous: kivik.ReplicationRunning},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.server.t = t
+			w := test.server.client().WatchReplication("rep", &ReplicationWatchOptions{Interval: time.Millisecond})
+			var events []ReplicationEvent
+			err := w.Run(context.Background(), func(event *ReplicationEvent) error {
+				if event.Time.IsZero() {
+					t.Errorf("Event time not set")
+				}
+				event.Time = time.Time{}
+				events = append(events, *event)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := testy.DiffInterface(test.expected, events); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestReplicationWatchErrors(t *testing.T) {
+	t.Run("missing doc ID", func(t *testing.T) {
+		err := newTestClient(nil, nil).WatchReplication("", nil).Run(context.Background(), nil)
+		testy.StatusError(t, "kivik: docID required", http.StatusBadRequest, err)
+	})
+	t.Run("handler error", func(t *testing.T) {
+		s := &watchServer{t: t, scheduler: true, docs: []string{schedulerDocJSON("running", "null")}}
+		err := s.client().WatchReplication("rep", nil).Run(context.Background(), func(*ReplicationEvent) error {
+			return errors.New("stop")
+		})
+		testy.Error(t, "stop", err)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		s := &watchServer{t: t, scheduler: true, docs: []string{schedulerDocJSON("running", "null")}}
+		ctx, cancel := context.WithCancel(context.Background())
+		err := s.client().WatchReplication("rep", nil).Run(ctx, func(*ReplicationEvent) error {
+			cancel()
+			return nil
+		})
+		testy.Error(t, "context canceled", err)
+	})
+}
+
+func TestReplicationWaitUntil(t *testing.T) {
+	docs := []string{
+		schedulerDocJSON("initializing", "null"),
+		schedulerDocJSON("running", "null"),
+		schedulerDocJSON("failed", `"db_not_found: could not open foo"`),
+	}
+	t.Run("reached", func(t *testing.T) {
+		s := &watchServer{t: t, scheduler: true, docs: docs}
+		event, err := s.client().WatchReplication("rep", &ReplicationWatchOptions{Interval: time.Millisecond}).WaitUntil(context.Background(), kivik.ReplicationRunning)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.State != kivik.ReplicationRunning {
+			t.Errorf("Unexpected state: %s", event.State)
+		}
+	})
+	t.Run("failed", func(t *testing.T) {
+		s := &watchServer{t: t, scheduler: true, docs: docs}
+		event, err := s.client().WatchReplication("rep", &ReplicationWatchOptions{Interval: time.Millisecond}).WaitUntil(context.Background(), kivik.ReplicationComplete)
+		if event.State != kivik.ReplicationFailed {
+			t.Errorf("Unexpected state: %s", event.State)
+		}
+		testy.StatusError(t, "kivik: replication failed: db_not_found: could not open foo", http.StatusConflict, err)
+	})
+	t.Run("legacy error", func(t *testing.T) {
+		s := &watchServer{t: t, docs: []string{
+			`{"_id":"rep","source":"foo","target":"bar","_replication_state":"triggered","_replication_state_time":"2017-10-30T20:03:34+00:00","_replication_id":"abc"}`,
+			`{"_id":"rep","source":"foo","target":"bar","_replication_state":"error","_replication_state_time":"2017-10-30T20:04:34+00:00","_replication_state_reason":"db_not_found: could not open foo","_replication_id":"abc"}`,
+		}, tasks: `[]`}
+		event, err := s.client().WatchReplication("rep", &ReplicationWatchOptions{Interval: time.Millisecond}).WaitUntil(context.Background(), kivik.ReplicationComplete)
+		if event.State != kivik.ReplicationFailed || event.Previous != kivik.ReplicationRunning {
+			t.Errorf("Unexpected states: %s, %s", event.Previous, event.State)
+		}
+		testy.StatusError(t, "kivik: replication failed: db_not_found: could not open foo", http.StatusConflict, err)
+	})
+	t.Run("no states", func(t *testing.T) {
+		_, err := newTestClient(nil, nil).WatchReplication("rep", nil).WaitUntil(context.Background())
+		testy.StatusError(t, "kivik: states required", http.StatusBadRequest, err)
+	})
+}
@@ -0,0 +1,868 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+const (
+	defaultReplicationBatch = 100
+	replicationHistoryLimit = 50
+	// replicationIDVersion is the version of CouchDB's replication IDs
+	// derived by baseReplicationID.
+	replicationIDVersion = 4
+)
+
+// ReplicatorOptions are optional parameters to NewReplicator.
+type ReplicatorOptions struct {
+	// Continuous, if true, keeps replicating new changes once the target has
+	// caught up with the source, until Stop is called or the context is
+	// cancelled.
+	Continuous bool
+	// Filter, if set, replicates only the changes it selects.
+	Filter *ChangesFilter
+	// BatchSize is the number of changes read from the source at once.
+	// Defaults to 100.
+	BatchSize int
+	// PollTimeout is how long each request for changes waits for a new
+	// change, once a continuous replication has caught up. Defaults to one
+	// minute.
+	PollTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before retrying after an
+	// error in a continuous replication, as for ChangesFollowerOptions.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Progress, if set, is called after each batch of changes has been
+	// replicated and checkpointed.
+	Progress func(ReplicatorStats)
+	// OnError, if set, is called with each error which a continuous
+	// replication retries.
+	OnError func(error)
+}
+
+// ReplicatorStats reports the progress of a Replicator. The counts have the
+// same meaning as those of a CouchDB replication.
+type ReplicatorStats struct {
+	// DocsRead is the number of document revisions read from the source.
+	DocsRead int64
+	// DocsWritten is the number of document revisions written to the target.
+	DocsWritten int64
+	// DocWriteFailures is the number of document revisions the target
+	// refused, such as those rejected by a validation function.
+	DocWriteFailures int64
+	// MissingChecked is the number of revisions checked against the target.
+	MissingChecked int64
+	// MissingFound is the number of those revisions missing from the target.
+	MissingFound int64
+	// LastSeq is the source sequence of the last checkpoint.
+	LastSeq string
+}
+
+// Replicator replicates from one database to another through this client,
+// using the CouchDB replication protocol, so that the databases need not be
+// able to reach each other. It reads the source's changes, asks the target
+// which revisions it is missing with _revs_diff, fetches them with _bulk_get,
+// or open_revs for servers without it, and writes them to the target with
+// new_edits=false, uploading attachments with multipart requests.
+//
+// Progress is checkpointed in a _local document in both databases, named by
+// the replication ID, so that a replication resumes from the last sequence
+// both databases agree on. The replication ID is the one CouchDB's replicator
+// derives for the same replication run on the target's server, so such a
+// replication resumes from these checkpoints, and this one from CouchDB's.
+type Replicator struct {
+	source, target *db
+	opts           ReplicatorOptions
+
+	mu    sync.Mutex
+	stats ReplicatorStats
+	// noBulkGet is set once the source has been found not to support
+	// _bulk_get.
+	noBulkGet bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewReplicator returns a replicator from source to target, which must both
+// be databases of this driver, though they may be on different servers.
+func NewReplicator(source, target driver.DB, opts *ReplicatorOptions) (*Replicator, error) {
+	src, ok := source.(*db)
+	if !ok {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: source must be a CouchDB database")}
+	}
+	tgt, ok := target.(*db)
+	if !ok {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: target must be a CouchDB database")}
+	}
+	r := &Replicator{
+		source: src,
+		target: tgt,
+		stop:   make(chan struct{}),
+	}
+	if opts != nil {
+		r.opts = *opts
+	}
+	if r.opts.BatchSize <= 0 {
+		r.opts.BatchSize = defaultReplicationBatch
+	}
+	if r.opts.PollTimeout <= 0 {
+		r.opts.PollTimeout = time.Minute
+	}
+	if r.opts.MinBackoff <= 0 {
+		r.opts.MinBackoff = defaultMinBackoff
+	}
+	if r.opts.MaxBackoff < r.opts.MinBackoff {
+		r.opts.MaxBackoff = defaultMaxBackoff
+		if r.opts.MaxBackoff < r.opts.MinBackoff {
+			r.opts.MaxBackoff = r.opts.MinBackoff
+		}
+	}
+	return r, nil
+}
+
+// Stats returns the progress of the replication.
+func (r *Replicator) Stats() ReplicatorStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func (r *Replicator) updateStats(fn func(*ReplicatorStats)) {
+	r.mu.Lock()
+	fn(&r.stats)
+	r.mu.Unlock()
+}
+
+// Stop stops the replication, abandoning any batch not yet checkpointed, which
+// is replicated again when the replication is next run. Run then returns nil.
+func (r *Replicator) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *Replicator) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// replicationEndpoint returns the term by which CouchDB's replicator
+// identifies the database in replication IDs: {remote, User, Host, Port, Path,
+// Headers, OAuth}, where User is the user of basic authentication in the URL,
+// if any, and Port is default for the default ports.
+func (d *db) replicationEndpoint() (erlTuple, error) {
+	dsn, err := url.Parse(d.Client.DSN())
+	if err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	var user interface{} = erlAtomTerm("undefined")
+	if _, ok := dsn.User.Password(); ok {
+		user = erlCharList(dsn.User.Username())
+	}
+	var port interface{} = erlAtomTerm("default")
+	if p := dsn.Port(); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		switch {
+		case dsn.Scheme == "http" && (n == 80 || n == 5984):
+		case dsn.Scheme == "https" && n == 443:
+		default:
+			port = n
+		}
+	}
+	path := strings.TrimSuffix(dsn.EscapedPath(), "/") + "/" + url.PathEscape(d.dbName) + "/"
+	return erlTuple{
+		erlAtomTerm("remote"),
+		user,
+		erlCharList(dsn.Hostname()),
+		port,
+		erlCharList(path),
+		[]interface{}{},
+		erlAtomTerm("undefined"),
+	}, nil
+}
+
+// filterCode returns the source of the design document filter function
+// named "ddoc/filter".
+func (d *db) filterCode(ctx context.Context, name string) (string, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return "", &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: invalid filter '%s'", name)}
+	}
+	var ddoc struct {
+		Filters map[string]string `json:"filters"`
+	}
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(chttp.EncodeDocID(designPrefix+parts[0])), nil, &ddoc); err != nil {
+		return "", err
+	}
+	code, ok := ddoc.Filters[parts[1]]
+	if !ok {
+		return "", &kivik.Error{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("kivik: filter '%s' not found", name)}
+	}
+	return code, nil
+}
+
+// ReplicationID returns the ID CouchDB's replicator gives the replication, if
+// run on the target's server, followed by "+continuous" for a continuous
+// replication. The ID without the suffix names the checkpoint documents, as
+// in CouchDB, so the ID must be the same each time the replication is run.
+//
+// CouchDB normalises Mango selectors before deriving the ID, and this does
+// not, so the ID of a replication filtered by a selector is CouchDB's only if
+// the selector is already in normal form, with an explicit operator for each
+// field, as built from the conditions in this package.
+func (r *Replicator) ReplicationID(ctx context.Context) (string, error) {
+	baseID, err := r.baseReplicationID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if r.opts.Continuous {
+		return baseID + "+continuous", nil
+	}
+	return baseID, nil
+}
+
+// baseReplicationID derives the replication ID as version 4 of CouchDB's
+// replicator does: the hex md5 hash of the term_to_binary of the server's
+// UUID, the source and target endpoints and the filter terms.
+func (r *Replicator) baseReplicationID(ctx context.Context) (string, error) {
+	i := &info{}
+	if _, err := r.target.Client.DoJSON(ctx, http.MethodGet, "/", nil, i); err != nil {
+		return "", err
+	}
+	source, err := r.source.replicationEndpoint()
+	if err != nil {
+		return "", err
+	}
+	target, err := r.target.replicationEndpoint()
+	if err != nil {
+		return "", err
+	}
+	terms := []interface{}{i.UUID, source, target}
+	if f := r.opts.Filter; f != nil {
+		filterTerms, err := r.replicationFilterTerms(ctx, f)
+		if err != nil {
+			return "", err
+		}
+		terms = append(terms, filterTerms...)
+	}
+	data, err := termToBinary(terms)
+	if err != nil {
+		return "", &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	sum := md5.Sum(data) // nolint: gosec
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// replicationFilterTerms returns the terms by which CouchDB identifies the
+// filter of a replication: the document IDs, the selector, or the name of a
+// built-in filter or the source of a design document filter followed by its
+// query parameters.
+func (r *Replicator) replicationFilterTerms(ctx context.Context, f *ChangesFilter) ([]interface{}, error) {
+	var term interface{}
+	switch f.name {
+	case "_doc_ids":
+		term = f.body["doc_ids"]
+	case "_selector":
+		term = f.body["selector"]
+	}
+	if term != nil {
+		ejson, err := toEJSON(term)
+		if err != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		return []interface{}{ejson}, nil
+	}
+	params := map[string]interface{}{}
+	for k, v := range f.params {
+		params[k] = v
+	}
+	queryParams, err := toEJSON(params)
+	if err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: err}
+	}
+	if strings.HasPrefix(f.name, "_") {
+		return []interface{}{f.name, queryParams}, nil
+	}
+	code, err := r.source.filterCode(ctx, f.name)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{code, queryParams}, nil
+}
+
+type replicationHistory struct {
+	SessionID        string     `json:"session_id"`
+	StartTime        string     `json:"start_time,omitempty"`
+	EndTime          string     `json:"end_time,omitempty"`
+	RecordedSeq      sequenceID `json:"recorded_seq,omitempty"`
+	LastSeq          sequenceID `json:"last_seq,omitempty"`
+	DocsRead         int64      `json:"docs_read"`
+	DocsWritten      int64      `json:"docs_written"`
+	DocWriteFailures int64      `json:"doc_write_failures"`
+	MissingChecked   int64      `json:"missing_checked"`
+	MissingFound     int64      `json:"missing_found"`
+}
+
+// seq returns the recorded sequence, which PouchDB calls last_seq.
+func (h *replicationHistory) seq() sequenceID {
+	if h.RecordedSeq != "" {
+		return h.RecordedSeq
+	}
+	return h.LastSeq
+}
+
+// replicationCheckpoint is the _local document in which CouchDB and PouchDB
+// record the progress of a replication.
+type replicationCheckpoint struct {
+	ID                   string               `json:"_id"`
+	SessionID            string               `json:"session_id"`
+	SourceLastSeq        sequenceID           `json:"source_last_seq,omitempty"`
+	LastSeq              sequenceID           `json:"last_seq,omitempty"`
+	ReplicationIDVersion int                  `json:"replication_id_version,omitempty"`
+	History              []replicationHistory `json:"history"`
+}
+
+func (c *replicationCheckpoint) seq() sequenceID {
+	if c.SourceLastSeq != "" {
+		return c.SourceLastSeq
+	}
+	return c.LastSeq
+}
+
+func (d *db) replicationCheckpoint(ctx context.Context, docID string) (*replicationCheckpoint, error) {
+	cp := &replicationCheckpoint{}
+	_, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(chttp.EncodeDocID(docID)), nil, cp)
+	if kivik.StatusCode(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// replicationStartSeq returns the sequence recorded by the most recent session
+// found in the checkpoints of both the source and the target, or "" if there
+// is none.
+func replicationStartSeq(source, target *replicationCheckpoint) string {
+	if source == nil || target == nil {
+		return ""
+	}
+	if source.SessionID != "" && source.SessionID == target.SessionID {
+		return string(source.seq())
+	}
+	for _, s := range source.History {
+		for _, t := range target.History {
+			if s.SessionID == t.SessionID {
+				return string(s.seq())
+			}
+		}
+	}
+	return ""
+}
+
+// replicationSession is the state of one run of a replication.
+type replicationSession struct {
+	docID     string
+	id        string
+	startTime string
+	startSeq  string
+	source    *replicationCheckpoint
+	target    *replicationCheckpoint
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Run replicates the changes after the last checkpoint. A one-shot
+// replication returns once the target has caught up with the source. A
+// continuous replication returns nil once Stop is called, and otherwise
+// retries errors, other than those with a status of 400, 401, 403 or 404,
+// until ctx is cancelled.
+func (r *Replicator) Run(parent context.Context) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	s, err := r.startSession(ctx)
+	if err != nil {
+		return err
+	}
+	since := s.startSeq
+	r.updateStats(func(stats *ReplicatorStats) { stats.LastSeq = since })
+	backoff := r.opts.MinBackoff
+	var caughtUp bool
+	for !r.stopped() {
+		count, lastSeq, err := r.replicateBatch(ctx, since, caughtUp)
+		if err == nil && (lastSeq != since || s.target == nil) {
+			err = r.checkpoint(ctx, s, lastSeq)
+		}
+		if err != nil {
+			if r.stopped() {
+				return nil
+			}
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			if !r.opts.Continuous || !retryable(err) {
+				return err
+			}
+			if r.opts.OnError != nil {
+				r.opts.OnError(err)
+			}
+			select {
+			case <-ctx.Done():
+				if r.stopped() {
+					return nil
+				}
+				return parent.Err()
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > r.opts.MaxBackoff {
+				backoff = r.opts.MaxBackoff
+			}
+			continue
+		}
+		backoff = r.opts.MinBackoff
+		since = lastSeq
+		if r.opts.Progress != nil {
+			r.opts.Progress(r.Stats())
+		}
+		if count < r.opts.BatchSize {
+			if !r.opts.Continuous {
+				return nil
+			}
+			caughtUp = true
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) startSession(ctx context.Context) (*replicationSession, error) {
+	baseID, err := r.baseReplicationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &replicationSession{
+		docID:     "_local/" + baseID,
+		startTime: time.Now().UTC().Format(time.RFC1123),
+	}
+	if s.id, err = newSessionID(); err != nil {
+		return nil, err
+	}
+	if s.source, err = r.source.replicationCheckpoint(ctx, s.docID); err != nil {
+		return nil, err
+	}
+	if s.target, err = r.target.replicationCheckpoint(ctx, s.docID); err != nil {
+		return nil, err
+	}
+	s.startSeq = replicationStartSeq(s.source, s.target)
+	return s, nil
+}
+
+// checkpoint records seq in the checkpoint documents of the target and the
+// source. A source which may not be written to, such as one read with
+// read-only credentials, is not checkpointed.
+func (r *Replicator) checkpoint(ctx context.Context, s *replicationSession, seq string) error {
+	stats := r.Stats()
+	entry := replicationHistory{
+		SessionID:        s.id,
+		StartTime:        s.startTime,
+		EndTime:          time.Now().UTC().Format(time.RFC1123),
+		RecordedSeq:      sequenceID(seq),
+		LastSeq:          sequenceID(seq),
+		DocsRead:         stats.DocsRead,
+		DocsWritten:      stats.DocsWritten,
+		DocWriteFailures: stats.DocWriteFailures,
+		MissingChecked:   stats.MissingChecked,
+		MissingFound:     stats.MissingFound,
+	}
+	var err error
+	if s.target, err = r.target.writeReplicationCheckpoint(ctx, s, s.target, entry); err != nil {
+		return err
+	}
+	s.source, err = r.source.writeReplicationCheckpoint(ctx, s, s.source, entry)
+	switch kivik.StatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	r.updateStats(func(stats *ReplicatorStats) { stats.LastSeq = seq })
+	return nil
+}
+
+func (d *db) writeReplicationCheckpoint(ctx context.Context, s *replicationSession, prev *replicationCheckpoint, entry replicationHistory) (*replicationCheckpoint, error) {
+	cp := &replicationCheckpoint{
+		ID:                   s.docID,
+		SessionID:            s.id,
+		SourceLastSeq:        entry.RecordedSeq,
+		LastSeq:              entry.RecordedSeq,
+		ReplicationIDVersion: replicationIDVersion,
+		History:              []replicationHistory{entry},
+	}
+	if prev != nil {
+		for _, h := range prev.History {
+			if h.SessionID != s.id && len(cp.History) < replicationHistoryLimit {
+				cp.History = append(cp.History, h)
+			}
+		}
+	}
+	doc, err := json.Marshal(cp)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.putLocalDoc(ctx, s.docID, doc); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// replicateBatch replicates up to BatchSize changes after since, and returns
+// the number of changes read and the sequence to continue from. Once the
+// target has caught up, it waits for new changes.
+func (r *Replicator) replicateBatch(ctx context.Context, since string, wait bool) (int, string, error) {
+	opts := map[string]interface{}{
+		"style": "all_docs",
+		"limit": r.opts.BatchSize,
+	}
+	if since != "" {
+		opts["since"] = since
+	}
+	if wait {
+		opts["feed"] = "longpoll"
+		opts["timeout"] = int64(r.opts.PollTimeout / time.Millisecond)
+	}
+	if r.opts.Filter != nil {
+		opts[OptionChangesFilter] = *r.opts.Filter
+	}
+	changes, err := r.source.changes(ctx, opts, nil)
+	if err != nil {
+		return 0, since, err
+	}
+	var count int
+	var ids []string
+	revs := map[string][]string{}
+	for {
+		change := &driver.Change{}
+		err := changes.Next(change)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = changes.Close()
+			return 0, since, err
+		}
+		count++
+		if _, ok := revs[change.ID]; !ok {
+			ids = append(ids, change.ID)
+		}
+		revs[change.ID] = append(revs[change.ID], change.Changes...)
+	}
+	_ = changes.Close()
+	lastSeq := changes.LastSeq()
+	if lastSeq == "" {
+		lastSeq = since
+	}
+	if len(revs) == 0 {
+		return count, lastSeq, nil
+	}
+	var checked int64
+	for _, r := range revs {
+		checked += int64(len(r))
+	}
+	r.updateStats(func(stats *ReplicatorStats) { stats.MissingChecked += checked })
+	missing, err := r.missingRevs(ctx, ids, revs)
+	if err != nil {
+		return 0, since, err
+	}
+	if len(missing) == 0 {
+		return count, lastSeq, nil
+	}
+	docs, err := r.fetchRevs(ctx, missing)
+	if err != nil {
+		return 0, since, err
+	}
+	if err := r.writeDocs(ctx, docs); err != nil {
+		return 0, since, err
+	}
+	return count, lastSeq, nil
+}
+
+// missingRev is a revision which the target lacks, with the revisions of the
+// document which the target has, and which may be its ancestors. The
+// attachments of those revisions are not fetched again.
+type missingRev struct {
+	ID        string   `json:"id"`
+	Rev       string   `json:"rev"`
+	AttsSince []string `json:"atts_since,omitempty"`
+}
+
+// missingRevs returns the revisions which the target lacks, in the order of
+// ids.
+func (r *Replicator) missingRevs(ctx context.Context, ids []string, revs map[string][]string) ([]missingRev, error) {
+	rows, err := r.target.RevsDiff(ctx, revs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+	missing := map[string][]missingRev{}
+	for {
+		var row driver.Row
+		if err := rows.Next(&row); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		var diff struct {
+			Missing           []string `json:"missing"`
+			PossibleAncestors []string `json:"possible_ancestors"`
+		}
+		if err := json.Unmarshal(row.Value, &diff); err != nil {
+			return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		for _, rev := range diff.Missing {
+			missing[row.ID] = append(missing[row.ID], missingRev{ID: row.ID, Rev: rev, AttsSince: diff.PossibleAncestors})
+		}
+	}
+	var refs []missingRev
+	for _, id := range ids {
+		refs = append(refs, missing[id]...)
+	}
+	r.updateStats(func(stats *ReplicatorStats) { stats.MissingFound += int64(len(refs)) })
+	return refs, nil
+}
+
+// fetchRevs reads the missing revisions from the source, with their
+// revision histories and the attachments added since the target's revisions.
+func (r *Replicator) fetchRevs(ctx context.Context, refs []missingRev) ([]json.RawMessage, error) {
+	r.mu.Lock()
+	noBulkGet := r.noBulkGet
+	r.mu.Unlock()
+	if !noBulkGet {
+		docs, err := r.bulkGetRevs(ctx, refs)
+		if !bulkGetUnsupported(err) {
+			return docs, err
+		}
+		r.mu.Lock()
+		r.noBulkGet = true
+		r.mu.Unlock()
+	}
+	var docs []json.RawMessage
+	for i := 0; i < len(refs); {
+		j := i + 1
+		for j < len(refs) && refs[j].ID == refs[i].ID {
+			j++
+		}
+		revs := make([]string, 0, j-i)
+		for _, ref := range refs[i:j] {
+			revs = append(revs, ref.Rev)
+		}
+		found, err := r.source.openRevs(ctx, refs[i].ID, revs, refs[i].AttsSince)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, found...)
+		i = j
+	}
+	r.updateStats(func(stats *ReplicatorStats) { stats.DocsRead += int64(len(docs)) })
+	return docs, nil
+}
+
+// bulkGetUnsupported reports whether err shows that the server has no
+// _bulk_get, rather than that the request was bad.
+func bulkGetUnsupported(err error) bool {
+	switch kivik.StatusCode(err) {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	case http.StatusBadRequest:
+		// CouchDB 1.x takes _bulk_get for a document ID, and refuses it.
+		cerr, ok := err.(*chttp.HTTPError)
+		return ok && strings.Contains(cerr.Reason, "Only reserved document ids may start with underscore")
+	}
+	return false
+}
+
+func (r *Replicator) bulkGetRevs(ctx context.Context, refs []missingRev) ([]json.RawMessage, error) {
+	rows, err := r.source.bulkGet(ctx, refs, map[string]interface{}{
+		"revs":        true,
+		"attachments": true,
+		"latest":      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+	var docs []json.RawMessage
+	for {
+		var row driver.Row
+		if err := rows.Next(&row); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if row.Error != nil {
+			// The revision has been removed since the changes were read, such
+			// as by compaction.
+			continue
+		}
+		docs = append(docs, row.Doc)
+	}
+	r.updateStats(func(stats *ReplicatorStats) { stats.DocsRead += int64(len(docs)) })
+	return docs, nil
+}
+
+// openRevs reads the named revisions of a document, for servers without
+// _bulk_get, with the attachments added since attsSince.
+func (d *db) openRevs(ctx context.Context, docID string, revs, attsSince []string) ([]json.RawMessage, error) {
+	openRevs, err := json.Marshal(revs)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"open_revs":   []string{string(openRevs)},
+		"revs":        []string{"true"},
+		"attachments": []string{"true"},
+		"latest":      []string{"true"},
+	}
+	if len(attsSince) > 0 {
+		since, err := json.Marshal(attsSince)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("atts_since", string(since))
+	}
+	var results []struct {
+		OK json.RawMessage `json:"ok"`
+	}
+	opts := &chttp.Options{Accept: typeJSON, Query: query}
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(chttp.EncodeDocID(docID)), opts, &results); err != nil {
+		return nil, err
+	}
+	docs := make([]json.RawMessage, 0, len(results))
+	for _, result := range results {
+		if len(result.OK) > 0 {
+			docs = append(docs, result.OK)
+		}
+	}
+	return docs, nil
+}
+
+// writeDocs writes revisions to the target, preserving their revision IDs.
+// Revisions with only new attachments are written individually, with their
+// attachments in binary form. Others are written with _bulk_docs, including
+// those with stubs of attachments the target already has. Revisions the
+// target refuses are counted as write failures.
+func (r *Replicator) writeDocs(ctx context.Context, docs []json.RawMessage) error {
+	var bulk []interface{}
+	var written, failed int64
+	for _, doc := range docs {
+		var meta struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(doc, &meta); err != nil {
+			return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		atts, err := decodeBackupAttachments(meta.ID, doc)
+		if err != nil {
+			return err
+		}
+		if len(atts) == 0 || hasStubs(atts) {
+			bulk = append(bulk, doc)
+			continue
+		}
+		err = r.target.restoreWithAttachments(ctx, meta.ID, doc, atts)
+		switch kivik.StatusCode(err) {
+		case 0:
+			written++
+		case http.StatusUnauthorized, http.StatusForbidden:
+			failed++
+		default:
+			return err
+		}
+	}
+	if len(bulk) > 0 {
+		results, err := r.target.BulkDocs(ctx, bulk, map[string]interface{}{"new_edits": false})
+		if err != nil {
+			return err
+		}
+		defer results.Close() // nolint: errcheck
+		var bulkFailed int64
+		for {
+			var result driver.BulkResult
+			if err := results.Next(&result); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			if result.Error != nil {
+				bulkFailed++
+			}
+		}
+		failed += bulkFailed
+		written += int64(len(bulk)) - bulkFailed
+	}
+	r.updateStats(func(stats *ReplicatorStats) {
+		stats.DocsWritten += written
+		stats.DocWriteFailures += failed
+	})
+	return nil
+}
+
+// hasStubs reports whether any of atts is a stub, without content.
+func hasStubs(atts map[string]backupAttachment) bool {
+	for _, att := range atts {
+		if att.Stub {
+			return true
+		}
+	}
+	return false
+}
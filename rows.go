@@ -33,6 +33,7 @@ type rowsMeta struct {
 	updateSeq sequenceID
 	warning   string
 	bookmark  string
+	stats     *ExecutionStats
 }
 
 type rows struct {
@@ -131,6 +132,10 @@ func (r *rows) Bookmark() string {
 	return r.bookmark
 }
 
+func (r *rows) ExecutionStats() *ExecutionStats {
+	return r.stats
+}
+
 func (r *rows) UpdateSeq() string {
 	return string(r.updateSeq)
 }
@@ -153,10 +158,81 @@ func (r *rowsMeta) parseMeta(key string, dec *json.Decoder) error {
 		return dec.Decode(&r.warning)
 	case "bookmark":
 		return dec.Decode(&r.bookmark)
+	case "execution_stats":
+		return dec.Decode(&r.stats)
 	}
 	return &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: fmt.Errorf("Unexpected key: %s", key)}
 }
 
+// QueryResult is the metadata of one query of a multi-query request.
+type QueryResult struct {
+	// Index is the position of the query in the request.
+	Index     int
+	Offset    int64
+	TotalRows int64
+	UpdateSeq string
+	// Err is a *QueryError if the query failed, in which case it returned no
+	// rows.
+	Err error
+}
+
+// QueryError represents the failure of a single query of a multi-query
+// request.
+type QueryError struct {
+	Index  int    `json:"-"`
+	Err    string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+var _ error = &QueryError{}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query %d: %s: %s", e.Index, e.Err, e.Reason)
+}
+
+// MultiQueryReporter is implemented by the rows returned for a view query with
+// the queries option. Offset, TotalRows and UpdateSeq report on the current
+// query only.
+type MultiQueryReporter interface {
+	// QueryResults returns the metadata of each query read to the end so far,
+	// in order. When Next returns driver.EOQ, or io.EOF, the last element is
+	// that of the query just ended.
+	QueryResults() []QueryResult
+}
+
+// queryMeta is the metadata of one query of a multi-query request, which may
+// be an error in place of the rows.
+type queryMeta struct {
+	rowsMeta
+	err    string
+	reason string
+}
+
+type queryParser struct {
+	rowParser
+}
+
+var _ parser = &queryParser{}
+
+func (p *queryParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
+	meta := i.(*queryMeta)
+	switch key {
+	case "error":
+		return dec.Decode(&meta.err)
+	case "reason":
+		return dec.Decode(&meta.reason)
+	}
+	return meta.rowsMeta.parseMeta(key, dec)
+}
+
+func newQueryRows(ctx context.Context, in io.ReadCloser) (*rows, *queryMeta) {
+	meta := &queryMeta{}
+	return &rows{
+		iter:     newIter(ctx, meta, "rows", in, &queryParser{}),
+		rowsMeta: &meta.rowsMeta,
+	}, meta
+}
+
 func newMultiQueriesRows(ctx context.Context, in io.ReadCloser) driver.Rows {
 	return &multiQueriesRows{
 		ctx: ctx,
@@ -166,6 +242,8 @@ func newMultiQueriesRows(ctx context.Context, in io.ReadCloser) driver.Rows {
 
 type multiQueriesRows struct {
 	*rows
+	meta       *queryMeta
+	results    []QueryResult
 	ctx        context.Context
 	r          io.ReadCloser
 	dec        *json.Decoder
@@ -177,6 +255,11 @@ type multiQueriesRows struct {
 	legacy int32
 }
 
+var (
+	_ driver.QueryIndexer = &multiQueriesRows{}
+	_ MultiQueryReporter  = &multiQueriesRows{}
+)
+
 func (r *multiQueriesRows) Next(row *driver.Row) error {
 	if atomic.LoadInt32(&r.closed) == 1 {
 		return io.EOF
@@ -192,14 +275,35 @@ func (r *multiQueriesRows) Next(row *driver.Row) error {
 		}
 	}
 	if err := r.rows.Next(row); err != nil {
-		if err == io.EOF && atomic.LoadInt32(&r.legacy) == 0 {
-			return driver.EOQ
+		if err == io.EOF {
+			r.endQuery()
+			if atomic.LoadInt32(&r.legacy) == 0 {
+				return driver.EOQ
+			}
 		}
 		return err
 	}
 	return nil
 }
 
+// endQuery records the metadata of the query just read to the end.
+func (r *multiQueriesRows) endQuery() {
+	result := QueryResult{
+		Index:     r.queryIndex,
+		Offset:    r.meta.offset,
+		TotalRows: r.meta.totalRows,
+		UpdateSeq: string(r.meta.updateSeq),
+	}
+	if r.meta.err != "" {
+		result.Err = &QueryError{Index: r.queryIndex, Err: r.meta.err, Reason: r.meta.reason}
+	}
+	r.results = append(r.results, result)
+}
+
+func (r *multiQueriesRows) QueryResults() []QueryResult {
+	return r.results
+}
+
 func (r *multiQueriesRows) begin() error {
 	r.dec = json.NewDecoder(r.r)
 	// consume the first '{'
@@ -226,7 +330,7 @@ func (r *multiQueriesRows) begin() error {
 				r.r),
 			Closer: r.r,
 		}
-		r.rows = newRows(r.ctx, in).(*rows)
+		r.rows, r.meta = newQueryRows(r.ctx, in)
 		r.rows.body = nil
 		r.rows.dec = json.NewDecoder(in)
 		return r.rows.begin()
@@ -235,8 +339,9 @@ func (r *multiQueriesRows) begin() error {
 	if err := consumeDelim(r.dec, json.Delim('[')); err != nil {
 		return err
 	}
-	r.rows = newRows(r.ctx, r.r).(*rows)
+	r.rows, r.meta = newQueryRows(r.ctx, r.r)
 	r.rows.body = nil
+	r.rows.iter.optional = true
 	r.rows.iter.dec = r.dec
 	return r.rows.iter.begin()
 }
@@ -248,7 +353,8 @@ func (r *multiQueriesRows) nextQuery() error {
 		}
 		return io.EOF
 	}
-	rows := newRows(r.ctx, r.r).(*rows)
+	rows, meta := newQueryRows(r.ctx, r.r)
+	rows.iter.optional = true
 	rows.iter.dec = r.dec
 	if err := rows.iter.begin(); err != nil {
 		// I'd normally use errors.As, but I want to retain backward
@@ -263,6 +369,7 @@ func (r *multiQueriesRows) nextQuery() error {
 	}
 	r.queryIndex++
 	r.rows = rows
+	r.meta = meta
 	r.rows.body = nil
 	return nil
 }
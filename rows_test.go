@@ -14,6 +14,7 @@ package couchdb
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -186,6 +187,47 @@ func TestMultiQueriesRowsIterator(t *testing.T) {
 	}
 }
 
+func TestMultiQueriesRowsResults(t *testing.T) {
+	body := `{"results":[
+{"total_rows":3,"offset":1,"update_seq":"12-g1AAAA","rows":[{"id":"a","key":"a","value":1}]},
+{"error":"query_parse_error","reason":"Invalid value for integer: \"x\""},
+{"rows":[{"id":"b","key":"b","value":2},{"id":"c","key":"c","value":3}],"total_rows":5,"offset":0,"update_seq":"12-g1AAAA"}
+]}`
+	rows := newMultiQueriesRows(context.TODO(), ioutil.NopCloser(strings.NewReader(body)))
+	reporter := rows.(MultiQueryReporter)
+	var events []string
+	for {
+		var row driver.Row
+		err := rows.Next(&row)
+		if err == driver.EOQ || err == io.EOF {
+			results := reporter.QueryResults()
+			events = append(events, fmt.Sprintf("%v %d", err, len(results)))
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next() failed: %s", err)
+		}
+		events = append(events, row.ID)
+	}
+	if d := testy.DiffInterface([]string{"a", "EOQ 1", "EOQ 2", "b", "c", "EOQ 3", "EOF 3"}, events); d != nil {
+		t.Error(d)
+	}
+	expected := []QueryResult{
+		{Index: 0, Offset: 1, TotalRows: 3, UpdateSeq: "12-g1AAAA"},
+		{Index: 1, Err: &QueryError{Index: 1, Err: "query_parse_error", Reason: `Invalid value for integer: "x"`}},
+		{Index: 2, Offset: 0, TotalRows: 5, UpdateSeq: "12-g1AAAA"},
+	}
+	if d := testy.DiffInterface(expected, reporter.QueryResults()); d != nil {
+		t.Error(d)
+	}
+	if err := rows.Close(); err != nil {
+		t.Errorf("Error closing rows iterator: %s", err)
+	}
+}
+
 func TestRowsIteratorErrors(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -292,4 +334,29 @@ func TestFindRowsIterator(t *testing.T) {
 	if rows.Bookmark() != "nil" {
 		t.Errorf("Unexpected bookmark: %s", rows.Bookmark())
 	}
+	if stats := rows.(ExecutionStatsReporter).ExecutionStats(); stats != nil {
+		t.Errorf("Unexpected execution stats: %v", stats)
+	}
+}
+
+func TestFindRowsExecutionStats(t *testing.T) {
+	input := `{"docs":[{"_id":"foo"}],"bookmark":"g1AAAA","execution_stats":{"total_keys_examined":0,"total_docs_examined":12,"total_quorum_docs_examined":0,"results_returned":1,"execution_time_ms":5.52}}`
+	rows := newFindRows(context.TODO(), ioutil.NopCloser(strings.NewReader(input)))
+	for {
+		err := rows.Next(&driver.Row{})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := &ExecutionStats{
+		TotalDocsExamined: 12,
+		ResultsReturned:   1,
+		ExecutionTimeMs:   5.52,
+	}
+	if d := testy.DiffInterface(expected, rows.(ExecutionStatsReporter).ExecutionStats()); d != nil {
+		t.Error(d)
+	}
 }
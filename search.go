@@ -0,0 +1,392 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// defaultAnalyzer is the analyzer used by SearchAnalyze.
+const defaultAnalyzer = "standard"
+
+var _ driver.Searcher = &db{}
+
+// SearchAnalyzer is implemented by the DB handles returned by this driver. It
+// extends driver.Searcher's SearchAnalyze, which always uses the standard
+// analyzer.
+type SearchAnalyzer interface {
+	// AnalyzeSearchText returns the tokens produced by the named Lucene
+	// analyzer, such as "keyword" or "english", for text.
+	AnalyzeSearchText(ctx context.Context, analyzer, text string) ([]string, error)
+}
+
+var _ SearchAnalyzer = &db{}
+
+// SearchReporter is implemented by the rows returned by Search, and by the
+// pages of a SearchPager, to expose the search-specific parts of the
+// response.
+type SearchReporter interface {
+	// Counts returns the facet counts requested with the counts option, by
+	// field and value.
+	Counts() map[string]map[string]int64
+	// Ranges returns the facet counts requested with the ranges option, by
+	// field and range label.
+	Ranges() map[string]map[string]int64
+	// Groups returns the results of a query with the group_field option.
+	// Grouped results are returned only here; the rows iterator is empty.
+	Groups() []SearchGroup
+	// Highlights returns the highlighted fragments of the current row, by
+	// field, when the highlight_fields option was used.
+	Highlights() map[string][]string
+}
+
+// SearchGroup is one group of the results of a grouped search.
+type SearchGroup struct {
+	By        string      `json:"by"`
+	TotalRows int64       `json:"total_rows"`
+	Rows      []SearchRow `json:"rows"`
+}
+
+// SearchRow is a single search result within a SearchGroup. In ungrouped
+// results, the same values are returned in driver.Row, with Order as the Key
+// and Fields as the Value.
+type SearchRow struct {
+	ID         string                 `json:"id"`
+	Order      []interface{}          `json:"order"`
+	Fields     map[string]interface{} `json:"fields"`
+	Highlights map[string][]string    `json:"highlights,omitempty"`
+	Doc        json.RawMessage        `json:"doc,omitempty"`
+}
+
+type searchMeta struct {
+	rowsMeta
+	counts     map[string]map[string]int64
+	ranges     map[string]map[string]int64
+	groups     []SearchGroup
+	highlights map[string][]string
+}
+
+func (m *searchMeta) parseMeta(key string, dec *json.Decoder) error {
+	switch key {
+	case "counts":
+		return dec.Decode(&m.counts)
+	case "ranges":
+		return dec.Decode(&m.ranges)
+	case "groups":
+		return dec.Decode(&m.groups)
+	}
+	return m.rowsMeta.parseMeta(key, dec)
+}
+
+type searchParser struct {
+	meta *searchMeta
+}
+
+var _ parser = &searchParser{}
+
+func (p *searchParser) parseMeta(i interface{}, dec *json.Decoder, key string) error {
+	return i.(*searchMeta).parseMeta(key, dec)
+}
+
+func (p *searchParser) decodeItem(i interface{}, dec *json.Decoder) error {
+	var result struct {
+		ID         string              `json:"id"`
+		Order      json.RawMessage     `json:"order"`
+		Fields     json.RawMessage     `json:"fields"`
+		Highlights map[string][]string `json:"highlights"`
+		Doc        json.RawMessage     `json:"doc"`
+	}
+	if err := dec.Decode(&result); err != nil {
+		return err
+	}
+	row := i.(*driver.Row)
+	row.ID = result.ID
+	row.Key = result.Order
+	row.Value = result.Fields
+	row.Doc = result.Doc
+	p.meta.highlights = result.Highlights
+	return nil
+}
+
+type searchRows struct {
+	*iter
+	*searchMeta
+}
+
+var (
+	_ driver.Rows    = &searchRows{}
+	_ SearchReporter = &searchRows{}
+)
+
+func newSearchRows(ctx context.Context, in io.ReadCloser) *searchRows {
+	meta := &searchMeta{}
+	return &searchRows{
+		iter:       newIter(ctx, meta, "rows", in, &searchParser{meta: meta}),
+		searchMeta: meta,
+	}
+}
+
+// newGroupedSearchRows reads a grouped search response, which has no
+// top-level rows, in full.
+func newGroupedSearchRows(in io.ReadCloser) (*searchRows, error) {
+	defer in.Close() // nolint: errcheck
+	var result struct {
+		TotalRows int64                       `json:"total_rows"`
+		Bookmark  string                      `json:"bookmark"`
+		Counts    map[string]map[string]int64 `json:"counts"`
+		Ranges    map[string]map[string]int64 `json:"ranges"`
+		Groups    []SearchGroup               `json:"groups"`
+	}
+	if err := json.NewDecoder(in).Decode(&result); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+	meta := &searchMeta{
+		counts: result.Counts,
+		ranges: result.Ranges,
+		groups: result.Groups,
+	}
+	meta.totalRows = result.TotalRows
+	meta.bookmark = result.Bookmark
+	return &searchRows{searchMeta: meta}, nil
+}
+
+func (r *searchRows) Next(row *driver.Row) error {
+	if r.iter == nil {
+		return io.EOF
+	}
+	row.Error = nil
+	return r.iter.next(row)
+}
+
+func (r *searchRows) Close() error {
+	if r.iter == nil {
+		return nil
+	}
+	return r.iter.Close()
+}
+
+func (r *searchRows) Offset() int64                       { return 0 }
+func (r *searchRows) TotalRows() int64                    { return r.totalRows }
+func (r *searchRows) UpdateSeq() string                   { return "" }
+func (r *searchRows) Bookmark() string                    { return r.bookmark }
+func (r *searchRows) Counts() map[string]map[string]int64 { return r.counts }
+func (r *searchRows) Ranges() map[string]map[string]int64 { return r.ranges }
+func (r *searchRows) Groups() []SearchGroup               { return r.groups }
+func (r *searchRows) Highlights() map[string][]string     { return r.highlights }
+
+// Search queries the search index of a design document, as provided by
+// CouchDB 3.x with Clouseau, or by Cloudant. query is the Lucene query. opts
+// are sent in the request body, so may include any parameter of the _search
+// endpoint, such as sort, counts, ranges, drilldown, group_field or
+// highlight_fields, as well as OptionPartition.
+//
+// Each row has the document ID, the sort order as its key, the stored fields
+// as its value, and the document, if include_docs was set. The returned rows
+// implement SearchReporter.
+func (d *db) Search(ctx context.Context, ddoc, index, query string, opts map[string]interface{}) (driver.Rows, error) {
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	if index == "" {
+		return nil, missingArg("index")
+	}
+	if query == "" {
+		return nil, missingArg("query")
+	}
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+	reqPath := fmt.Sprintf("_design/%s/_search/%s", chttp.EncodeDocID(ddoc), chttp.EncodeDocID(index))
+	body := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		body[k] = v
+	}
+	if part, ok := body[OptionPartition].(string); ok {
+		delete(body, OptionPartition)
+		reqPath = path.Join("_partition", part, reqPath)
+	}
+	body["query"] = query
+	options := &chttp.Options{
+		GetBody: chttp.BodyEncoder(body),
+		Header: http.Header{
+			chttp.HeaderIdempotencyKey: []string{},
+		},
+	}
+	resp, err := d.Client.DoReq(ctx, http.MethodPost, d.path(reqPath), options)
+	if err != nil {
+		return nil, err
+	}
+	if err = chttp.ResponseError(resp); err != nil {
+		return nil, err
+	}
+	if _, ok := body["group_field"]; ok {
+		return newGroupedSearchRows(resp.Body)
+	}
+	return newSearchRows(ctx, resp.Body), nil
+}
+
+func (d *db) SearchInfo(ctx context.Context, ddoc, index string) (*driver.SearchInfo, error) {
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	if index == "" {
+		return nil, missingArg("index")
+	}
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+	reqPath := fmt.Sprintf("_design/%s/_search_info/%s", chttp.EncodeDocID(ddoc), chttp.EncodeDocID(index))
+	var raw json.RawMessage
+	if _, err := d.Client.DoJSON(ctx, http.MethodGet, d.path(reqPath), nil, &raw); err != nil {
+		return nil, err
+	}
+	var result struct {
+		Name        string `json:"name"`
+		SearchIndex struct {
+			PendingSeq   int64 `json:"pending_seq"`
+			DocDelCount  int64 `json:"doc_del_count"`
+			DocCount     int64 `json:"doc_count"`
+			DiskSize     int64 `json:"disk_size"`
+			CommittedSeq int64 `json:"committed_seq"`
+		} `json:"search_index"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadGateway, Err: err}
+	}
+	return &driver.SearchInfo{
+		Name: result.Name,
+		SearchIndex: driver.SearchIndex{
+			PendingSeq:   result.SearchIndex.PendingSeq,
+			DocDelCount:  result.SearchIndex.DocDelCount,
+			DocCount:     result.SearchIndex.DocCount,
+			DiskSize:     result.SearchIndex.DiskSize,
+			CommittedSeq: result.SearchIndex.CommittedSeq,
+		},
+		RawResponse: raw,
+	}, nil
+}
+
+func (d *db) SearchAnalyze(ctx context.Context, text string) ([]string, error) {
+	return d.AnalyzeSearchText(ctx, defaultAnalyzer, text)
+}
+
+func (d *db) AnalyzeSearchText(ctx context.Context, analyzer, text string) ([]string, error) {
+	if analyzer == "" {
+		return nil, missingArg("analyzer")
+	}
+	opts := &chttp.Options{
+		Body: chttp.EncodeBody(map[string]string{
+			"analyzer": analyzer,
+			"text":     text,
+		}),
+	}
+	var result struct {
+		Tokens []string `json:"tokens"`
+	}
+	_, err := d.Client.DoJSON(ctx, http.MethodPost, "/_search_analyze", opts, &result)
+	return result.Tokens, err
+}
+
+// SearchPager pages through the complete results of a search, by re-issuing
+// the query with the bookmark returned by each page. The page size is the
+// limit option, or the default of 25.
+type SearchPager struct {
+	bookmarkPaging
+	db    driver.Searcher
+	ddoc  string
+	index string
+	query string
+	opts  map[string]interface{}
+}
+
+// NewSearchPager returns a pager over the results of the search query, with
+// the same arguments as Search. If opts includes a bookmark, paging starts
+// from that bookmark. Grouped searches cannot be paged by bookmark, so opts
+// may not include group_field.
+func NewSearchPager(db driver.Searcher, ddoc, index, query string, opts map[string]interface{}) (*SearchPager, error) {
+	if _, ok := opts["group_field"]; ok {
+		return nil, &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: errors.New("kivik: grouped search results cannot be paged")}
+	}
+	limit, err := pageLimit(opts["limit"])
+	if err != nil {
+		return nil, err
+	}
+	o := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		o[k] = v
+	}
+	o["limit"] = limit
+	bookmark, _ := o["bookmark"].(string)
+	delete(o, "bookmark")
+	return &SearchPager{
+		bookmarkPaging: bookmarkPaging{limit: limit, bookmark: bookmark},
+		db:             db,
+		ddoc:           ddoc,
+		index:          index,
+		query:          query,
+		opts:           o,
+	}, nil
+}
+
+// Next returns a rows iterator over the next page of results, which
+// implements SearchReporter. It returns io.EOF when there are no more results.
+// Any previous page is closed.
+func (p *SearchPager) Next(ctx context.Context) (driver.Rows, error) {
+	rows, err := p.nextPage(func(bookmark string) (driver.Rows, error) {
+		opts := make(map[string]interface{}, len(p.opts)+1)
+		for k, v := range p.opts {
+			opts[k] = v
+		}
+		if bookmark != "" {
+			opts["bookmark"] = bookmark
+		}
+		return p.db.Search(ctx, p.ddoc, p.index, p.query, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &searchPage{bookmarkPage: rows.(*bookmarkPage)}, nil
+}
+
+// Rows returns a rows iterator over all remaining results, fetching each page
+// as the previous one is exhausted.
+func (p *SearchPager) Rows(ctx context.Context) driver.Rows {
+	return &bookmarkPagerRows{ctx: ctx, next: p.Next, paging: &p.bookmarkPaging}
+}
+
+// searchPage exposes the search metadata of a single page.
+type searchPage struct {
+	*bookmarkPage
+}
+
+var _ SearchReporter = &searchPage{}
+
+func (r *searchPage) reporter() SearchReporter {
+	if s, ok := r.Rows.(SearchReporter); ok {
+		return s
+	}
+	return &searchRows{searchMeta: &searchMeta{}}
+}
+
+func (r *searchPage) Counts() map[string]map[string]int64 { return r.reporter().Counts() }
+func (r *searchPage) Ranges() map[string]map[string]int64 { return r.reporter().Ranges() }
+func (r *searchPage) Groups() []SearchGroup               { return r.reporter().Groups() }
+func (r *searchPage) Highlights() map[string][]string     { return r.reporter().Highlights() }
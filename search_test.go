@@ -0,0 +1,276 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+func TestSearch(t *testing.T) {
+	t.Run("validation", func(t *testing.T) {
+		db := newTestDB(nil, nil)
+		_, err := db.Search(context.Background(), "", "idx", "q", nil)
+		testy.StatusError(t, "kivik: ddoc required", http.StatusBadRequest, err)
+		_, err = db.Search(context.Background(), "ddoc", "", "q", nil)
+		testy.StatusError(t, "kivik: index required", http.StatusBadRequest, err)
+		_, err = db.Search(context.Background(), "ddoc", "idx", "", nil)
+		testy.StatusError(t, "kivik: query required", http.StatusBadRequest, err)
+	})
+	t.Run("rows and facets", func(t *testing.T) {
+		var body map[string]interface{}
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodPost || req.URL.Path != "/testdb/_partition/p1/_design/products/_search/by-text" {
+				t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			return jsonResponse(http.StatusOK, `{"total_rows":2,"bookmark":"g1AAAA","rows":[
+{"id":"p1:a","order":[1.5,0],"fields":{"name":"Red shirt"},"highlights":{"name":["<em>Red</em> shirt"]},"doc":{"_id":"p1:a"}},
+{"id":"p1:b","order":[0.5,1],"fields":{"name":"Red hat"}}
+],"counts":{"color":{"red":2}},"ranges":{"price":{"cheap":1,"expensive":1}}}`), nil
+		})
+		rows, err := db.Search(context.Background(), "_design/products", "by-text", "name:red", map[string]interface{}{
+			OptionPartition:    "p1",
+			"include_docs":     true,
+			"counts":           []string{"color"},
+			"highlight_fields": []string{"name"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedBody := map[string]interface{}{
+			"query":            "name:red",
+			"include_docs":     true,
+			"counts":           []interface{}{"color"},
+			"highlight_fields": []interface{}{"name"},
+		}
+		if d := testy.DiffInterface(expectedBody, body); d != nil {
+			t.Errorf("Unexpected request body:\n%s", d)
+		}
+		reporter := rows.(SearchReporter)
+		var ids []string
+		var highlights []map[string][]string
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			ids = append(ids, row.ID+" "+string(row.Key)+" "+string(row.Value))
+			highlights = append(highlights, reporter.Highlights())
+		}
+		expectedIDs := []string{
+			`p1:a [1.5,0] {"name":"Red shirt"}`,
+			`p1:b [0.5,1] {"name":"Red hat"}`,
+		}
+		if d := testy.DiffInterface(expectedIDs, ids); d != nil {
+			t.Error(d)
+		}
+		if d := testy.DiffInterface([]map[string][]string{{"name": {"<em>Red</em> shirt"}}, nil}, highlights); d != nil {
+			t.Error(d)
+		}
+		if rows.TotalRows() != 2 {
+			t.Errorf("Unexpected total rows: %d", rows.TotalRows())
+		}
+		if bm := rows.(driver.Bookmarker).Bookmark(); bm != "g1AAAA" {
+			t.Errorf("Unexpected bookmark: %s", bm)
+		}
+		if d := testy.DiffInterface(map[string]map[string]int64{"color": {"red": 2}}, reporter.Counts()); d != nil {
+			t.Error(d)
+		}
+		if d := testy.DiffInterface(map[string]map[string]int64{"price": {"cheap": 1, "expensive": 1}}, reporter.Ranges()); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("grouped", func(t *testing.T) {
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, `{"total_rows":3,"groups":[
+{"by":"shirts","total_rows":2,"rows":[{"id":"a","order":[1.0,0],"fields":{}},{"id":"b","order":[0.8,1],"fields":{}}]},
+{"by":"hats","total_rows":1,"rows":[{"id":"c","order":[0.5,2],"fields":{"name":"Red hat"}}]}
+]}`), nil
+		})
+		rows, err := db.Search(context.Background(), "products", "by-text", "red", map[string]interface{}{"group_field": "category"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row driver.Row
+		if err := rows.Next(&row); err != io.EOF {
+			t.Errorf("Expected no rows, got %v", err)
+		}
+		expected := []SearchGroup{
+			{By: "shirts", TotalRows: 2, Rows: []SearchRow{
+				{ID: "a", Order: []interface{}{1.0, 0.0}, Fields: map[string]interface{}{}},
+				{ID: "b", Order: []interface{}{0.8, 1.0}, Fields: map[string]interface{}{}},
+			}},
+			{By: "hats", TotalRows: 1, Rows: []SearchRow{
+				{ID: "c", Order: []interface{}{0.5, 2.0}, Fields: map[string]interface{}{"name": "Red hat"}},
+			}},
+		}
+		if d := testy.DiffInterface(expected, rows.(SearchReporter).Groups()); d != nil {
+			t.Error(d)
+		}
+		if rows.TotalRows() != 3 {
+			t.Errorf("Unexpected total rows: %d", rows.TotalRows())
+		}
+	})
+}
+
+func TestSearchInfo(t *testing.T) {
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/testdb/_design/products/_search_info/by-text" {
+			t.Errorf("Unexpected path: %s", req.URL.Path)
+		}
+		return jsonResponse(http.StatusOK, `{"name":"_design/products/by-text","search_index":{"pending_seq":7,"doc_del_count":1,"doc_count":42,"disk_size":8192,"committed_seq":5}}`), nil
+	})
+	info, err := db.SearchInfo(context.Background(), "products", "by-text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.RawResponse = nil
+	expected := &driver.SearchInfo{
+		Name: "_design/products/by-text",
+		SearchIndex: driver.SearchIndex{
+			PendingSeq:   7,
+			DocDelCount:  1,
+			DocCount:     42,
+			DiskSize:     8192,
+			CommittedSeq: 5,
+		},
+	}
+	if d := testy.DiffInterface(expected, info); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSearchAnalyze(t *testing.T) {
+	var body map[string]string
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/_search_analyze" {
+			t.Errorf("Unexpected path: %s", req.URL.Path)
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		return jsonResponse(http.StatusOK, `{"tokens":["red","shirt"]}`), nil
+	})
+	tokens, err := db.SearchAnalyze(context.Background(), "Red Shirts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := testy.DiffInterface([]string{"red", "shirt"}, tokens); d != nil {
+		t.Error(d)
+	}
+	if d := testy.DiffInterface(map[string]string{"analyzer": "standard", "text": "Red Shirts"}, body); d != nil {
+		t.Error(d)
+	}
+	_, err = db.AnalyzeSearchText(context.Background(), "", "x")
+	testy.StatusError(t, "kivik: analyzer required", http.StatusBadRequest, err)
+}
+
+// searchHandler serves docCount results from _search.
+func searchHandler(docCount int) func(*http.Request) (*http.Response, error) {
+	return bookmarkHandler(docCount, func(start, end int, bookmark string) string {
+		rows := []string{}
+		for i := start; i < end; i++ {
+			rows = append(rows, fmt.Sprintf(`{"id":"doc%d","order":[1.0,%d],"fields":{}}`, i, i))
+		}
+		return fmt.Sprintf(`{"total_rows":%d,"bookmark":"%s","rows":[%s],"counts":{"n":{"x":%d}}}`,
+			docCount, bookmark, strings.Join(rows, ","), docCount)
+	})
+}
+
+func TestSearchPager(t *testing.T) {
+	t.Run("grouped", func(t *testing.T) {
+		_, err := NewSearchPager(newTestDB(nil, nil), "d", "i", "q", map[string]interface{}{"group_field": "x"})
+		testy.StatusError(t, "kivik: grouped search results cannot be paged", http.StatusBadRequest, err)
+	})
+	t.Run("pages", func(t *testing.T) {
+		var requests []string
+		pager, err := NewSearchPager(newRecordingDB(&requests, searchHandler(5)), "d", "i", "q", map[string]interface{}{"limit": 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface([]int{2, 2, 1}, readPageSizes(t, pager)); d != nil {
+			t.Error(d)
+		}
+		expected := []string{
+			`POST /testdb/_design/d/_search/i {"limit":2,"query":"q"}`,
+			`POST /testdb/_design/d/_search/i {"bookmark":"b2","limit":2,"query":"q"}`,
+			`POST /testdb/_design/d/_search/i {"bookmark":"b4","limit":2,"query":"q"}`,
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+		if !pager.Done() {
+			t.Error("Expected pager to be done")
+		}
+	})
+	t.Run("all rows, resumed", func(t *testing.T) {
+		var requests []string
+		pager, err := NewSearchPager(newRecordingDB(&requests, searchHandler(4)), "d", "i", "q", map[string]interface{}{"limit": 2, "bookmark": "b1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := pager.Rows(context.Background())
+		var ids []string
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			ids = append(ids, row.ID)
+		}
+		if d := testy.DiffInterface([]string{"doc1", "doc2", "doc3"}, ids); d != nil {
+			t.Error(d)
+		}
+		expected := []string{
+			`POST /testdb/_design/d/_search/i {"bookmark":"b1","limit":2,"query":"q"}`,
+			`POST /testdb/_design/d/_search/i {"bookmark":"b3","limit":2,"query":"q"}`,
+		}
+		if d := testy.DiffInterface(expected, requests); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("counts", func(t *testing.T) {
+		pager, err := NewSearchPager(newCustomDB(searchHandler(5)), "d", "i", "q", map[string]interface{}{"limit": 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := pager.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row driver.Row
+		for rows.Next(&row) == nil {
+		}
+		if d := testy.DiffInterface(map[string]map[string]int64{"n": {"x": 5}}, rows.(SearchReporter).Counts()); d != nil {
+			t.Error(d)
+		}
+	})
+}
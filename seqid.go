@@ -12,7 +12,11 @@
 
 package couchdb
 
-import "bytes"
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
 
 // sequenceID is a CouchDB update sequence ID. This is just a string, but has
 // a special JSON unmarshaler to work with both CouchDB 2.0.0 (which uses
@@ -25,3 +29,18 @@ func (id *sequenceID) UnmarshalJSON(data []byte) error {
 	*id = sid
 	return nil
 }
+
+// number returns the numeric prefix of the sequence ID, which for CouchDB 2.0
+// and later is the sum of the shard sequence numbers, or -1 if the ID has no
+// numeric prefix.
+func (id sequenceID) number() int64 {
+	s := string(id)
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FullScanError is returned by Find in strict Mango mode, when CouchDB would
+// answer the query by scanning every document in the database, rather than
+// with an index. See OptionStrictMango.
+type FullScanError struct {
+	// Index is the index CouchDB selected for the query, as reported by
+	// _explain.
+	Index map[string]interface{}
+	// Fields are the fields referenced by the query's selector.
+	Fields []string
+}
+
+var _ error = &FullScanError{}
+
+func (e *FullScanError) Error() string {
+	name, _ := e.Index["name"].(string)
+	return fmt.Sprintf("kivik: no usable index for query on fields [%s]; CouchDB selected index `%s`",
+		strings.Join(e.Fields, ", "), name)
+}
+
+// StatusCode returns http.StatusBadRequest.
+func (e *FullScanError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// strictMango reports whether strict Mango mode applies to a query with the
+// given options, removing OptionStrictMango from opts.
+func (d *db) strictMango(opts map[string]interface{}) (bool, error) {
+	v, ok := opts[OptionStrictMango]
+	if !ok {
+		return d.client.strictMango, nil
+	}
+	delete(opts, OptionStrictMango)
+	strict, ok := v.(bool)
+	if !ok {
+		return false, mangoError("invalid value for %s: %v", OptionStrictMango, v)
+	}
+	return strict, nil
+}
+
+// checkIndex explains query, and returns a *FullScanError if CouchDB would
+// answer it with the special _all_docs index.
+func (d *db) checkIndex(ctx context.Context, query interface{}, opts map[string]interface{}) error {
+	explainOpts := map[string]interface{}{}
+	if part, ok := opts[OptionPartition]; ok {
+		explainOpts[OptionPartition] = part
+	}
+	plan, err := d.Explain(ctx, query, explainOpts)
+	if err != nil {
+		return err
+	}
+	if typ, _ := plan.Index["type"].(string); typ != "special" {
+		return nil
+	}
+	return &FullScanError{
+		Index:  plan.Index,
+		Fields: selectorFields(plan.Selector),
+	}
+}
+
+// selectorFields returns the sorted list of fields referenced by a selector.
+// Fields compared by $elemMatch, $allMatch and $keyMapMatch are reported by
+// the name of the containing field only.
+func selectorFields(selector map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var walk func(prefix string, sel map[string]interface{})
+	walk = func(prefix string, sel map[string]interface{}) {
+		for key, value := range sel {
+			if strings.HasPrefix(key, "$") {
+				switch t := value.(type) {
+				case []interface{}:
+					for _, v := range t {
+						if m, ok := v.(map[string]interface{}); ok {
+							walk(prefix, m)
+						}
+					}
+				case map[string]interface{}:
+					if key == "$not" {
+						walk(prefix, t)
+					}
+				}
+				if prefix != "" {
+					seen[prefix] = true
+				}
+				continue
+			}
+			field := key
+			if prefix != "" {
+				field = prefix + "." + key
+			}
+			if m, ok := value.(map[string]interface{}); ok {
+				walk(field, m)
+				continue
+			}
+			seen[field] = true
+		}
+	}
+	walk("", selector)
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
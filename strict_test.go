@@ -0,0 +1,133 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+const (
+	fullScanPlan = `{"dbname":"testdb","index":{"ddoc":null,"name":"_all_docs","type":"special","def":{"fields":[{"_id":"asc"}]}},"selector":{"$and":[{"type":{"$eq":"user"}},{"age":{"$gt":18}}]},"opts":{},"limit":25,"skip":0,"fields":"all_fields"}`
+	indexedPlan  = `{"dbname":"testdb","index":{"ddoc":"_design/idx","name":"by-type","type":"json","def":{"fields":[{"type":"asc"}]}},"selector":{"type":{"$eq":"user"}},"opts":{},"limit":25,"skip":0,"fields":"all_fields"}`
+)
+
+func TestFindStrict(t *testing.T) {
+	type tst struct {
+		clientStrict bool
+		opts         map[string]interface{}
+		plan         string
+		paths        []string
+		status       int
+		err          string
+	}
+	tests := testy.NewTable()
+	tests.Add("client default, full scan", tst{
+		clientStrict: true,
+		plan:         fullScanPlan,
+		paths:        []string{"/testdb/_explain"},
+		status:       http.StatusBadRequest,
+		err:          "kivik: no usable index for query on fields [age, type]; CouchDB selected index `_all_docs`",
+	})
+	tests.Add("option, indexed", tst{
+		opts:  map[string]interface{}{OptionStrictMango: true},
+		plan:  indexedPlan,
+		paths: []string{"/testdb/_explain", "/testdb/_find"},
+	})
+	tests.Add("option overrides client default", tst{
+		clientStrict: true,
+		opts:         map[string]interface{}{OptionStrictMango: false},
+		paths:        []string{"/testdb/_find"},
+	})
+	tests.Add("partitioned", tst{
+		opts:   map[string]interface{}{OptionStrictMango: true, OptionPartition: "p1"},
+		plan:   fullScanPlan,
+		paths:  []string{"/testdb/_partition/p1/_explain"},
+		status: http.StatusBadRequest,
+		err:    "kivik: no usable index for query on fields [age, type]; CouchDB selected index `_all_docs`",
+	})
+	tests.Add("invalid option", tst{
+		opts:   map[string]interface{}{OptionStrictMango: "yes"},
+		status: http.StatusBadRequest,
+		err:    "kivik: invalid value for kivik:strict-mango: yes",
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		var paths []string
+		db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+			paths = append(paths, req.URL.Path)
+			if strings.HasSuffix(req.URL.Path, "/_explain") {
+				return jsonResponse(http.StatusOK, test.plan), nil
+			}
+			return jsonResponse(http.StatusOK, `{"docs":[]}`), nil
+		})
+		db.client.strictMango = test.clientStrict
+		query := NewMangoQuery(And(Eq("type", "user"), Gt("age", 18)))
+		rows, err := db.Find(context.Background(), query, test.opts)
+		testy.StatusError(t, test.err, test.status, err)
+		if err == nil {
+			_ = rows.Close()
+		}
+		if d := testy.DiffInterface(test.paths, paths); d != nil {
+			t.Error(d)
+		}
+		if fse, ok := err.(*FullScanError); ok {
+			if name := fse.Index["name"]; name != "_all_docs" {
+				t.Errorf("Unexpected index: %v", name)
+			}
+		}
+	})
+}
+
+func TestSelectorFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "empty",
+			expected: []string{},
+		},
+		{
+			name: "implicit equality and nesting",
+			selector: map[string]interface{}{
+				"name":    "bob",
+				"address": map[string]interface{}{"city": map[string]interface{}{"$eq": "Paris"}},
+			},
+			expected: []string{"address.city", "name"},
+		},
+		{
+			name: "combinations",
+			selector: map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"a": map[string]interface{}{"$gt": 1}},
+					map[string]interface{}{"$not": map[string]interface{}{"b": true}},
+				},
+				"tags": map[string]interface{}{"$elemMatch": map[string]interface{}{"name": "x"}},
+			},
+			expected: []string{"a", "b", "tags"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if d := testy.DiffInterface(test.expected, selectorFields(test.selector)); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
@@ -0,0 +1 @@
+function(newDoc, oldDoc, userCtx) {}
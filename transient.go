@@ -0,0 +1,163 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kivik/couchdb/v4/chttp"
+)
+
+// TransientReplicator is implemented by the driver's client, to run
+// replications with POST /_replicate, which leaves no document in the
+// _replicator database.
+type TransientReplicator interface {
+	// ReplicateTransient starts a replication from sourceDSN to targetDSN,
+	// with the same options as Replicate. A one-shot replication blocks until
+	// it is complete, and its result reports what was replicated. A
+	// continuous replication returns once started, and runs until cancelled
+	// with CancelReplication, or until the server restarts.
+	ReplicateTransient(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (*TransientReplication, error)
+	// CancelReplication cancels a running transient replication, given the
+	// replication ID reported when it was started, or listed by
+	// _active_tasks. It is not supported by CouchDB 1.x, which identifies
+	// replications to cancel by their options instead: cancel those by
+	// calling ReplicateTransient with the original options and the cancel
+	// option set to true.
+	CancelReplication(ctx context.Context, replicationID string) error
+}
+
+var _ TransientReplicator = &client{}
+
+// TransientReplication is the result of a transient replication.
+type TransientReplication struct {
+	// ReplicationID identifies a continuous replication, for
+	// CancelReplication.
+	ReplicationID string
+	// SessionID identifies the run of a one-shot replication.
+	SessionID string
+	// SourceLastSeq is the last source sequence replicated.
+	SourceLastSeq string
+	// NoChanges is true if the target was already up to date.
+	NoChanges bool
+	// History lists the sessions recorded in the replication's checkpoint,
+	// the current one first.
+	History []ReplicationHistory
+}
+
+// Stats returns the statistics of the current session, or the zero value if
+// there is none.
+func (r *TransientReplication) Stats() ReplicationHistory {
+	for _, h := range r.History {
+		if h.SessionID == r.SessionID {
+			return h
+		}
+	}
+	return ReplicationHistory{}
+}
+
+// ReplicationHistory is a session recorded in a replication checkpoint.
+type ReplicationHistory struct {
+	SessionID        string
+	StartTime        time.Time
+	EndTime          time.Time
+	StartLastSeq     string
+	EndLastSeq       string
+	RecordedSeq      string
+	MissingChecked   int64
+	MissingFound     int64
+	DocsRead         int64
+	DocsWritten      int64
+	DocWriteFailures int64
+}
+
+type transientResponse struct {
+	LocalID       string     `json:"_local_id"`
+	SessionID     string     `json:"session_id"`
+	SourceLastSeq sequenceID `json:"source_last_seq"`
+	NoChanges     bool       `json:"no_changes"`
+	History       []struct {
+		SessionID        string               `json:"session_id"`
+		StartTime        replicationStateTime `json:"start_time"`
+		EndTime          replicationStateTime `json:"end_time"`
+		StartLastSeq     sequenceID           `json:"start_last_seq"`
+		EndLastSeq       sequenceID           `json:"end_last_seq"`
+		RecordedSeq      sequenceID           `json:"recorded_seq"`
+		MissingChecked   int64                `json:"missing_checked"`
+		MissingFound     int64                `json:"missing_found"`
+		DocsRead         int64                `json:"docs_read"`
+		DocsWritten      int64                `json:"docs_written"`
+		DocWriteFailures int64                `json:"doc_write_failures"`
+	} `json:"history"`
+}
+
+func (c *client) ReplicateTransient(ctx context.Context, targetDSN, sourceDSN string, options map[string]interface{}) (*TransientReplication, error) {
+	options, err := replicationOptions(targetDSN, sourceDSN, options)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.postReplicate(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	rep := &TransientReplication{
+		ReplicationID: result.LocalID,
+		SessionID:     result.SessionID,
+		SourceLastSeq: string(result.SourceLastSeq),
+		NoChanges:     result.NoChanges,
+		History:       make([]ReplicationHistory, 0, len(result.History)),
+	}
+	for _, h := range result.History {
+		rep.History = append(rep.History, ReplicationHistory{
+			SessionID:        h.SessionID,
+			StartTime:        time.Time(h.StartTime),
+			EndTime:          time.Time(h.EndTime),
+			StartLastSeq:     string(h.StartLastSeq),
+			EndLastSeq:       string(h.EndLastSeq),
+			RecordedSeq:      string(h.RecordedSeq),
+			MissingChecked:   h.MissingChecked,
+			MissingFound:     h.MissingFound,
+			DocsRead:         h.DocsRead,
+			DocsWritten:      h.DocsWritten,
+			DocWriteFailures: h.DocWriteFailures,
+		})
+	}
+	return rep, nil
+}
+
+func (c *client) CancelReplication(ctx context.Context, replicationID string) error {
+	if replicationID == "" {
+		return missingArg("replicationID")
+	}
+	_, err := c.postReplicate(ctx, map[string]interface{}{
+		"replication_id": replicationID,
+		"cancel":         true,
+	})
+	return err
+}
+
+func (c *client) postReplicate(ctx context.Context, body map[string]interface{}) (*transientResponse, error) {
+	opts := &chttp.Options{
+		GetBody: chttp.BodyEncoder(body),
+		Header: http.Header{
+			chttp.HeaderIdempotencyKey: []string{},
+		},
+	}
+	result := &transientResponse{}
+	if _, err := c.Client.DoJSON(ctx, http.MethodPost, "/_replicate", opts, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
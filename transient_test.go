@@ -0,0 +1,184 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestReplicateTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		source    string
+		options   map[string]interface{}
+		body      string
+		status    int
+		response  string
+		expected  *TransientReplication
+		errStatus int
+		err       string
+	}{
+		{
+			name:      "missing target",
+			source:    "foo",
+			errStatus: http.StatusBadRequest,
+			err:       "kivik: targetDSN required",
+		},
+		{
+			name:      "missing source",
+			target:    "foo",
+			errStatus: http.StatusBadRequest,
+			err:       "kivik: sourceDSN required",
+		},
+		{
+			name:   "one shot",
+			target: "http://localhost:5984/bar",
+			source: "http://localhost:5984/foo",
+			body:   `{"source":"http://localhost:5984/foo","target":"http://localhost:5984/bar"}`,
+			status: http.StatusOK,
+			response: `{"ok":true,"session_id":"6cc9b1e2","source_last_seq":"5-g1AAAA","replication_id_version":4,"history":[
+				{"session_id":"6cc9b1e2","start_time":"Thu, 10 Oct 2019 12:00:00 GMT","end_time":"Thu, 10 Oct 2019 12:00:05 GMT","start_last_seq":0,"end_last_seq":"5-g1AAAA","recorded_seq":"5-g1AAAA","missing_checked":5,"missing_found":4,"docs_read":4,"docs_written":3,"doc_write_failures":1},
+				{"session_id":"4d1b2cfa","start_time":"Wed, 09 Oct 2019 12:00:00 GMT","end_time":"","start_last_seq":0,"end_last_seq":0,"recorded_seq":0,"missing_checked":0,"missing_found":0,"docs_read":0,"docs_written":0,"doc_write_failures":0}
+			]}`,
+			expected: &TransientReplication{
+				SessionID:     "6cc9b1e2",
+				SourceLastSeq: "5-g1AAAA",
+				History: []ReplicationHistory{
+					{
+						SessionID:        "6cc9b1e2",
+						StartTime:        time.Date(2019, 10, 10, 12, 0, 0, 0, time.UTC),
+						EndTime:          time.Date(2019, 10, 10, 12, 0, 5, 0, time.UTC),
+						StartLastSeq:     "0",
+						EndLastSeq:       "5-g1AAAA",
+						RecordedSeq:      "5-g1AAAA",
+						MissingChecked:   5,
+						MissingFound:     4,
+						DocsRead:         4,
+						DocsWritten:      3,
+						DocWriteFailures: 1,
+					},
+					{
+						SessionID:    "4d1b2cfa",
+						StartTime:    time.Date(2019, 10, 9, 12, 0, 0, 0, time.UTC),
+						StartLastSeq: "0",
+						EndLastSeq:   "0",
+						RecordedSeq:  "0",
+					},
+				},
+			},
+		},
+		{
+			name:     "no changes",
+			target:   "bar",
+			source:   "foo",
+			body:     `{"source":"foo","target":"bar"}`,
+			status:   http.StatusOK,
+			response: `{"ok":true,"no_changes":true}`,
+			expected: &TransientReplication{NoChanges: true, History: []ReplicationHistory{}},
+		},
+		{
+			name:     "continuous",
+			target:   "bar",
+			source:   "foo",
+			options:  map[string]interface{}{"continuous": true},
+			body:     `{"continuous":true,"source":"foo","target":"bar"}`,
+			status:   http.StatusAccepted,
+			response: `{"ok":true,"_local_id":"0a81b645497e6270611ec3419767a584+continuous"}`,
+			expected: &TransientReplication{ReplicationID: "0a81b645497e6270611ec3419767a584+continuous", History: []ReplicationHistory{}},
+		},
+		{
+			name:      "source not found",
+			target:    "bar",
+			source:    "foo",
+			body:      `{"source":"foo","target":"bar"}`,
+			status:    http.StatusNotFound,
+			response:  `{"error":"not_found","reason":"could not open foo"}`,
+			errStatus: http.StatusNotFound,
+			err:       "Not Found",
+		},
+		{
+			name:      "invalid time",
+			target:    "bar",
+			source:    "foo",
+			body:      `{"source":"foo","target":"bar"}`,
+			status:    http.StatusOK,
+			response:  `{"ok":true,"session_id":"x","history":[{"session_id":"x","start_time":"yesterday"}]}`,
+			errStatus: http.StatusBadGateway,
+			err:       `kivik: '"yesterday"' does not appear to be a valid timestamp`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+				if req.Method != http.MethodPost || req.URL.Path != "/_replicate" {
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+				}
+				body, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				if d := testy.DiffJSON([]byte(test.body), body); d != nil {
+					t.Errorf("Unexpected body:\n%s", d)
+				}
+				resp := jsonResponse(test.status, test.response)
+				resp.Request = req
+				return resp, nil
+			})
+			result, err := c.ReplicateTransient(context.Background(), test.target, test.source, test.options)
+			testy.StatusError(t, test.err, test.errStatus, err)
+			if d := testy.DiffInterface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+			if test.expected.Stats().SessionID != test.expected.SessionID {
+				t.Errorf("Unexpected stats: %+v", test.expected.Stats())
+			}
+		})
+	}
+}
+
+func TestCancelReplication(t *testing.T) {
+	t.Run("missing ID", func(t *testing.T) {
+		err := newTestClient(nil, nil).CancelReplication(context.Background(), "")
+		testy.StatusError(t, "kivik: replicationID required", http.StatusBadRequest, err)
+	})
+	t.Run("success", func(t *testing.T) {
+		c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			if d := testy.DiffJSON([]byte(`{"replication_id":"abc+continuous","cancel":true}`), body); d != nil {
+				t.Errorf("Unexpected body:\n%s", d)
+			}
+			return jsonResponse(http.StatusOK, `{"ok":true,"_local_id":"abc+continuous"}`), nil
+		})
+		err := c.CancelReplication(context.Background(), "abc+continuous")
+		testy.Error(t, "", err)
+	})
+	t.Run("not running", func(t *testing.T) {
+		c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+			resp := jsonResponse(http.StatusNotFound, `{"error":"not_found","reason":"abc+continuous"}`)
+			resp.Request = req
+			return resp, nil
+		})
+		err := c.CancelReplication(context.Background(), "abc+continuous")
+		testy.StatusError(t, "Not Found", http.StatusNotFound, err)
+	})
+}
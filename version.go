@@ -20,6 +20,21 @@ import (
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
+// FeatureNouveau is the entry in Version().Features of servers with Nouveau
+// search enabled, added in CouchDB 3.4.
+const FeatureNouveau = "nouveau"
+
+// HasFeature returns true if feature is one of the features reported by the
+// server, as returned in the Features field of Version().
+func HasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 // Version returns the server's version info.
 func (c *client) Version(ctx context.Context) (*driver.Version, error) {
 	i := &info{}
@@ -34,6 +49,7 @@ func (c *client) Version(ctx context.Context) (*driver.Version, error) {
 
 type info struct {
 	Data     json.RawMessage
+	UUID     string   `json:"uuid"`
 	Version  string   `json:"version"`
 	Features []string `json:"features"`
 	Vendor   struct {
@@ -48,8 +64,27 @@ func (i *info) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	i.Data = data
+	i.UUID = a.UUID
 	i.Version = a.Version
 	i.Vendor = a.Vendor
 	i.Features = a.Features
 	return nil
 }
+
+// hasFeature reports whether the server has feature enabled. The server's
+// features are read once, and cached.
+func (c *client) hasFeature(ctx context.Context, feature string) (bool, error) {
+	c.featuresMU.Lock()
+	defer c.featuresMU.Unlock()
+	if c.features == nil {
+		version, err := c.Version(ctx)
+		if err != nil {
+			return false, err
+		}
+		c.features = version.Features
+		if c.features == nil {
+			c.features = []string{}
+		}
+	}
+	return HasFeature(c.features, feature), nil
+}
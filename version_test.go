@@ -126,3 +126,26 @@ func TestVersionConstant(t *testing.T) {
 			Version, chttp.Version)
 	}
 }
+
+func TestHasFeature(t *testing.T) {
+	var requests int
+	c := newCustomClient(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return jsonResponse(http.StatusOK, `{"couchdb":"Welcome","version":"3.4.1","features":["nouveau","scheduler"]}`), nil
+	})
+	for _, feature := range []string{FeatureNouveau, "reshard"} {
+		ok, err := c.hasFeature(context.Background(), feature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (feature == FeatureNouveau) {
+			t.Errorf("Unexpected result for %s: %t", feature, ok)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected features to be read once, got %d requests", requests)
+	}
+	if HasFeature(nil, FeatureNouveau) {
+		t.Error("Expected no features")
+	}
+}
@@ -0,0 +1,276 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"fmt"
+	"net/http"
+
+	kivik "github.com/go-kivik/kivik/v4"
+)
+
+// Update modes accepted by ViewQuery.Update.
+const (
+	UpdateTrue  = "true"
+	UpdateFalse = "false"
+	UpdateLazy  = "lazy"
+)
+
+// ViewQuery is a typed builder for the options accepted by Query, AllDocs,
+// DesignDocs and LocalDocs. The zero value is not usable; create a new query
+// with NewViewQuery.
+//
+// Example:
+//
+//    opts, err := couchdb.NewViewQuery().
+//        StartKey([]interface{}{"foo"}).
+//        EndKey([]interface{}{"foo", map[string]interface{}{}}).
+//        Reduce(false).
+//        Limit(10).
+//        Options()
+//    rows, err := db.Query(ctx, "ddoc", "view", opts)
+//
+// Keys may be any value which can be marshaled to JSON. To query for a null
+// key, pass json.RawMessage("null").
+type ViewQuery struct {
+	opts map[string]interface{}
+}
+
+// NewViewQuery returns a new, empty view query.
+func NewViewQuery() *ViewQuery {
+	return &ViewQuery{opts: map[string]interface{}{}}
+}
+
+func (q *ViewQuery) set(key string, value interface{}) *ViewQuery {
+	q.opts[key] = value
+	return q
+}
+
+// Key limits the result to rows matching key.
+func (q *ViewQuery) Key(key interface{}) *ViewQuery { return q.set("key", key) }
+
+// Keys limits the result to rows matching any of keys. Results are returned
+// in the order of keys.
+func (q *ViewQuery) Keys(keys ...interface{}) *ViewQuery { return q.set("keys", keys) }
+
+// StartKey returns rows starting with the specified key.
+func (q *ViewQuery) StartKey(key interface{}) *ViewQuery { return q.set("startkey", key) }
+
+// EndKey stops returning rows when the specified key is reached.
+func (q *ViewQuery) EndKey(key interface{}) *ViewQuery { return q.set("endkey", key) }
+
+// StartKeyDocID returns rows starting with the specified document ID, among
+// rows matching StartKey.
+func (q *ViewQuery) StartKeyDocID(id string) *ViewQuery { return q.set("startkey_docid", id) }
+
+// EndKeyDocID stops returning rows when the specified document ID is reached,
+// among rows matching EndKey.
+func (q *ViewQuery) EndKeyDocID(id string) *ViewQuery { return q.set("endkey_docid", id) }
+
+// Descending returns rows in descending key order.
+func (q *ViewQuery) Descending(desc bool) *ViewQuery { return q.set("descending", desc) }
+
+// InclusiveEnd controls whether rows matching EndKey are included in the
+// result. CouchDB defaults to true.
+func (q *ViewQuery) InclusiveEnd(inclusive bool) *ViewQuery {
+	return q.set("inclusive_end", inclusive)
+}
+
+// Reduce controls whether the reduce function is used. CouchDB defaults to
+// true, if the view has a reduce function.
+func (q *ViewQuery) Reduce(reduce bool) *ViewQuery { return q.set("reduce", reduce) }
+
+// Group groups the reduce results by key.
+func (q *ViewQuery) Group(group bool) *ViewQuery { return q.set("group", group) }
+
+// GroupLevel groups the reduce results by the first level elements of array
+// keys.
+func (q *ViewQuery) GroupLevel(level int) *ViewQuery { return q.set("group_level", level) }
+
+// IncludeDocs includes the associated document with each row.
+func (q *ViewQuery) IncludeDocs(include bool) *ViewQuery { return q.set("include_docs", include) }
+
+// Conflicts includes conflicts information in the included documents. Only
+// valid with IncludeDocs.
+func (q *ViewQuery) Conflicts(conflicts bool) *ViewQuery { return q.set("conflicts", conflicts) }
+
+// Stable, when true, requests that the same shard replicas be used for every
+// request, to produce stable results in clustered environments.
+func (q *ViewQuery) Stable(stable bool) *ViewQuery { return q.set("stable", stable) }
+
+// Update controls whether the view is updated before the results are
+// returned. It must be one of UpdateTrue, UpdateFalse or UpdateLazy.
+func (q *ViewQuery) Update(mode string) *ViewQuery { return q.set("update", mode) }
+
+// Limit limits the number of rows returned.
+func (q *ViewQuery) Limit(limit int) *ViewQuery { return q.set("limit", limit) }
+
+// Skip skips the specified number of rows before returning results.
+func (q *ViewQuery) Skip(skip int) *ViewQuery { return q.set("skip", skip) }
+
+// UpdateSeq includes the database update sequence in the result.
+func (q *ViewQuery) UpdateSeq(updateSeq bool) *ViewQuery { return q.set("update_seq", updateSeq) }
+
+// Partition limits the query to the named partition. See OptionPartition.
+func (q *ViewQuery) Partition(partition string) *ViewQuery {
+	return q.set(OptionPartition, partition)
+}
+
+// Options validates the query, and returns it as an options map suitable for
+// passing to Query, DesignDocs or LocalDocs.
+func (q *ViewQuery) Options() (map[string]interface{}, error) {
+	if err := validateViewQuery(q.opts); err != nil {
+		return nil, err
+	}
+	return q.copy(), nil
+}
+
+// AllDocsOptions works as Options, but additionally rejects options not
+// supported by _all_docs, _design_docs and _local_docs, which have no reduce
+// function.
+func (q *ViewQuery) AllDocsOptions() (map[string]interface{}, error) {
+	for _, key := range []string{"reduce", "group", "group_level"} {
+		if _, ok := q.opts[key]; ok {
+			return nil, viewQueryError("`%s` is invalid for _all_docs", key)
+		}
+	}
+	return q.Options()
+}
+
+func (q *ViewQuery) copy() map[string]interface{} {
+	opts := make(map[string]interface{}, len(q.opts))
+	for k, v := range q.opts {
+		opts[k] = v
+	}
+	return opts
+}
+
+func viewQueryError(format string, args ...interface{}) error {
+	return &kivik.Error{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("kivik: "+format, args...)}
+}
+
+// viewOptionAliases maps the alternate spellings of view options accepted by
+// CouchDB to the names used by ViewQuery.
+var viewOptionAliases = map[string]string{
+	"start_key":        "startkey",
+	"end_key":          "endkey",
+	"start_key_doc_id": "startkey_docid",
+	"end_key_doc_id":   "endkey_docid",
+}
+
+var knownViewOptions = map[string]bool{
+	"key": true, "keys": true, "startkey": true, "endkey": true,
+	"startkey_docid": true, "endkey_docid": true, "descending": true,
+	"inclusive_end": true, "reduce": true, "group": true, "group_level": true,
+	"include_docs": true, "conflicts": true, "attachments": true,
+	"att_encoding_info": true, "stable": true, "stale": true, "update": true,
+	"limit": true, "skip": true, "update_seq": true, "sorted": true,
+	"queries": true, OptionPartition: true,
+}
+
+// ValidateViewOptions checks an options map intended for Query, AllDocs,
+// DesignDocs or LocalDocs for unknown keys, such as misspellings, and for
+// invalid combinations of options, as NewViewQuery().Options() does.
+func ValidateViewOptions(opts map[string]interface{}) error {
+	_, err := normalizeViewOptions(opts)
+	return err
+}
+
+// normalizeViewOptions validates opts as ValidateViewOptions does, and returns
+// a copy in which aliases are replaced by the names used by ViewQuery.
+func normalizeViewOptions(opts map[string]interface{}) (map[string]interface{}, error) {
+	normalized := make(map[string]interface{}, len(opts))
+	for key, value := range opts {
+		if alias, ok := viewOptionAliases[key]; ok {
+			key = alias
+		}
+		if !knownViewOptions[key] {
+			return nil, viewQueryError("unknown view option `%s`", key)
+		}
+		if _, ok := normalized[key]; ok {
+			return nil, viewQueryError("`%s` specified more than once", key)
+		}
+		normalized[key] = value
+	}
+	if err := validateViewQuery(normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func validateViewQuery(opts map[string]interface{}) error {
+	has := func(key string) bool {
+		_, ok := opts[key]
+		return ok
+	}
+	isTrue := func(key string) bool {
+		v, _ := opts[key].(bool)
+		return v
+	}
+	isFalse := func(key string) bool {
+		v, ok := opts[key].(bool)
+		return ok && !v
+	}
+	if has("keys") {
+		for _, key := range []string{"key", "startkey", "endkey"} {
+			if has(key) {
+				return viewQueryError("`keys` is incompatible with `%s`", key)
+			}
+		}
+	}
+	if has("key") {
+		for _, key := range []string{"startkey", "endkey"} {
+			if has(key) {
+				return viewQueryError("`key` is incompatible with `%s`", key)
+			}
+		}
+	}
+	if has("startkey_docid") && !has("startkey") {
+		return viewQueryError("`startkey_docid` requires `startkey`")
+	}
+	if has("endkey_docid") && !has("endkey") {
+		return viewQueryError("`endkey_docid` requires `endkey`")
+	}
+	if isFalse("reduce") {
+		for _, key := range []string{"group", "group_level"} {
+			if has(key) {
+				return viewQueryError("`%s` is invalid when `reduce` is false", key)
+			}
+		}
+	}
+	if has("group_level") && isFalse("group") {
+		return viewQueryError("`group_level` is invalid when `group` is false")
+	}
+	if isTrue("include_docs") && isTrue("reduce") {
+		return viewQueryError("`include_docs` is invalid for reduce")
+	}
+	if isTrue("conflicts") && !isTrue("include_docs") {
+		return viewQueryError("`conflicts` requires `include_docs`")
+	}
+	for _, key := range []string{"limit", "skip", "group_level"} {
+		if v, ok := opts[key].(int); ok && v < 0 {
+			return viewQueryError("`%s` must not be negative", key)
+		}
+	}
+	if mode, ok := opts["update"]; ok {
+		switch mode {
+		case UpdateTrue, UpdateFalse, UpdateLazy:
+		default:
+			return viewQueryError("invalid `update` mode: %v", mode)
+		}
+	}
+	if p, ok := opts[OptionPartition]; ok && p == "" {
+		return viewQueryError("partition must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+)
+
+func TestViewQueryOptions(t *testing.T) {
+	type tst struct {
+		query    *ViewQuery
+		allDocs  bool
+		expected map[string]interface{}
+		status   int
+		err      string
+	}
+	tests := testy.NewTable()
+	tests.Add("empty", tst{
+		query:    NewViewQuery(),
+		expected: map[string]interface{}{},
+	})
+	tests.Add("range", tst{
+		query: NewViewQuery().
+			StartKey([]interface{}{"foo"}).
+			StartKeyDocID("a").
+			EndKey([]interface{}{"foo", map[string]interface{}{}}).
+			InclusiveEnd(false).
+			Descending(true).
+			Limit(10).
+			Skip(2).
+			Stable(true).
+			Update(UpdateLazy).
+			UpdateSeq(true),
+		expected: map[string]interface{}{
+			"startkey":       []interface{}{"foo"},
+			"startkey_docid": "a",
+			"endkey":         []interface{}{"foo", map[string]interface{}{}},
+			"inclusive_end":  false,
+			"descending":     true,
+			"limit":          10,
+			"skip":           2,
+			"stable":         true,
+			"update":         "lazy",
+			"update_seq":     true,
+		},
+	})
+	tests.Add("grouped reduce", tst{
+		query:    NewViewQuery().Reduce(true).GroupLevel(2),
+		expected: map[string]interface{}{"reduce": true, "group_level": 2},
+	})
+	tests.Add("include docs", tst{
+		query:    NewViewQuery().Keys("a", "b").IncludeDocs(true).Conflicts(true).Partition("x"),
+		expected: map[string]interface{}{"keys": []interface{}{"a", "b"}, "include_docs": true, "conflicts": true, OptionPartition: "x"},
+	})
+	tests.Add("keys and key", tst{
+		query:  NewViewQuery().Keys("a").Key("b"),
+		status: http.StatusBadRequest,
+		err:    "kivik: `keys` is incompatible with `key`",
+	})
+	tests.Add("key and range", tst{
+		query:  NewViewQuery().Key("a").EndKey("b"),
+		status: http.StatusBadRequest,
+		err:    "kivik: `key` is incompatible with `endkey`",
+	})
+	tests.Add("docid without key", tst{
+		query:  NewViewQuery().StartKeyDocID("a"),
+		status: http.StatusBadRequest,
+		err:    "kivik: `startkey_docid` requires `startkey`",
+	})
+	tests.Add("group without reduce", tst{
+		query:  NewViewQuery().Reduce(false).GroupLevel(1),
+		status: http.StatusBadRequest,
+		err:    "kivik: `group_level` is invalid when `reduce` is false",
+	})
+	tests.Add("group level without group", tst{
+		query:  NewViewQuery().Group(false).GroupLevel(1),
+		status: http.StatusBadRequest,
+		err:    "kivik: `group_level` is invalid when `group` is false",
+	})
+	tests.Add("include docs with reduce", tst{
+		query:  NewViewQuery().Reduce(true).IncludeDocs(true),
+		status: http.StatusBadRequest,
+		err:    "kivik: `include_docs` is invalid for reduce",
+	})
+	tests.Add("conflicts without docs", tst{
+		query:  NewViewQuery().Conflicts(true),
+		status: http.StatusBadRequest,
+		err:    "kivik: `conflicts` requires `include_docs`",
+	})
+	tests.Add("negative limit", tst{
+		query:  NewViewQuery().Limit(-1),
+		status: http.StatusBadRequest,
+		err:    "kivik: `limit` must not be negative",
+	})
+	tests.Add("invalid update", tst{
+		query:  NewViewQuery().Update("sometimes"),
+		status: http.StatusBadRequest,
+		err:    "kivik: invalid `update` mode: sometimes",
+	})
+	tests.Add("all docs", tst{
+		query:    NewViewQuery().StartKey("a").Limit(5),
+		allDocs:  true,
+		expected: map[string]interface{}{"startkey": "a", "limit": 5},
+	})
+	tests.Add("all docs with reduce", tst{
+		query:   NewViewQuery().Reduce(false),
+		allDocs: true,
+		status:  http.StatusBadRequest,
+		err:     "kivik: `reduce` is invalid for _all_docs",
+	})
+
+	tests.Run(t, func(t *testing.T, test tst) {
+		var result map[string]interface{}
+		var err error
+		if test.allDocs {
+			result, err = test.query.AllDocsOptions()
+		} else {
+			result, err = test.query.Options()
+		}
+		testy.StatusError(t, test.err, test.status, err)
+		if d := testy.DiffInterface(test.expected, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestValidateViewOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   map[string]interface{}
+		status int
+		err    string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "aliases",
+			opts: map[string]interface{}{"start_key": "a", "start_key_doc_id": "b"},
+		},
+		{
+			name:   "typo",
+			opts:   map[string]interface{}{"start_key_docid": "a"},
+			status: http.StatusBadRequest,
+			err:    "kivik: unknown view option `start_key_docid`",
+		},
+		{
+			name:   "duplicate alias",
+			opts:   map[string]interface{}{"startkey": "a", "start_key": "b"},
+			status: http.StatusBadRequest,
+			err:    "kivik: `startkey` specified more than once",
+		},
+		{
+			name:   "invalid combination",
+			opts:   map[string]interface{}{"end_key_doc_id": "a"},
+			status: http.StatusBadRequest,
+			err:    "kivik: `endkey_docid` requires `endkey`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateViewOptions(test.opts)
+			testy.StatusError(t, test.err, test.status, err)
+		})
+	}
+}
+
+func TestViewQueryRequest(t *testing.T) {
+	opts, err := NewViewQuery().StartKey([]interface{}{"a", 1}).Reduce(false).Partition("p1").Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newCustomDB(func(req *http.Request) (*http.Response, error) {
+		expected := "/testdb/_partition/p1/_design/foo/_view/bar?reduce=false&startkey=%5B%22a%22%2C1%5D"
+		if req.URL.RequestURI() != expected {
+			t.Errorf("Unexpected request URI: %s", req.URL.RequestURI())
+		}
+		return jsonResponse(http.StatusOK, `{"rows":[]}`), nil
+	})
+	rows, err := db.Query(context.Background(), "foo", "bar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+}